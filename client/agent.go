@@ -0,0 +1,283 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief agent runner calling local tools in ReAct loop
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultAgentInstruction ReAct格式说明, 第一个%s为工具说明, 第二个%s为工具名列表
+	DefaultAgentInstruction = `尽可能回答用户的问题, 可以使用以下工具:
+
+%s
+
+使用以下格式:
+
+Thought: 思考下一步做什么
+Action: 要使用的工具, 必须是[%s]之一
+Action Input: 工具的JSON参数
+Observation: 工具返回的结果
+... (Thought/Action/Action Input/Observation可以重复多次)
+Thought: 我已经知道最终答案
+Final Answer: 对原始问题的最终回答`
+	DefaultAgentMaxSteps = 8
+	ObservationPrefix    = "Observation:"
+)
+
+var ErrAgentMaxSteps = errors.New("Agent reached max steps without final answer")
+
+var (
+	finalAnswerPattern = regexp.MustCompile(`(?m)^\s*Final Answer\s*[:：]`)
+	actionPattern      = regexp.MustCompile(`(?m)^\s*Action\s*[:：][ \t]*(.*)$`)
+	actionInputPattern = regexp.MustCompile(`(?m)^\s*Action Input\s*[:：]`)
+	thoughtPattern     = regexp.MustCompile(`(?m)^\s*Thought\s*[:：]`)
+	observationPattern = regexp.MustCompile(`(?m)^\s*Observation\s*[:：]`)
+)
+
+// AgentAction 从模型输出中解析出的下一步动作, Call为nil时Answer为最终回答
+type AgentAction struct {
+	Thought string    `json:"Thought,omitempty"`
+	Call    *ToolCall `json:"Call,omitempty"`
+	Answer  string    `json:"Answer,omitempty"`
+	// Text 去掉模型自行编造的Observation之后的输出, 作为assistant消息加入上下文
+	Text string `json:"Text,omitempty"`
+}
+
+func (a AgentAction) String() string {
+	return tea.Prettify(a)
+}
+
+func (a AgentAction) GoString() string {
+	return a.String()
+}
+
+// ParseAgentAction 解析ReAct格式的输出; 同时包含Action和Final Answer时以先出现的为准,
+// 都没有时整段输出视为最终回答
+func ParseAgentAction(text string) AgentAction {
+	//模型可能在Action之后自行编造Observation, 之后的内容丢弃
+	if loc := observationPattern.FindStringIndex(text); loc != nil {
+		text = text[:loc[0]]
+	}
+	text = strings.TrimSpace(text)
+	action := AgentAction{Text: text}
+
+	final := finalAnswerPattern.FindStringIndex(text)
+	act := actionPattern.FindStringSubmatchIndex(text)
+	if act != nil && (final == nil || act[0] < final[0]) {
+		action.Thought = thoughtBefore(text, act[0])
+		call := &ToolCall{Name: strings.TrimSpace(text[act[2]:act[3]])}
+		if loc := actionInputPattern.FindStringIndex(text[act[1]:]); loc != nil {
+			input := strings.TrimSpace(text[act[1]+loc[1]:])
+			if extracted, err := ExtractJSON(input); err == nil && strings.HasPrefix(input, extracted[:1]) {
+				input = extracted
+			}
+			call.Input = input
+		}
+
+		//部分模型输出的工具名带有方括号或反引号
+		call.Name = strings.Trim(call.Name, "[]`")
+		action.Call = call
+		return action
+	}
+
+	if final != nil {
+		action.Thought = thoughtBefore(text, final[0])
+		action.Answer = strings.TrimSpace(text[final[1]:])
+		return action
+	}
+
+	action.Answer = text
+	return action
+}
+
+func thoughtBefore(text string, end int) string {
+	loc := thoughtPattern.FindStringIndex(text[:end])
+	if loc == nil {
+		return strings.TrimSpace(text[:end])
+	}
+	return strings.TrimSpace(text[loc[1]:end])
+}
+
+// AgentStep 一次工具调用的记录
+type AgentStep struct {
+	Thought     string   `json:"Thought,omitempty"`
+	Call        ToolCall `json:"Call"`
+	Observation string   `json:"Observation"`
+	Err         error    `json:"-"`
+}
+
+func (s AgentStep) String() string {
+	return tea.Prettify(s)
+}
+
+func (s AgentStep) GoString() string {
+	return s.String()
+}
+
+// AgentResult Agent运行结果, Response为最后一次调用的响应
+type AgentResult struct {
+	Answer   string                  `json:"Answer"`
+	Steps    []AgentStep             `json:"Steps,omitempty"`
+	Messages []ChatCompletionMessage `json:"Messages,omitempty"`
+	Response *CompletionResponse     `json:"-"`
+}
+
+func (r AgentResult) String() string {
+	return tea.Prettify(r)
+}
+
+func (r AgentResult) GoString() string {
+	return r.String()
+}
+
+// AgentRunner 在对话中向模型声明本地工具, 执行模型要求的工具调用并将结果反馈给模型,
+// 直到得到最终回答或达到步数上限. 工具调用来自应用返回的Thoughts中未执行的api动作, 或ReAct格式的输出
+type AgentRunner struct {
+	Client *CompletionClient
+	Tools  *ToolRegistry
+	// MaxSteps 最多调用工具的次数, 为0时使用DefaultAgentMaxSteps
+	MaxSteps int
+	// Instruction 格式说明模板, 为空时使用DefaultAgentInstruction
+	Instruction string
+	// ObservationRole 反馈工具结果的消息角色, 为空时使用user, 应用支持tool角色时可设置为RoleTool
+	ObservationRole Role
+	// OnStep 每次工具调用后回调
+	OnStep func(step AgentStep)
+}
+
+// Run 运行Agent, 不修改传入的request; 达到步数上限时返回ErrAgentMaxSteps和已执行的步骤
+func (ar *AgentRunner) Run(ctx context.Context, request *CompletionRequest) (*AgentResult, error) {
+	maxSteps := ar.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = DefaultAgentMaxSteps
+	}
+
+	current := ar.prepare(request)
+	result := &AgentResult{}
+	for step := 0; ; step++ {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		response, err := ar.Client.CreateCompletionWithContext(ctx, copyRequest(current))
+		if err != nil {
+			return result, err
+		}
+
+		result.Response = response
+		if err = response.Err(); err != nil {
+			return result, err
+		}
+
+		action := ar.nextAction(response)
+		if action.Call == nil {
+			result.Answer = action.Answer
+			result.Messages = append(current.Messages, ChatCompletionMessage{Role: RoleAssistant, Content: action.Text})
+			return result, nil
+		}
+
+		if step >= maxSteps {
+			result.Messages = current.Messages
+			return result, fmt.Errorf("%w: %d", ErrAgentMaxSteps, maxSteps)
+		}
+
+		agentStep := AgentStep{Thought: action.Thought, Call: *action.Call}
+		agentStep.Observation, agentStep.Err = ar.Tools.Call(ctx, *action.Call)
+		if agentStep.Err != nil {
+			//错误作为Observation反馈给模型, 由模型决定重试或换用其他工具
+			agentStep.Observation = "Error: " + agentStep.Err.Error()
+		}
+
+		result.Steps = append(result.Steps, agentStep)
+		if ar.OnStep != nil {
+			ar.OnStep(agentStep)
+		}
+
+		role := ar.ObservationRole
+		if role == "" {
+			role = RoleUser
+		}
+
+		current.Messages = append(current.Messages,
+			ChatCompletionMessage{Role: RoleAssistant, Content: action.Text},
+			ChatCompletionMessage{Role: role, Content: ObservationPrefix + " " + agentStep.Observation})
+	}
+}
+
+// prepare 复制请求并转换为Messages格式, 在system消息中声明工具, 添加Observation停止词
+func (ar *AgentRunner) prepare(request *CompletionRequest) *CompletionRequest {
+	result := copyRequest(request)
+	if len(result.Messages) == 0 {
+		result.Messages = HistoryToMessages("", result.Prompt, result.History)
+		result.Prompt, result.History = "", nil
+	}
+
+	var names []string
+	for _, tool := range ar.Tools.Tools() {
+		names = append(names, tool.Name)
+	}
+
+	instruction := ar.Instruction
+	if instruction == "" {
+		instruction = DefaultAgentInstruction
+	}
+	result = withInstruction(result, fmt.Sprintf(instruction, ar.Tools.Describe(), strings.Join(names, ", ")))
+
+	parameters := CompletionRequestModelParameter{}
+	if result.Parameters != nil {
+		parameters = *result.Parameters
+	}
+
+	stop := append([]string(nil), parameters.Stop...)
+	if !containsString(stop, ObservationPrefix) {
+		stop = append(stop, ObservationPrefix)
+	}
+	parameters.Stop = stop
+	result.Parameters = &parameters
+	return result
+}
+
+// nextAction 优先使用Thoughts中未执行且在本地注册的api动作, 否则解析输出文本
+func (ar *AgentRunner) nextAction(response *CompletionResponse) AgentAction {
+	if response.Data != nil {
+		for _, thought := range response.Data.Thoughts {
+			if thought.ActionType != ActionTypeApi || thought.Observation != "" {
+				continue
+			}
+
+			if _, ok := ar.Tools.Get(thought.ActionName); ok {
+				text := fmt.Sprintf("Thought: %s\nAction: %s\nAction Input: %s",
+					thought.Thought, thought.ActionName, thought.ActionInput)
+				return AgentAction{
+					Thought: thought.Thought,
+					Call:    &ToolCall{Name: thought.ActionName, Input: thought.ActionInput},
+					Text:    text,
+				}
+			}
+		}
+	}
+
+	return ParseAgentAction(response.OutputText())
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
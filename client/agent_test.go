@@ -0,0 +1,226 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for tools and agent runner
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"testing"
+	"time"
+)
+
+type weatherInput struct {
+	City string `json:"city" desc:"城市名称"`
+}
+
+func weatherTool(t *testing.T) *client.Tool {
+	schema, err := client.SchemaOf(weatherInput{})
+	if err != nil {
+		t.Fatalf("failed to derive schema: %v", err)
+	}
+
+	return &client.Tool{
+		Name:        "weather",
+		Description: "查询城市天气",
+		Parameters:  schema,
+		Handler: func(ctx context.Context, input json.RawMessage) (string, error) {
+			params := weatherInput{}
+			if err := json.Unmarshal(input, &params); err != nil {
+				return "", err
+			}
+			return params.City + "晴, 25度", nil
+		},
+	}
+}
+
+func newToolRegistry(t *testing.T) *client.ToolRegistry {
+	registry, err := client.NewToolRegistry(
+		weatherTool(t),
+		&client.Tool{
+			Name:        "slow",
+			Description: "耗时操作",
+			Timeout:     20 * time.Millisecond,
+			Handler: func(ctx context.Context, input json.RawMessage) (string, error) {
+				<-ctx.Done()
+				return "", ctx.Err()
+			},
+		},
+		&client.Tool{
+			Name:        "broken",
+			Description: "总是panic",
+			Handler: func(ctx context.Context, input json.RawMessage) (string, error) {
+				panic("boom")
+			},
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create registry: %v", err)
+	}
+	return registry
+}
+
+func TestToolRegistryCall(t *testing.T) {
+	registry := newToolRegistry(t)
+	ctx := context.Background()
+
+	output, err := registry.Call(ctx, client.ToolCall{Name: "weather", Input: `{"city": "杭州"}`})
+	if err != nil || output != "杭州晴, 25度" {
+		t.Errorf("unexpected output: %s, err: %v", output, err)
+	}
+
+	if _, err = registry.Call(ctx, client.ToolCall{Name: "weather", Input: `{"town": "杭州"}`}); err == nil ||
+		!strings.Contains(err.Error(), `missing required field "city"`) {
+		t.Errorf("expected validation error, got: %v", err)
+	}
+
+	if _, err = registry.Call(ctx, client.ToolCall{Name: "slow"}); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("expected timeout error, got: %v", err)
+	}
+
+	if _, err = registry.Call(ctx, client.ToolCall{Name: "broken"}); err == nil || !strings.Contains(err.Error(), "panic: boom") {
+		t.Errorf("expected panic error, got: %v", err)
+	}
+
+	if _, err = registry.Call(ctx, client.ToolCall{Name: "search"}); !errors.Is(err, client.ErrToolNotFound) {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+
+	if err = registry.Register(weatherTool(t)); err == nil {
+		t.Errorf("expected duplicated tool error")
+	}
+}
+
+func TestParseAgentAction(t *testing.T) {
+	action := client.ParseAgentAction("Thought: 需要查询天气\nAction: weather\nAction Input: {\"city\": \"杭州\"}\nObservation: 杭州下雨")
+	if action.Call == nil || action.Call.Name != "weather" || action.Call.Input != `{"city": "杭州"}` || action.Thought != "需要查询天气" {
+		t.Fatalf("unexpected action: %s", action)
+	}
+
+	if strings.Contains(action.Text, "Observation") {
+		t.Errorf("hallucinated observation should be removed: %s", action.Text)
+	}
+
+	action = client.ParseAgentAction("Thought: 我已经知道最终答案\nFinal Answer：杭州今天晴")
+	if action.Call != nil || action.Answer != "杭州今天晴" {
+		t.Errorf("unexpected final action: %s", action)
+	}
+
+	action = client.ParseAgentAction("你好, 有什么可以帮你?")
+	if action.Call != nil || action.Answer != "你好, 有什么可以帮你?" {
+		t.Errorf("unexpected plain action: %s", action)
+	}
+}
+
+func TestAgentRunner(t *testing.T) {
+	outputs := []string{
+		"Thought: 需要查询天气\nAction: weather\nAction Input: {\"city\": \"杭州\"}",
+		"Thought: 我已经知道最终答案\nFinal Answer: 杭州今天晴, 25度",
+	}
+
+	var server *mockCompletionServer
+	server = newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{messageResponse(outputs[server.requestCount()-1], client.FinishReasonStop)}
+	})
+
+	var steps []client.AgentStep
+	runner := &client.AgentRunner{
+		Client: server.client(),
+		Tools:  newToolRegistry(t),
+		OnStep: func(step client.AgentStep) { steps = append(steps, step) },
+	}
+
+	request := &client.CompletionRequest{AppId: "app", Prompt: "杭州天气怎么样"}
+	result, err := runner.Run(context.Background(), request)
+	if err != nil {
+		t.Fatalf("failed to run agent: %v", err)
+	}
+
+	if result.Answer != "杭州今天晴, 25度" || len(result.Steps) != 1 || len(steps) != 1 || steps[0].Observation != "杭州晴, 25度" {
+		t.Errorf("unexpected result: %s", result)
+	}
+
+	last := server.lastRequest()
+	if len(last.Messages) != 4 || last.Prompt != "" {
+		t.Fatalf("unexpected request: %s", last)
+	}
+
+	if system := last.Messages[0].Content; !strings.Contains(system, "weather: 查询城市天气") || !strings.Contains(system, "[broken, slow, weather]") {
+		t.Errorf("tools should be declared in system message: %s", system)
+	}
+
+	if observation := last.Messages[3]; observation.Role != client.RoleUser || observation.Content != "Observation: 杭州晴, 25度" {
+		t.Errorf("unexpected observation: %s", observation)
+	}
+
+	if stop := last.Parameters.Stop; len(stop) != 1 || stop[0] != client.ObservationPrefix {
+		t.Errorf("unexpected stop words: %v", stop)
+	}
+
+	if request.Prompt != "杭州天气怎么样" || request.Parameters != nil {
+		t.Errorf("request should not be modified: %s", request)
+	}
+}
+
+func TestAgentRunnerThoughtsAndMaxSteps(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		response := textResponse("")
+		response.Data.Thoughts = []client.CompletionResponseDataThought{
+			{ActionType: client.ActionTypeApi, ActionName: "search", ActionInput: "{}", Observation: "已由应用执行"},
+			{Thought: "查天气", ActionType: client.ActionTypeApi, ActionName: "weather", ActionInput: `{"city": "北京"}`},
+		}
+		return []*client.CompletionResponse{response}
+	})
+
+	runner := &client.AgentRunner{
+		Client:          server.client(),
+		Tools:           newToolRegistry(t),
+		MaxSteps:        2,
+		ObservationRole: client.RoleTool,
+	}
+
+	result, err := runner.Run(context.Background(), &client.CompletionRequest{AppId: "app", Prompt: "北京天气"})
+	if !errors.Is(err, client.ErrAgentMaxSteps) {
+		t.Fatalf("expected max steps error, got: %v", err)
+	}
+
+	if server.requestCount() != 3 || len(result.Steps) != 2 || result.Steps[1].Call.Name != "weather" || result.Steps[1].Thought != "查天气" {
+		t.Errorf("unexpected result: %s", result)
+	}
+
+	if observation := server.lastRequest().Messages[3]; observation.Role != client.RoleTool || observation.Content != "Observation: 北京晴, 25度" {
+		t.Errorf("unexpected observation: %s", observation)
+	}
+}
+
+func TestAgentRunnerCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		<-release
+		return []*client.CompletionResponse{textResponse("Final Answer: 晴")}
+	})
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	runner := &client.AgentRunner{Client: server.client(), Tools: newToolRegistry(t)}
+	start := time.Now()
+	if _, err := runner.Run(ctx, &client.CompletionRequest{AppId: "app", Prompt: "天气"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got: %v", err)
+	}
+
+	//取消ctx后立即中断正在进行的模型调用
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("run should abort in-flight call, elapsed: %v", elapsed)
+	}
+}
@@ -0,0 +1,366 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief concurrent batch completion executor
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"context"
+	"errors"
+	"github.com/alibabacloud-go/tea/tea"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBatchConcurrency   = 4
+	DefaultBatchMaxRetries    = 2
+	DefaultBatchRetryInterval = time.Second
+)
+
+// BatchResult 单个请求的结果, Index为请求在输入中的序号
+type BatchResult struct {
+	Index    int                 `json:"Index"`
+	Request  *CompletionRequest  `json:"Request"`
+	Response *CompletionResponse `json:"Response,omitempty"`
+	// Err 请求失败或服务端返回Success为false时的错误
+	Err      error         `json:"-"`
+	Error    string        `json:"Error,omitempty"`
+	Attempts int           `json:"Attempts"`
+	Latency  time.Duration `json:"Latency"`
+}
+
+func (r BatchResult) String() string {
+	return tea.Prettify(r)
+}
+
+func (r BatchResult) GoString() string {
+	return r.String()
+}
+
+// BatchProgress 批量执行进度, 通过通道输入时Total为0
+type BatchProgress struct {
+	Done    int                         `json:"Done"`
+	Failed  int                         `json:"Failed"`
+	Total   int                         `json:"Total"`
+	Retries int                         `json:"Retries"`
+	Usage   CompletionResponseDataUsage `json:"Usage"`
+}
+
+func (p BatchProgress) String() string {
+	return tea.Prettify(p)
+}
+
+func (p BatchProgress) GoString() string {
+	return p.String()
+}
+
+// BatchSummary 批量执行结果, Results与输入顺序一致
+type BatchSummary struct {
+	Results  []BatchResult `json:"Results"`
+	Progress BatchProgress `json:"Progress"`
+}
+
+func (s BatchSummary) String() string {
+	return tea.Prettify(s)
+}
+
+func (s BatchSummary) GoString() string {
+	return s.String()
+}
+
+// BatchExecutor 以有限的并发和速率批量调用CreateCompletion, 失败的请求按指数退避重试
+type BatchExecutor struct {
+	Client *CompletionClient
+	// Concurrency 并发请求数, 为0时使用DefaultBatchConcurrency
+	Concurrency int
+	// RateLimit 每秒最多发起的请求数, 包含重试, 为0时不限制
+	RateLimit float64
+	// MaxRetries 每个请求的最大重试次数, 为0时使用DefaultBatchMaxRetries, 小于0时不重试
+	MaxRetries int
+	// RetryInterval 首次重试间隔, 之后每次翻倍, 为0时使用DefaultBatchRetryInterval
+	RetryInterval time.Duration
+	// OnProgress 每完成一个请求时回调, 回调不会并发执行
+	OnProgress func(progress BatchProgress)
+	// OnResult 每完成一个请求时按完成顺序回调, 回调不会并发执行
+	OnResult func(result BatchResult)
+}
+
+type batchItem struct {
+	index   int
+	request *CompletionRequest
+}
+
+// Run 执行全部请求, ctx取消后未执行的请求Err为ctx.Err(), 同时返回ctx.Err()
+func (b *BatchExecutor) Run(ctx context.Context, requests []*CompletionRequest) (*BatchSummary, error) {
+	summary := &BatchSummary{Results: make([]BatchResult, len(requests))}
+	for i, request := range requests {
+		summary.Results[i] = BatchResult{Index: i, Request: request}
+	}
+
+	items := make(chan batchItem)
+	go func() {
+		defer close(items)
+		for i, request := range requests {
+			select {
+			case items <- batchItem{index: i, request: request}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	progress := b.execute(ctx, items, len(requests), func(result BatchResult) {
+		summary.Results[result.Index] = result
+	})
+	summary.Progress = progress
+
+	if err := ctx.Err(); err != nil {
+		for i := range summary.Results {
+			if summary.Results[i].Response == nil && summary.Results[i].Err == nil {
+				summary.Results[i].Err, summary.Results[i].Error = err, err.Error()
+			}
+		}
+		return summary, err
+	}
+	return summary, nil
+}
+
+// RunChannel 执行通道中的请求, 按输入顺序输出结果, 输入通道关闭且全部完成或ctx取消后关闭输出通道
+func (b *BatchExecutor) RunChannel(ctx context.Context, requests <-chan *CompletionRequest) <-chan BatchResult {
+	items := make(chan batchItem)
+	go func() {
+		defer close(items)
+		index := 0
+		for {
+			select {
+			case request, ok := <-requests:
+				if !ok {
+					return
+				}
+
+				select {
+				case items <- batchItem{index: index, request: request}:
+					index++
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	out := make(chan BatchResult)
+	go func() {
+		defer close(out)
+
+		//结果按完成顺序到达, 缓存到前序结果都输出后再按序输出
+		var mutex sync.Mutex
+		cond := sync.NewCond(&mutex)
+		pending := make(map[int]BatchResult)
+		finished := false
+
+		go func() {
+			b.execute(ctx, items, 0, func(result BatchResult) {
+				mutex.Lock()
+				pending[result.Index] = result
+				mutex.Unlock()
+				cond.Signal()
+			})
+
+			mutex.Lock()
+			finished = true
+			mutex.Unlock()
+			cond.Signal()
+		}()
+
+		next := 0
+		mutex.Lock()
+		defer mutex.Unlock()
+		for {
+			result, ok := pending[next]
+			if ok {
+				delete(pending, next)
+				next++
+				mutex.Unlock()
+				select {
+				case out <- result:
+				case <-ctx.Done():
+				}
+				mutex.Lock()
+				continue
+			}
+
+			if finished {
+				return
+			}
+			cond.Wait()
+		}
+	}()
+	return out
+}
+
+// execute 并发执行items, 每完成一个请求调用collect, 返回最终进度
+func (b *BatchExecutor) execute(ctx context.Context, items <-chan batchItem, total int, collect func(result BatchResult)) BatchProgress {
+	concurrency := b.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	limiter := newRateLimiter(b.RateLimit)
+	progress := BatchProgress{Total: total}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				result := b.complete(ctx, limiter, item)
+
+				mutex.Lock()
+				progress.Done++
+				progress.Retries += result.Attempts - 1
+				if result.Err != nil {
+					progress.Failed++
+				} else {
+					usage := result.Response.TotalUsage()
+					progress.Usage.InputTokens += usage.InputTokens
+					progress.Usage.OutputTokens += usage.OutputTokens
+				}
+
+				collect(result)
+				if b.OnResult != nil {
+					b.OnResult(result)
+				}
+
+				if b.OnProgress != nil {
+					b.OnProgress(progress)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return progress
+}
+
+// complete 执行单个请求, 每次尝试使用请求的副本, 调用方未设置RequestId时每次生成新的RequestId
+func (b *BatchExecutor) complete(ctx context.Context, limiter *rateLimiter, item batchItem) BatchResult {
+	maxRetries := b.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultBatchMaxRetries
+	}
+
+	interval := b.RetryInterval
+	if interval <= 0 {
+		interval = DefaultBatchRetryInterval
+	}
+
+	result := BatchResult{Index: item.index, Request: item.request}
+	start := time.Now()
+	for {
+		result.Attempts++
+		err := limiter.wait(ctx)
+		if err == nil {
+			var response *CompletionResponse
+			response, err = b.Client.CreateCompletionWithContext(ctx, copyRequest(item.request))
+			if err == nil {
+				err = response.Err()
+			}
+			result.Response = response
+		}
+
+		if err == nil {
+			result.Latency = time.Since(start)
+			return result
+		}
+
+		if result.Attempts > maxRetries || !isTemporary(ctx, err) {
+			result.Err, result.Error, result.Latency = err, err.Error(), time.Since(start)
+			return result
+		}
+
+		select {
+		case <-time.After(interval << uint(result.Attempts-1)):
+		case <-ctx.Done():
+			result.Err, result.Error, result.Latency = ctx.Err(), ctx.Err().Error(), time.Since(start)
+			return result
+		}
+	}
+}
+
+// isTemporary 服务端返回的错误仅在限流或服务端错误时重试, 网络错误和超时重试, 其他错误如解析失败不重试, ctx取消后不重试;
+// BatchExecutor和BulkEmbedder使用相同的规则
+func isTemporary(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.Temporary()
+	}
+
+	var embeddingErr *EmbeddingError
+	if errors.As(err, &embeddingErr) {
+		return embeddingErr.Temporary()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// rateLimiter 按固定间隔发放请求配额
+type rateLimiter struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	next     time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return ctx.Err()
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
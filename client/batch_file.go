@@ -0,0 +1,309 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief resumable jsonl batch file runner
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxBatchLineSize 输入文件单行的最大字节数
+const maxBatchLineSize = 16 * 1024 * 1024
+
+var ErrNoRenderer = errors.New("Batch row has vars but no renderer is configured")
+
+// BatchInputRow 输入文件中的一行, Request和Vars都为空时整行按CompletionRequest解析
+type BatchInputRow struct {
+	CustomId string             `json:"custom_id"`
+	Request  *CompletionRequest `json:"request,omitempty"`
+	// Vars 模板变量, 由BatchFileRunner.Render生成请求
+	Vars map[string]interface{} `json:"vars,omitempty"`
+}
+
+func (r BatchInputRow) String() string {
+	return tea.Prettify(r)
+}
+
+func (r BatchInputRow) GoString() string {
+	return r.String()
+}
+
+// BatchOutputRow 输出文件中的一行, 同一custom_id出现多次时以最后一行为准
+type BatchOutputRow struct {
+	CustomId  string                       `json:"custom_id"`
+	Response  *CompletionResponse          `json:"response,omitempty"`
+	Usage     *CompletionResponseDataUsage `json:"usage,omitempty"`
+	Error     string                       `json:"error,omitempty"`
+	LatencyMs int64                        `json:"latency_ms"`
+	Attempts  int                          `json:"attempts"`
+}
+
+func (r BatchOutputRow) String() string {
+	return tea.Prettify(r)
+}
+
+func (r BatchOutputRow) GoString() string {
+	return r.String()
+}
+
+// BatchFileSummary 文件批量执行结果
+type BatchFileSummary struct {
+	Total     int                         `json:"Total"`
+	Skipped   int                         `json:"Skipped"`
+	Succeeded int                         `json:"Succeeded"`
+	Failed    int                         `json:"Failed"`
+	Usage     CompletionResponseDataUsage `json:"Usage"`
+}
+
+func (s BatchFileSummary) String() string {
+	return tea.Prettify(s)
+}
+
+func (s BatchFileSummary) GoString() string {
+	return s.String()
+}
+
+// BatchFileRunner 读取JSONL输入文件, 使用BatchExecutor执行并按完成顺序追加写入JSONL输出文件.
+// 重新运行时跳过输出文件中已成功的custom_id, 失败的行会重新执行; 没有custom_id的行使用"line-行号"
+type BatchFileRunner struct {
+	Executor *BatchExecutor
+	// AppId 请求未设置AppId时使用的默认值
+	AppId string
+	// Render 将模板变量渲染为请求, 如使用prompt.Engine.Apply, 输入包含vars时必须设置
+	Render func(vars map[string]interface{}) (*CompletionRequest, error)
+}
+
+// Run 执行inputPath中尚未完成的行, 结果追加到outputPath
+func (r *BatchFileRunner) Run(ctx context.Context, inputPath string, outputPath string) (*BatchFileSummary, error) {
+	completed, err := loadCompletedIds(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	output, err := os.OpenFile(outputPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	if err = terminateLastLine(output); err != nil {
+		return nil, err
+	}
+
+	summary := &BatchFileSummary{}
+	var mutex sync.Mutex
+	var ids []string
+	var writeErr error
+
+	//按完成顺序写入, 进程中断时已完成的结果不会丢失
+	write := func(row *BatchOutputRow) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if row.Error == "" {
+			summary.Succeeded++
+			if row.Usage != nil {
+				summary.Usage.InputTokens += row.Usage.InputTokens
+				summary.Usage.OutputTokens += row.Usage.OutputTokens
+			}
+		} else {
+			summary.Failed++
+		}
+
+		data, err := json.Marshal(row)
+		if err == nil {
+			_, err = output.Write(append(data, '\n'))
+		}
+
+		if err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	executor := *r.Executor
+	onResult := executor.OnResult
+	executor.OnResult = func(result BatchResult) {
+		mutex.Lock()
+		row := &BatchOutputRow{CustomId: ids[result.Index], Response: result.Response, Error: result.Error,
+			LatencyMs: result.Latency.Milliseconds(), Attempts: result.Attempts}
+		mutex.Unlock()
+
+		if result.Response != nil && result.Err == nil {
+			usage := result.Response.TotalUsage()
+			row.Usage = &usage
+		}
+		write(row)
+
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+
+	requests := make(chan *CompletionRequest)
+	readDone := make(chan error, 1)
+	go func() {
+		defer close(requests)
+		var readErr error
+		defer func() { readDone <- readErr }()
+		readErr = r.read(ctx, input, func(customId string, request *CompletionRequest, err error) bool {
+			mutex.Lock()
+			summary.Total++
+			if completed[customId] {
+				summary.Skipped++
+				mutex.Unlock()
+				return true
+			}
+			completed[customId] = true
+
+			if err != nil {
+				mutex.Unlock()
+				write(&BatchOutputRow{CustomId: customId, Error: err.Error()})
+				return true
+			}
+			ids = append(ids, customId)
+			mutex.Unlock()
+
+			select {
+			case requests <- request:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	for range executor.RunChannel(ctx, requests) {
+	}
+
+	if readErr := <-readDone; readErr != nil {
+		return summary, readErr
+	}
+
+	if writeErr != nil {
+		return summary, writeErr
+	}
+	return summary, ctx.Err()
+}
+
+// read 逐行解析输入, handle返回false时停止
+func (r *BatchFileRunner) read(ctx context.Context, input io.Reader, handle func(customId string, request *CompletionRequest, err error) bool) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxBatchLineSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		row := BatchInputRow{}
+		err := json.Unmarshal(line, &row)
+		if row.CustomId == "" {
+			row.CustomId = fmt.Sprintf("line-%d", lineNo)
+		}
+
+		var request *CompletionRequest
+		if err == nil {
+			request, err = r.request(&row, line)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Invalid batch row at line %d: %v", lineNo, err)
+		}
+
+		if !handle(row.CustomId, request, err) || ctx.Err() != nil {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *BatchFileRunner) request(row *BatchInputRow, line []byte) (*CompletionRequest, error) {
+	request := row.Request
+	if request == nil && row.Vars != nil {
+		if r.Render == nil {
+			return nil, ErrNoRenderer
+		}
+
+		var err error
+		if request, err = r.Render(row.Vars); err != nil {
+			return nil, err
+		}
+	}
+
+	if request == nil {
+		request = &CompletionRequest{}
+		if err := json.Unmarshal(line, request); err != nil {
+			return nil, err
+		}
+	}
+
+	if request.AppId == "" {
+		request.AppId = r.AppId
+	}
+	return request, nil
+}
+
+// loadCompletedIds 读取输出文件中已成功的custom_id, 文件不存在时返回空集合
+func loadCompletedIds(path string) (map[string]bool, error) {
+	completed := make(map[string]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return completed, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxBatchLineSize)
+	for scanner.Scan() {
+		row := BatchOutputRow{}
+		//进程中断时最后一行可能不完整, 跳过无法解析的行
+		if err = json.Unmarshal(scanner.Bytes(), &row); err != nil || row.CustomId == "" {
+			continue
+		}
+		completed[row.CustomId] = row.Error == ""
+	}
+	return completed, scanner.Err()
+}
+
+// terminateLastLine 进程中断时最后一行可能不完整, 补充换行避免与追加的结果合并为一行
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err = file.ReadAt(last, info.Size()-1); err != nil || last[0] == '\n' {
+		return err
+	}
+
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
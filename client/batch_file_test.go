@@ -0,0 +1,125 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for jsonl batch file runner
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readBatchOutput(t *testing.T, path string) map[string]client.BatchOutputRow {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open output: %v", err)
+	}
+	defer file.Close()
+
+	rows := make(map[string]client.BatchOutputRow)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		row := client.BatchOutputRow{}
+		if err = json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			continue
+		}
+		rows[row.CustomId] = row
+	}
+	return rows
+}
+
+func TestBatchFileRunner(t *testing.T) {
+	server := newMockCompletionServer(t, batchHandler())
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input.jsonl")
+	outputPath := filepath.Join(dir, "output.jsonl")
+
+	lines := []string{
+		`{"custom_id": "a", "request": {"AppId": "app", "Prompt": "p1"}}`,
+		`{"custom_id": "b", "AppId": "app", "Prompt": "p2"}`,
+		`{"custom_id": "c", "vars": {"name": "张三"}}`,
+		`{"custom_id": "d", "request": {"AppId": "app", "Prompt": "bad"}}`,
+		``,
+		`{"AppId": "app", "Prompt": "p5"}`,
+		`{"custom_id": "g", "request": `,
+	}
+	if err := ioutil.WriteFile(inputPath, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	//模拟上次运行中断: a已完成, d失败, 最后一行写入不完整
+	previous := `{"custom_id": "a", "response": {"Success": true}, "latency_ms": 5, "attempts": 1}` + "\n" +
+		`{"custom_id": "d", "error": "timeout", "latency_ms": 5, "attempts": 3}` + "\n" +
+		`{"custom_id": "b", "resp`
+	if err := ioutil.WriteFile(outputPath, []byte(previous), 0644); err != nil {
+		t.Fatalf("failed to write output: %v", err)
+	}
+
+	runner := &client.BatchFileRunner{
+		Executor: &client.BatchExecutor{Client: server.client(), RetryInterval: time.Millisecond},
+		Render: func(vars map[string]interface{}) (*client.CompletionRequest, error) {
+			return &client.CompletionRequest{AppId: "app", Prompt: fmt.Sprintf("hello %v", vars["name"])}, nil
+		},
+	}
+
+	summary, err := runner.Run(context.Background(), inputPath, outputPath)
+	if err != nil {
+		t.Fatalf("failed to run batch file: %v", err)
+	}
+
+	if summary.Total != 6 || summary.Skipped != 1 || summary.Succeeded != 3 || summary.Failed != 2 ||
+		summary.Usage.InputTokens != 6 || server.requestCount() != 4 {
+		t.Errorf("unexpected summary: %s, requests: %d", summary, server.requestCount())
+	}
+
+	rows := readBatchOutput(t, outputPath)
+	if rows["b"].Response.Data.Text != "echo: p2" || rows["c"].Response.Data.Text != "echo: hello 张三" ||
+		rows["line-6"].Response.Data.Text != "echo: p5" || rows["b"].Usage.OutputTokens != 3 {
+		t.Errorf("unexpected output: %v", rows)
+	}
+
+	if rows["d"].Error == "timeout" || !strings.Contains(rows["d"].Error, "InvalidParameter") ||
+		!strings.Contains(rows["line-7"].Error, "line 7") {
+		t.Errorf("unexpected errors: %s, %s", rows["d"], rows["line-7"])
+	}
+
+	//再次运行时只重试失败的行
+	summary, err = runner.Run(context.Background(), inputPath, outputPath)
+	if err != nil || summary.Skipped != 4 || summary.Failed != 2 || server.requestCount() != 5 {
+		t.Errorf("unexpected resumed summary: %s, err: %v", summary, err)
+	}
+}
+
+func TestBatchFileRunnerWithoutRenderer(t *testing.T) {
+	server := newMockCompletionServer(t, batchHandler())
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input.jsonl")
+	outputPath := filepath.Join(dir, "output.jsonl")
+	if err := ioutil.WriteFile(inputPath, []byte(`{"custom_id": "a", "vars": {"name": "x"}}`), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	runner := &client.BatchFileRunner{Executor: &client.BatchExecutor{Client: server.client()}}
+	summary, err := runner.Run(context.Background(), inputPath, outputPath)
+	if err != nil || summary.Failed != 1 || server.requestCount() != 0 {
+		t.Errorf("unexpected summary: %s, err: %v", summary, err)
+	}
+
+	if row := readBatchOutput(t, outputPath)["a"]; !strings.Contains(row.Error, client.ErrNoRenderer.Error()) {
+		t.Errorf("unexpected output: %s", row)
+	}
+}
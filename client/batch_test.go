@@ -0,0 +1,206 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for batch completion executor
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchHandler 返回"echo: 提示词", 提示词为retry时第一次返回限流错误, 为bad时返回参数错误
+func batchHandler() func(request *client.CompletionRequest) []*client.CompletionResponse {
+	var mutex sync.Mutex
+	throttled := false
+	return func(request *client.CompletionRequest) []*client.CompletionResponse {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if request.Prompt == "bad" || (request.Prompt == "retry" && !throttled) {
+			throttled = true
+			code := "InvalidParameter"
+			if request.Prompt == "retry" {
+				code = "Throttling.User"
+			}
+			return []*client.CompletionResponse{{Success: false, Code: code, RequestId: request.RequestId}}
+		}
+
+		response := textResponse("echo: " + request.Prompt)
+		response.Data.Usage = []client.CompletionResponseDataUsage{{InputTokens: 2, OutputTokens: 3}}
+		return []*client.CompletionResponse{response}
+	}
+}
+
+func TestBatchExecutorRun(t *testing.T) {
+	server := newMockCompletionServer(t, batchHandler())
+
+	var requests []*client.CompletionRequest
+	for i := 0; i < 10; i++ {
+		requests = append(requests, &client.CompletionRequest{AppId: "app", Prompt: fmt.Sprintf("p%d", i)})
+	}
+	requests[3].Prompt, requests[7].Prompt = "retry", "bad"
+
+	var progresses []client.BatchProgress
+	executor := &client.BatchExecutor{
+		Client:        server.client(),
+		Concurrency:   3,
+		RetryInterval: time.Millisecond,
+		OnProgress: func(progress client.BatchProgress) {
+			progresses = append(progresses, progress)
+		},
+	}
+
+	summary, err := executor.Run(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("failed to run batch: %v", err)
+	}
+
+	for i, result := range summary.Results {
+		if result.Index != i || result.Request != requests[i] {
+			t.Fatalf("unexpected result order: %s", result)
+		}
+
+		if i != 7 && (result.Err != nil || result.Response.Data.Text != "echo: "+requests[i].Prompt) {
+			t.Errorf("unexpected result: %s", result)
+		}
+	}
+
+	var responseErr *client.ResponseError
+	if failed := summary.Results[7]; !errors.As(failed.Err, &responseErr) || responseErr.Code != "InvalidParameter" || failed.Attempts != 1 {
+		t.Errorf("expected invalid parameter without retry, got: %s", failed)
+	}
+
+	progress := summary.Progress
+	if summary.Results[3].Attempts != 2 || progress.Done != 10 || progress.Failed != 1 || progress.Retries != 1 ||
+		progress.Usage.InputTokens != 18 || progress.Usage.OutputTokens != 27 || len(progresses) != 10 {
+		t.Errorf("unexpected progress: %s", progress)
+	}
+
+	//请求未设置RequestId时不修改调用方的请求
+	if requests[0].RequestId != "" {
+		t.Errorf("request modified: %s", requests[0])
+	}
+}
+
+func TestBatchExecutorChannel(t *testing.T) {
+	server := newMockCompletionServer(t, batchHandler())
+	executor := &client.BatchExecutor{Client: server.client(), Concurrency: 4, RateLimit: 200}
+
+	requests := make(chan *client.CompletionRequest)
+	go func() {
+		defer close(requests)
+		for i := 0; i < 20; i++ {
+			requests <- &client.CompletionRequest{AppId: "app", Prompt: fmt.Sprintf("p%d", i)}
+		}
+	}()
+
+	start := time.Now()
+	index := 0
+	for result := range executor.RunChannel(context.Background(), requests) {
+		if result.Index != index || result.Response.Data.Text != fmt.Sprintf("echo: p%d", index) {
+			t.Fatalf("unexpected result: %s", result)
+		}
+		index++
+	}
+
+	//每秒200个请求, 20个请求至少需要95ms
+	if index != 20 || time.Since(start) < 90*time.Millisecond {
+		t.Errorf("unexpected results: %d, elapsed: %v", index, time.Since(start))
+	}
+}
+
+func TestBatchExecutorCancel(t *testing.T) {
+	server := newMockCompletionServer(t, batchHandler())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	executor := &client.BatchExecutor{
+		Client:      server.client(),
+		Concurrency: 1,
+		OnResult: func(result client.BatchResult) {
+			cancel()
+		},
+	}
+
+	requests := []*client.CompletionRequest{{AppId: "app", Prompt: "a"}, {AppId: "app", Prompt: "b"}, {AppId: "app", Prompt: "c"}}
+	summary, err := executor.Run(ctx, requests)
+	if !errors.Is(err, context.Canceled) || summary.Results[0].Err != nil || !errors.Is(summary.Results[2].Err, context.Canceled) {
+		t.Errorf("unexpected cancel result: %s, err: %v", summary, err)
+	}
+}
+
+func TestBatchExecutorRetryableErrors(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		_, _ = w.Write([]byte("not json"))
+	}))
+
+	executor := &client.BatchExecutor{Client: &client.CompletionClient{Endpoint: server.URL}, MaxRetries: 2, RetryInterval: time.Millisecond}
+	summary, _ := executor.Run(context.Background(), []*client.CompletionRequest{{AppId: "app", Prompt: "p1"}})
+	if summary.Results[0].Err == nil || summary.Results[0].Attempts != 1 || requests != 1 {
+		t.Errorf("invalid response should not be retried: %s, requests: %d", summary, requests)
+	}
+
+	//连接失败的网络错误重试
+	server.Close()
+	summary, _ = executor.Run(context.Background(), []*client.CompletionRequest{{AppId: "app", Prompt: "p1"}})
+	if summary.Results[0].Err == nil || summary.Results[0].Attempts != 3 {
+		t.Errorf("network error should be retried: %s", summary)
+	}
+}
+
+func TestBatchExecutorTokenProvider(t *testing.T) {
+	var mutex sync.Mutex
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(textResponse("ok"))
+	}))
+	defer server.Close()
+
+	//模拟长时间运行时Token过期后刷新
+	calls := 0
+	completionClient := &client.CompletionClient{Token: "ignored", Endpoint: server.URL, TokenProvider: func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		return fmt.Sprintf("token-%d", (calls+1)/2), nil
+	}}
+
+	requests := []*client.CompletionRequest{{AppId: "app", Prompt: "p1"}, {AppId: "app", Prompt: "p2"}, {AppId: "app", Prompt: "p3"}}
+	executor := &client.BatchExecutor{Client: completionClient, Concurrency: 1}
+	if _, err := executor.Run(context.Background(), requests); err != nil {
+		t.Fatalf("failed to run batch: %v", err)
+	}
+
+	if strings.Join(authorizations, ",") != "Bearer token-1,Bearer token-1,Bearer token-2" {
+		t.Errorf("unexpected authorizations: %v", authorizations)
+	}
+
+	completionClient.TokenProvider = func() (string, error) {
+		return "", errors.New("expired")
+	}
+	if _, err := completionClient.CreateCompletion(&client.CompletionRequest{AppId: "app", Prompt: "p4"}); err == nil || err.Error() != "expired" {
+		t.Errorf("expected token error, got: %v", err)
+	}
+}
@@ -0,0 +1,424 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief citations linking answer markers to doc references
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// CitationFormat 引用标记的渲染格式
+type CitationFormat string
+
+const (
+	// CitationFormatText 渲染为[1]
+	CitationFormatText CitationFormat = "text"
+	// CitationFormatMarkdown 渲染为Markdown脚注[^1]
+	CitationFormatMarkdown CitationFormat = "markdown"
+	// CitationFormatHTML 渲染为指向引用列表的上标链接, 正文按HTML转义
+	CitationFormatHTML CitationFormat = "html"
+	// CitationFormatNone 删除引用标记
+	CitationFormatNone CitationFormat = "none"
+)
+
+var (
+	//DocReferenceType为indexed时的引用标记, 如<ref>[1]</ref>、<ref>[1][2]</ref>、<ref>[1,2]</ref>
+	refMarkerPattern = regexp.MustCompile(`^<ref>\s*((?:\[\s*\d+(?:\s*,\s*\d+)*\s*\]\s*)+)</ref>`)
+	//没有ref标签的引用标记, 仅当编号存在于DocReferences中时视为引用
+	bareMarkerPattern = regexp.MustCompile(`^\[(\d+(?:\s*,\s*\d+)*)\]`)
+	markerIdPattern   = regexp.MustCompile(`\d+`)
+)
+
+// Citation 被引用的文档, 多个引用片段属于同一文档时合并为一条
+type Citation struct {
+	// Number 按在回答中首次出现的顺序从1开始编号
+	Number    int                                `json:"Number"`
+	Reference CompletionResponseDataDocReference `json:"Reference"`
+	// IndexIds 合并到该文档的引用编号
+	IndexIds []string `json:"IndexIds"`
+	// Texts 被引用的文档片段
+	Texts []string `json:"Texts,omitempty"`
+}
+
+func (c Citation) String() string {
+	return tea.Prettify(c)
+}
+
+func (c Citation) GoString() string {
+	return c.String()
+}
+
+type citationSegment struct {
+	text    string
+	numbers []int
+}
+
+// CitedAnswer 解析引用标记后的回答
+type CitedAnswer struct {
+	Citations []Citation `json:"Citations"`
+	// Unused 返回了但未在回答中引用的文档
+	Unused []CompletionResponseDataDocReference `json:"Unused,omitempty"`
+
+	segments []citationSegment
+}
+
+func (a CitedAnswer) String() string {
+	return tea.Prettify(a)
+}
+
+func (a CitedAnswer) GoString() string {
+	return a.String()
+}
+
+// citationIndex 将引用编号映射为去重后的文档编号
+type citationIndex struct {
+	refs      map[string]CompletionResponseDataDocReference
+	order     []string
+	byDoc     map[string]*Citation
+	byIndex   map[string]*Citation
+	citations []*Citation
+}
+
+func newCitationIndex(refs []CompletionResponseDataDocReference) *citationIndex {
+	index := &citationIndex{
+		refs:    make(map[string]CompletionResponseDataDocReference),
+		byDoc:   make(map[string]*Citation),
+		byIndex: make(map[string]*Citation),
+	}
+	index.addReferences(refs)
+	return index
+}
+
+func (ci *citationIndex) addReferences(refs []CompletionResponseDataDocReference) {
+	for _, ref := range refs {
+		if _, ok := ci.refs[ref.IndexId]; !ok {
+			ci.order = append(ci.order, ref.IndexId)
+		}
+		ci.refs[ref.IndexId] = ref
+	}
+}
+
+// cite 返回引用编号对应的文档编号, 首次引用时分配编号
+func (ci *citationIndex) cite(indexId string) int {
+	if citation, ok := ci.byIndex[indexId]; ok {
+		return citation.Number
+	}
+
+	ref, ok := ci.refs[indexId]
+	if !ok {
+		ref = CompletionResponseDataDocReference{IndexId: indexId}
+	}
+
+	key := docKey(ref)
+	citation, ok := ci.byDoc[key]
+	if !ok {
+		citation = &Citation{Number: len(ci.citations) + 1, Reference: ref}
+		ci.byDoc[key] = citation
+		ci.citations = append(ci.citations, citation)
+	}
+
+	citation.IndexIds = append(citation.IndexIds, indexId)
+	if ref.Text != "" && !containsString(citation.Texts, ref.Text) {
+		citation.Texts = append(citation.Texts, ref.Text)
+	}
+	ci.byIndex[indexId] = citation
+	return citation.Number
+}
+
+// docKey 同一文档的不同片段使用相同的key
+func docKey(ref CompletionResponseDataDocReference) string {
+	switch {
+	case ref.DocId != "":
+		return "id:" + ref.DocId
+	case ref.DocUrl != "":
+		return "url:" + ref.DocUrl
+	case ref.DocName != "" || ref.Title != "":
+		return "name:" + ref.DocName + "\x00" + ref.Title
+	}
+	return "index:" + ref.IndexId
+}
+
+// marker 返回文本开头的引用标记长度和其中的引用编号, 不是引用标记时返回-1
+func (ci *citationIndex) marker(text string) (int, []string) {
+	if loc := refMarkerPattern.FindStringSubmatchIndex(text); loc != nil {
+		return loc[1], markerIdPattern.FindAllString(text[loc[2]:loc[3]], -1)
+	}
+
+	if loc := bareMarkerPattern.FindStringSubmatchIndex(text); loc != nil {
+		ids := markerIdPattern.FindAllString(text[loc[2]:loc[3]], -1)
+		for _, id := range ids {
+			if _, ok := ci.refs[id]; !ok {
+				return -1, nil
+			}
+		}
+		return loc[1], ids
+	}
+	return -1, nil
+}
+
+// parse 将文本切分为正文和引用标记
+func (ci *citationIndex) parse(text string) []citationSegment {
+	var segments []citationSegment
+	last := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] != '<' && text[i] != '[' {
+			continue
+		}
+
+		end, ids := ci.marker(text[i:])
+		if end < 0 {
+			continue
+		}
+
+		segment := citationSegment{text: text[last:i]}
+		for _, id := range ids {
+			number := ci.cite(id)
+			if !containsInt(segment.numbers, number) {
+				segment.numbers = append(segment.numbers, number)
+			}
+		}
+		segments = append(segments, segment)
+		i += end - 1
+		last = i + 1
+	}
+
+	if last < len(text) {
+		segments = append(segments, citationSegment{text: text[last:]})
+	}
+	return segments
+}
+
+func (ci *citationIndex) answer(segments []citationSegment) *CitedAnswer {
+	answer := &CitedAnswer{Citations: make([]Citation, 0, len(ci.citations)), segments: segments}
+	for _, citation := range ci.citations {
+		answer.Citations = append(answer.Citations, *citation)
+	}
+
+	for _, id := range ci.order {
+		ref := ci.refs[id]
+		if _, ok := ci.byIndex[id]; !ok {
+			if _, ok := ci.byDoc[docKey(ref)]; !ok {
+				answer.Unused = append(answer.Unused, ref)
+			}
+		}
+	}
+	return answer
+}
+
+// ParseCitations 解析回答中的引用标记并关联到DocReferences, 同一文档的多个片段合并为一条引用
+func ParseCitations(text string, refs []CompletionResponseDataDocReference) *CitedAnswer {
+	index := newCitationIndex(refs)
+	return index.answer(index.parse(text))
+}
+
+// Citations 解析响应中的引用
+func (cr *CompletionResponse) Citations() *CitedAnswer {
+	if cr == nil || cr.Data == nil {
+		return ParseCitations(cr.OutputText(), nil)
+	}
+	return ParseCitations(cr.OutputText(), cr.Data.DocReferences)
+}
+
+// Render 按格式渲染正文, 不包含引用列表
+func (a *CitedAnswer) Render(format CitationFormat) string {
+	var builder strings.Builder
+	for _, segment := range a.segments {
+		if format == CitationFormatHTML {
+			builder.WriteString(html.EscapeString(segment.text))
+		} else {
+			builder.WriteString(segment.text)
+		}
+		builder.WriteString(renderMarkers(segment.numbers, format))
+	}
+	return builder.String()
+}
+
+// Text 返回删除引用标记后的正文
+func (a *CitedAnswer) Text() string {
+	return a.Render(CitationFormatNone)
+}
+
+// PlainText 渲染为带[n]标记的纯文本, 末尾附引用列表
+func (a *CitedAnswer) PlainText() string {
+	text := a.Render(CitationFormatText)
+	if len(a.Citations) == 0 {
+		return text
+	}
+
+	lines := []string{text, "", "参考资料:"}
+	for _, citation := range a.Citations {
+		line := fmt.Sprintf("[%d] %s", citation.Number, citation.title())
+		if citation.Reference.DocUrl != "" {
+			line += " " + citation.Reference.DocUrl
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Markdown 渲染为带脚注的Markdown
+func (a *CitedAnswer) Markdown() string {
+	text := a.Render(CitationFormatMarkdown)
+	if len(a.Citations) == 0 {
+		return text
+	}
+
+	lines := []string{text, ""}
+	for _, citation := range a.Citations {
+		title := escapeMarkdownLink(citation.title())
+		if citation.Reference.DocUrl != "" {
+			title = fmt.Sprintf("[%s](%s)", title, citation.Reference.DocUrl)
+		}
+		lines = append(lines, fmt.Sprintf("[^%d]: %s", citation.Number, title))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HTML 渲染为HTML, 引用标记为上标链接, 末尾附引用列表
+func (a *CitedAnswer) HTML() string {
+	text := a.Render(CitationFormatHTML)
+	if len(a.Citations) == 0 {
+		return text
+	}
+
+	var builder strings.Builder
+	builder.WriteString(text)
+	builder.WriteString("\n<ol class=\"citations\">\n")
+	for _, citation := range a.Citations {
+		title := html.EscapeString(citation.title())
+		if citation.Reference.DocUrl != "" {
+			title = fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(citation.Reference.DocUrl), title)
+		}
+		builder.WriteString(fmt.Sprintf("<li id=\"ref-%d\">%s</li>\n", citation.Number, title))
+	}
+	builder.WriteString("</ol>")
+	return builder.String()
+}
+
+func (c *Citation) title() string {
+	switch {
+	case c.Reference.Title != "":
+		return c.Reference.Title
+	case c.Reference.DocName != "":
+		return c.Reference.DocName
+	}
+	return "文档" + strings.Join(c.IndexIds, ",")
+}
+
+func renderMarkers(numbers []int, format CitationFormat) string {
+	var builder strings.Builder
+	for _, number := range numbers {
+		switch format {
+		case CitationFormatMarkdown:
+			builder.WriteString(fmt.Sprintf("[^%d]", number))
+		case CitationFormatHTML:
+			builder.WriteString(fmt.Sprintf("<sup><a href=\"#ref-%d\">[%d]</a></sup>", number, number))
+		case CitationFormatNone:
+		default:
+			builder.WriteString(fmt.Sprintf("[%d]", number))
+		}
+	}
+	return builder.String()
+}
+
+func escapeMarkdownLink(text string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(text)
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CitationStream 在流式输出中实时替换引用标记: 可能是未完整标记的尾部内容会暂缓输出, 直到能够判断.
+// DocReferences到达之前, 文档去重只能按引用编号进行, 流结束后以Result为准
+type CitationStream struct {
+	Format CitationFormat
+
+	index   *citationIndex
+	raw     string
+	emitted int
+}
+
+func NewCitationStream(format CitationFormat) *CitationStream {
+	return &CitationStream{Format: format, index: newCitationIndex(nil)}
+}
+
+// Add 处理一个流式响应, 返回可以输出的内容; 兼容增量输出和累积输出
+func (s *CitationStream) Add(response *CompletionResponse) string {
+	if response.Data != nil {
+		s.index.addReferences(response.Data.DocReferences)
+	}
+
+	text := response.OutputText()
+	if strings.HasPrefix(text, s.raw) {
+		s.raw = text
+	} else {
+		s.raw += text
+	}
+	return s.flush(false)
+}
+
+// Close 输出剩余内容
+func (s *CitationStream) Close() string {
+	return s.flush(true)
+}
+
+// Result 返回按完整输出解析的引用
+func (s *CitationStream) Result() *CitedAnswer {
+	refs := make([]CompletionResponseDataDocReference, 0, len(s.index.order))
+	for _, id := range s.index.order {
+		refs = append(refs, s.index.refs[id])
+	}
+	return ParseCitations(s.raw, refs)
+}
+
+func (s *CitationStream) flush(final bool) string {
+	end := len(s.raw)
+	if !final {
+		end = s.emitted + pendingMarkerStart(s.raw[s.emitted:])
+	}
+
+	if end <= s.emitted {
+		return ""
+	}
+
+	answer := &CitedAnswer{segments: s.index.parse(s.raw[s.emitted:end])}
+	s.emitted = end
+	return answer.Render(s.Format)
+}
+
+// pendingMarkerStart 返回文本末尾可能是未完整引用标记的起始位置, 没有时返回文本长度
+func pendingMarkerStart(text string) int {
+	if i := strings.LastIndex(text, "<"); i >= 0 {
+		tail := text[i:]
+		if strings.HasPrefix("<ref>", tail) || (strings.HasPrefix(tail, "<ref>") && !strings.Contains(tail, "</ref>")) {
+			return i
+		}
+		//</ref>的前缀
+		if j := strings.LastIndex(text, "<ref>"); j >= 0 && strings.HasPrefix("</ref>", tail) {
+			return j
+		}
+	}
+
+	if i := strings.LastIndex(text, "["); i >= 0 && !strings.Contains(text[i:], "]") {
+		if strings.Trim(text[i+1:], "0123456789, ") == "" {
+			return i
+		}
+	}
+	return len(text)
+}
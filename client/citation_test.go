@@ -0,0 +1,104 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for citations
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"testing"
+)
+
+func docReferences() []client.CompletionResponseDataDocReference {
+	return []client.CompletionResponseDataDocReference{
+		{IndexId: "1", DocId: "d1", Title: "退货政策", DocUrl: "https://example.com/return?a=1&b=2", Text: "7天无理由退货"},
+		{IndexId: "2", DocId: "d2", Title: "运费说明", Text: "退货运费由买家承担"},
+		{IndexId: "3", DocId: "d1", Title: "退货政策", Text: "定制商品不支持退货"},
+		{IndexId: "4", DocId: "d3", Title: "发票"},
+	}
+}
+
+func TestParseCitations(t *testing.T) {
+	text := "支持7天无理由退货<ref>[1]</ref>, 定制商品除外<ref>[3][2]</ref>。数组[5]不是引用, [2]是引用。"
+	answer := client.ParseCitations(text, docReferences())
+
+	if len(answer.Citations) != 2 || len(answer.Unused) != 1 || answer.Unused[0].IndexId != "4" {
+		t.Fatalf("unexpected citations: %s", answer)
+	}
+
+	first := answer.Citations[0]
+	if first.Number != 1 || strings.Join(first.IndexIds, ",") != "1,3" || len(first.Texts) != 2 {
+		t.Errorf("unexpected citation: %s", first)
+	}
+
+	if text := answer.Text(); text != "支持7天无理由退货, 定制商品除外。数组[5]不是引用, 是引用。" {
+		t.Errorf("unexpected text: %s", text)
+	}
+
+	expected := "支持7天无理由退货[1], 定制商品除外[1][2]。数组[5]不是引用, [2]是引用。\n\n参考资料:\n" +
+		"[1] 退货政策 https://example.com/return?a=1&b=2\n[2] 运费说明"
+	if plain := answer.PlainText(); plain != expected {
+		t.Errorf("unexpected plain text:\n%s", plain)
+	}
+
+	expected = "支持7天无理由退货[^1], 定制商品除外[^1][^2]。数组[5]不是引用, [^2]是引用。\n\n" +
+		"[^1]: [退货政策](https://example.com/return?a=1&b=2)\n[^2]: 运费说明"
+	if markdown := answer.Markdown(); markdown != expected {
+		t.Errorf("unexpected markdown:\n%s", markdown)
+	}
+
+	html := client.ParseCitations("A<B<ref>[1]</ref>", docReferences()).HTML()
+	expected = "A&lt;B<sup><a href=\"#ref-1\">[1]</a></sup>\n<ol class=\"citations\">\n" +
+		"<li id=\"ref-1\"><a href=\"https://example.com/return?a=1&amp;b=2\">退货政策</a></li>\n</ol>"
+	if html != expected {
+		t.Errorf("unexpected html:\n%s", html)
+	}
+}
+
+func TestCitationStream(t *testing.T) {
+	chunks := []string{"支持退货<re", "f>[1]</r", "ef>, 运费自理[", "2]。", "完"}
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		var responses []*client.CompletionResponse
+		for i, chunk := range chunks {
+			response := textResponse(chunk)
+			if i == 0 {
+				response.Data.DocReferences = docReferences()
+			}
+			responses = append(responses, response)
+		}
+		return responses
+	})
+
+	request := &client.CompletionRequest{
+		AppId:            "app",
+		Prompt:           "怎么退货",
+		DocReferenceType: client.DocReferenceTypeIndexed,
+		Parameters:       &client.CompletionRequestModelParameter{IncrementalOutput: true},
+	}
+	ch, err := server.client().CreateStreamCompletion(request)
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	stream := client.NewCitationStream(client.CitationFormatMarkdown)
+	var outputs []string
+	for response := range ch {
+		outputs = append(outputs, stream.Add(response))
+	}
+	outputs = append(outputs, stream.Close())
+
+	if joined := strings.Join(outputs, "|"); joined != "支持退货||[^1], 运费自理|[^2]。|完|" {
+		t.Errorf("unexpected outputs: %s", joined)
+	}
+
+	result := stream.Result()
+	if len(result.Citations) != 2 || result.Text() != "支持退货, 运费自理。完" {
+		t.Errorf("unexpected result: %s", result)
+	}
+}
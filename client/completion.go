@@ -14,6 +14,7 @@ package broadscope_bailian
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -36,6 +37,9 @@ const (
 	DocReferenceTypeIndexed      = "indexed"
 )
 
+// ErrorCodeStreamInterrupted 流式响应读取失败时, ReadStream输出的最后一个失败片段的Code
+const ErrorCodeStreamInterrupted = "StreamInterrupted"
+
 var (
 	SSEEventData  = []byte("data: ")
 	SSEEventError = []byte(`data: {"error":`)
@@ -80,6 +84,19 @@ func (c *AccessTokenClient) GetToken() (_token string, _err error) {
 	return *c.TokenData.Token, nil
 }
 
+// newAPIClient 使用AccessKey创建OpenAPI客户端, Endpoint为空时使用默认地址
+func (c *AccessTokenClient) newAPIClient() (*client.Client, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = BroadscopeBailianPopEndpoint
+	}
+
+	config := &openapi.Config{AccessKeyId: &c.AccessKeyId,
+		AccessKeySecret: &c.AccessKeySecret,
+		Endpoint:        &endpoint}
+	return client.NewClient(config)
+}
+
 func (c *AccessTokenClient) CreateToken() (_result *client.CreateTokenResponseBodyData, _err error) {
 	if c.Endpoint == "" {
 		c.Endpoint = BroadscopeBailianPopEndpoint
@@ -129,7 +146,7 @@ func (c ChatQaMessage) GoString() string {
 }
 
 type ChatCompletionMessage struct {
-	Role    string `json:"Role"`
+	Role    Role   `json:"Role"`
 	Content string `json:"Content"`
 }
 
@@ -156,6 +173,8 @@ type CompletionRequest struct {
 	Parameters       *CompletionRequestModelParameter `json:"Parameters,omitempty"`
 	DocTagIds        []int64                          `json:"DocTagIds,omitempty"`
 	DocTagCodes      []string                         `json:"DocTagCodes,omitempty"`
+	//调用侧元数据, 不发送到服务端, 原样带回到CompletionResponse.Metadata
+	Metadata map[string]string `json:"-"`
 }
 
 func (cr CompletionRequest) String() string {
@@ -167,14 +186,14 @@ func (cr CompletionRequest) GoString() string {
 }
 
 type CompletionRequestModelParameter struct {
-	TopK              int32    `json:"TopK,omitempty"`
-	Seed              int32    `json:"Seed,omitempty"`
-	UseRawPrompt      bool     `json:"UseRawPrompt,omitempty"`
-	Temperature       float32  `json:"Temperature,omitempty"`
-	MaxTokens         int32    `json:"MaxTokens,omitempty"`
-	ResultFormat      string   `json:"ResultFormat,omitempty"`
-	Stop              []string `json:"Stop,omitempty"`
-	IncrementalOutput bool     `json:"IncrementalOutput,omitempty"`
+	TopK              int32        `json:"TopK,omitempty"`
+	Seed              int32        `json:"Seed,omitempty"`
+	UseRawPrompt      bool         `json:"UseRawPrompt,omitempty"`
+	Temperature       float32      `json:"Temperature,omitempty"`
+	MaxTokens         int32        `json:"MaxTokens,omitempty"`
+	ResultFormat      ResultFormat `json:"ResultFormat,omitempty"`
+	Stop              []string     `json:"Stop,omitempty"`
+	IncrementalOutput bool         `json:"IncrementalOutput,omitempty"`
 }
 
 func (cp CompletionRequestModelParameter) String() string {
@@ -186,14 +205,14 @@ func (cp CompletionRequestModelParameter) GoString() string {
 }
 
 type CompletionResponseDataThought struct {
-	Thought           string `json:"Thought,omitempty"`
-	ActionType        string `json:"ActionType,omitempty"`
-	ActionName        string `json:"ActionName,omitempty"`
-	Action            string `json:"Action,omitempty"`
-	ActionInputStream string `json:"ActionInputStream,omitempty"`
-	ActionInput       string `json:"ActionInput,omitempty"`
-	Response          string `json:"Response,omitempty"`
-	Observation       string `json:"Observation,omitempty"`
+	Thought           string     `json:"Thought,omitempty"`
+	ActionType        ActionType `json:"ActionType,omitempty"`
+	ActionName        string     `json:"ActionName,omitempty"`
+	Action            string     `json:"Action,omitempty"`
+	ActionInputStream string     `json:"ActionInputStream,omitempty"`
+	ActionInput       string     `json:"ActionInput,omitempty"`
+	Response          string     `json:"Response,omitempty"`
+	Observation       string     `json:"Observation,omitempty"`
 }
 
 func (ct CompletionResponseDataThought) String() string {
@@ -237,7 +256,7 @@ func (cu CompletionResponseDataUsage) GoString() string {
 }
 
 type CompletionResponseMessage struct {
-	Role    string `json:"Role"`
+	Role    Role   `json:"Role"`
 	Content string `json:"Content"`
 }
 
@@ -250,7 +269,7 @@ func (cm CompletionResponseMessage) GoString() string {
 }
 
 type CompletionResponseChoice struct {
-	FinishReason string                     `json:"FinishReason,omitempty"`
+	FinishReason FinishReason               `json:"FinishReason,omitempty"`
 	Message      *CompletionResponseMessage `json:"Message"`
 }
 
@@ -286,6 +305,10 @@ type CompletionResponse struct {
 	Message   string                  `json:"Message,omitempty"`
 	RequestId string                  `json:"RequestId,omitempty"`
 	Data      *CompletionResponseData `json:"Data,omitempty"`
+	//对应请求的调用侧元数据
+	Metadata map[string]string `json:"-"`
+	//响应来自CompletionClient.Cache, 未调用服务端
+	Cached bool `json:"-"`
 }
 
 func (cr CompletionResponse) String() string {
@@ -300,6 +323,14 @@ type CompletionClient struct {
 	Token    string
 	Endpoint string
 	Timeout  time.Duration
+	//发送请求前裁剪历史上下文, 为nil时不做处理
+	HistoryStrategy HistoryStrategy `json:"-"`
+	//应用接收上下文的格式, key为AppId, 发送请求前自动转换Messages或Prompt+History
+	AppMessageFormats map[string]MessageFormat `json:"-"`
+	//响应缓存, 命中时不调用服务端, 为nil时不缓存
+	Cache CompletionCache `json:"-"`
+	//每次请求前获取Token, 设置后忽略Token字段, 长时间运行时用于在Token过期前刷新; 可能被并发调用
+	TokenProvider func() (string, error) `json:"-"`
 }
 
 func (cc CompletionClient) String() string {
@@ -324,6 +355,17 @@ func (cc *CompletionClient) CreateCompletionRequest(request *CompletionRequest,
 		request.Stream = stream
 	}
 
+	if format, ok := cc.AppMessageFormats[request.AppId]; ok {
+		AdaptRequest(request, format)
+	}
+
+	if cc.HistoryStrategy != nil {
+		err := cc.HistoryStrategy.Trim(request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	url := fmt.Sprintf("%s/v2/app/completions", cc.Endpoint)
 	data, err := json.Marshal(*request)
 	if err != nil {
@@ -335,7 +377,13 @@ func (cc *CompletionClient) CreateCompletionRequest(request *CompletionRequest,
 		return nil, err
 	}
 
-	authorization := fmt.Sprintf("Bearer %s", cc.Token)
+	token := cc.Token
+	if cc.TokenProvider != nil {
+		if token, err = cc.TokenProvider(); err != nil {
+			return nil, err
+		}
+	}
+	authorization := fmt.Sprintf("Bearer %s", token)
 
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	req.Header.Set("Authorization", authorization)
@@ -348,13 +396,24 @@ func (cc *CompletionClient) CreateCompletionRequest(request *CompletionRequest,
 }
 
 func (cc *CompletionClient) CreateCompletion(request *CompletionRequest) (_response *CompletionResponse, _err error) {
+	return cc.CreateCompletionWithContext(context.Background(), request)
+}
+
+// CreateCompletionWithContext 同CreateCompletion, ctx取消时中断请求
+func (cc *CompletionClient) CreateCompletionWithContext(ctx context.Context, request *CompletionRequest) (_response *CompletionResponse, _err error) {
+	//发送前会修改请求, 缓存使用调用方传入时的请求
+	cacheRequest, cached := cc.lookupCache(request)
+	if cached != nil {
+		return cached, nil
+	}
+
 	req, err := cc.CreateCompletionRequest(request, false)
 	if err != nil {
 		return nil, err
 	}
 
 	httpClient := &http.Client{Timeout: cc.Timeout}
-	resp, err := httpClient.Do(req)
+	resp, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -371,8 +430,7 @@ func (cc *CompletionClient) CreateCompletion(request *CompletionRequest) (_respo
 	}
 
 	if resp.StatusCode != 200 {
-		errMessage := fmt.Sprintf("Failed to complete request, code: %d, message: %s", resp.StatusCode, string(body))
-		return nil, errors.New(errMessage)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	response := &CompletionResponse{}
@@ -381,9 +439,12 @@ func (cc *CompletionClient) CreateCompletion(request *CompletionRequest) (_respo
 		return nil, err
 	}
 
+	response.Metadata = request.Metadata
+	cc.storeCache(cacheRequest, response)
 	return response, nil
 }
 
+// ReadStream 读取SSE流式响应, 读取失败时输出一个Code为ErrorCodeStreamInterrupted的失败片段后关闭通道
 func (cc *CompletionClient) ReadStream(response *http.Response) (chan *CompletionResponse, error) {
 	ch := make(chan *CompletionResponse)
 	reader := bufio.NewReader(response.Body)
@@ -400,6 +461,7 @@ func (cc *CompletionClient) ReadStream(response *http.Response) (chan *Completio
 
 			if err != nil {
 				log.Printf("failed to read line, err: %v\n", err)
+				ch <- &CompletionResponse{Code: ErrorCodeStreamInterrupted, Message: err.Error()}
 				return
 			}
 
@@ -433,6 +495,12 @@ func (cc *CompletionClient) ReadStream(response *http.Response) (chan *Completio
 }
 
 func (cc *CompletionClient) CreateStreamCompletion(request *CompletionRequest) (_response chan *CompletionResponse, _err error) {
+	incremental := request.Parameters != nil && request.Parameters.IncrementalOutput
+	cacheRequest, cached := cc.lookupCache(request)
+	if cached != nil {
+		return replayStream(cached, incremental), nil
+	}
+
 	req, err := cc.CreateCompletionRequest(request, true)
 	if err != nil {
 		return nil, err
@@ -453,8 +521,7 @@ func (cc *CompletionClient) CreateStreamCompletion(request *CompletionRequest) (
 			return nil, err
 		}
 
-		errMessage := fmt.Sprintf("Failed to complete request, code: %d, message: %s", resp.StatusCode, string(body))
-		return nil, errors.New(errMessage)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	result, err := cc.ReadStream(resp)
@@ -462,5 +529,26 @@ func (cc *CompletionClient) CreateStreamCompletion(request *CompletionRequest) (
 		return nil, err
 	}
 
-	return result, nil
+	if len(request.Metadata) == 0 && cacheRequest == nil {
+		return result, nil
+	}
+
+	ch := make(chan *CompletionResponse)
+	go func() {
+		defer close(ch)
+		var responses []*CompletionResponse
+		for response := range result {
+			response.Metadata = request.Metadata
+			if cacheRequest != nil {
+				responses = append(responses, copyResponse(response))
+			}
+			ch <- response
+		}
+
+		if merged := mergeStream(responses, incremental); merged != nil {
+			cc.storeCache(cacheRequest, merged)
+		}
+	}()
+
+	return ch, nil
 }
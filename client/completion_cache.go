@@ -0,0 +1,411 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief completion response cache hooks
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/internal/fileutil"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// replayChunkRunes 回放缓存的流式响应时每个片段的字符数
+const replayChunkRunes = 16
+
+// CompletionCache 响应缓存, 设置在CompletionClient.Cache上时由CreateCompletion和CreateStreamCompletion自动查询和写入,
+// 流式调用命中时回放为流式响应. 缓存读写失败只记录日志, 不影响请求
+type CompletionCache interface {
+	// Lookup 返回缓存的响应, 未命中时返回nil
+	Lookup(request *CompletionRequest) (*CompletionResponse, error)
+	// Store 写入成功的响应
+	Store(request *CompletionRequest, response *CompletionResponse) error
+}
+
+// lookupCache 查询缓存, 未命中时返回请求的副本用于写入缓存
+func (cc *CompletionClient) lookupCache(request *CompletionRequest) (*CompletionRequest, *CompletionResponse) {
+	if cc.Cache == nil {
+		return nil, nil
+	}
+
+	cached, err := cc.Cache.Lookup(request)
+	if err != nil {
+		log.Printf("failed to lookup completion cache, err: %v\n", err)
+	}
+
+	if cached != nil {
+		cached.Cached = true
+		cached.Metadata = request.Metadata
+		if cached.Data != nil {
+			cached.Data.SessionId = request.SessionId
+		}
+		return nil, cached
+	}
+	return copyRequest(request), nil
+}
+
+func (cc *CompletionClient) storeCache(request *CompletionRequest, response *CompletionResponse) {
+	if cc.Cache == nil || request == nil || !response.Success {
+		return
+	}
+
+	if err := cc.Cache.Store(request, response); err != nil {
+		log.Printf("failed to store completion cache, err: %v\n", err)
+	}
+}
+
+// copyResponse 深拷贝响应, 缓存中的响应不受调用方修改影响
+func copyResponse(response *CompletionResponse) *CompletionResponse {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil
+	}
+
+	result := &CompletionResponse{}
+	if err = json.Unmarshal(data, result); err != nil {
+		return nil
+	}
+	return result
+}
+
+// RequestHash 请求的规范化哈希, 包含AppId、Prompt、Messages、History、模型参数、业务参数和文档标签;
+// 不包含RequestId、SessionId、Stream和IncrementalOutput, 同一请求的流式和非流式调用共用缓存
+func RequestHash(request *CompletionRequest) string {
+	var parameters *CompletionRequestModelParameter
+	if request.Parameters != nil {
+		copied := *request.Parameters
+		copied.IncrementalOutput = false
+		parameters = &copied
+	}
+
+	//map按键排序序列化, 结果与键的插入顺序无关
+	data, _ := json.Marshal(map[string]interface{}{
+		"AppId":            request.AppId,
+		"Prompt":           request.Prompt,
+		"Messages":         request.Messages,
+		"History":          request.History,
+		"TopP":             request.TopP,
+		"HasThoughts":      request.HasThoughts,
+		"BizParams":        request.BizParams,
+		"DocReferenceType": request.DocReferenceType,
+		"Parameters":       parameters,
+		"DocTagIds":        request.DocTagIds,
+		"DocTagCodes":      request.DocTagCodes,
+	})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// completionCacheEntry 缓存条目, ExpiresAt为0时不过期
+type completionCacheEntry struct {
+	Response  *CompletionResponse `json:"Response"`
+	ExpiresAt int64               `json:"ExpiresAt,omitempty"`
+}
+
+func newCompletionCacheEntry(response *CompletionResponse, ttl time.Duration, now time.Time) *completionCacheEntry {
+	entry := &completionCacheEntry{Response: copyResponse(response)}
+	if ttl > 0 {
+		entry.ExpiresAt = now.Add(ttl).UnixNano()
+	}
+	return entry
+}
+
+func (e *completionCacheEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && now.UnixNano() >= e.ExpiresAt
+}
+
+// MemoryCompletionCache 按RequestHash精确匹配的进程内缓存, 适用于固定Seed等确定性的请求
+type MemoryCompletionCache struct {
+	// TTL 缓存有效期, 为0时不过期
+	TTL time.Duration
+	// Now 当前时间, 为nil时使用time.Now, 测试时可替换
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*completionCacheEntry
+}
+
+func NewMemoryCompletionCache(ttl time.Duration) *MemoryCompletionCache {
+	return &MemoryCompletionCache{TTL: ttl, entries: make(map[string]*completionCacheEntry)}
+}
+
+func (c *MemoryCompletionCache) String() string {
+	return fmt.Sprintf("MemoryCompletionCache{TTL: %v, Entries: %d}", c.TTL, c.Len())
+}
+
+func (c *MemoryCompletionCache) GoString() string {
+	return c.String()
+}
+
+func (c *MemoryCompletionCache) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (c *MemoryCompletionCache) Lookup(request *CompletionRequest) (*CompletionResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := RequestHash(request)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	}
+
+	if entry.expired(c.now()) {
+		delete(c.entries, key)
+		return nil, nil
+	}
+	return copyResponse(entry.Response), nil
+}
+
+func (c *MemoryCompletionCache) Store(request *CompletionRequest, response *CompletionResponse) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*completionCacheEntry)
+	}
+	c.entries[RequestHash(request)] = newCompletionCacheEntry(response, c.TTL, c.now())
+	return nil
+}
+
+// Delete 删除请求对应的缓存
+func (c *MemoryCompletionCache) Delete(request *CompletionRequest) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, RequestHash(request))
+}
+
+// Clear 清空缓存
+func (c *MemoryCompletionCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]*completionCacheEntry)
+}
+
+// Len 缓存的条目数, 包含尚未删除的过期条目
+func (c *MemoryCompletionCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.entries)
+}
+
+// FileCompletionCache 按RequestHash精确匹配的文件缓存, 每个响应保存为一个JSON文件, 可在多次运行之间复用
+type FileCompletionCache struct {
+	Dir string
+	// TTL 缓存有效期, 为0时不过期
+	TTL time.Duration
+	// Now 当前时间, 为nil时使用time.Now, 测试时可替换
+	Now func() time.Time
+}
+
+func NewFileCompletionCache(dir string, ttl time.Duration) (*FileCompletionCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCompletionCache{Dir: dir, TTL: ttl}, nil
+}
+
+func (c *FileCompletionCache) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (c *FileCompletionCache) path(request *CompletionRequest) string {
+	key := RequestHash(request)
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+// Lookup 过期的缓存文件在查询时删除
+func (c *FileCompletionCache) Lookup(request *CompletionRequest) (*CompletionResponse, error) {
+	path := c.path(request)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &completionCacheEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("Failed to parse completion cache %s: %v", path, err)
+	}
+
+	if entry.expired(c.now()) {
+		_ = os.Remove(path)
+		return nil, nil
+	}
+	return entry.Response, nil
+}
+
+func (c *FileCompletionCache) Store(request *CompletionRequest, response *CompletionResponse) error {
+	path := c.path(request)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	entry := newCompletionCacheEntry(response, c.TTL, c.now())
+	return fileutil.WriteFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(entry)
+	})
+}
+
+// Delete 删除请求对应的缓存
+func (c *FileCompletionCache) Delete(request *CompletionRequest) error {
+	err := os.Remove(c.path(request))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Clear 删除缓存目录下的所有文件, 保留目录本身
+func (c *FileCompletionCache) Clear() error {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err = os.RemoveAll(filepath.Join(c.Dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayStream 将缓存的响应按片段回放为流式响应, incremental为true时每个片段只包含新增的文本.
+// 中间片段只包含文本, 最后一个片段包含完整的引用、思考过程和用量
+func replayStream(response *CompletionResponse, incremental bool) chan *CompletionResponse {
+	runes := []rune(response.OutputText())
+	var chunks []*CompletionResponse
+	for start := 0; start+replayChunkRunes < len(runes); start += replayChunkRunes {
+		chunk := &CompletionResponse{
+			Success:   response.Success,
+			RequestId: response.RequestId,
+			Data:      &CompletionResponseData{ResponseId: response.Data.ResponseId, SessionId: response.Data.SessionId},
+			Metadata:  response.Metadata,
+			Cached:    true,
+		}
+
+		text := string(runes[:start+replayChunkRunes])
+		if incremental {
+			text = string(runes[start : start+replayChunkRunes])
+		}
+
+		if choice := response.FirstChoice(); choice != nil && choice.Message != nil {
+			message := &CompletionResponseMessage{Role: choice.Message.Role, Content: text}
+			chunk.Data.Choices = []CompletionResponseChoice{{FinishReason: FinishReasonNull, Message: message}}
+		} else {
+			chunk.Data.Text = text
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	last := copyResponse(response)
+	last.Metadata, last.Cached = response.Metadata, true
+	if incremental && len(chunks) > 0 {
+		text := string(runes[len(chunks)*replayChunkRunes:])
+		if choice := last.FirstChoice(); choice != nil && choice.Message != nil {
+			choice.Message.Content = text
+		} else {
+			last.Data.Text = text
+		}
+	}
+	chunks = append(chunks, last)
+
+	ch := make(chan *CompletionResponse, len(chunks))
+	for _, chunk := range chunks {
+		ch <- chunk
+	}
+	close(ch)
+	return ch
+}
+
+// mergeStream 将流式响应合并为一个完整响应用于写入缓存, 任一片段失败或最后一个片段未结束时返回nil.
+// 思考过程与ThoughtMerger一致按位置合并, 文档引用去重后合并
+func mergeStream(responses []*CompletionResponse, incremental bool) *CompletionResponse {
+	if len(responses) == 0 || !streamFinished(responses[len(responses)-1]) {
+		return nil
+	}
+
+	text := ""
+	merger := NewThoughtMerger()
+	var references []CompletionResponseDataDocReference
+	for _, response := range responses {
+		if response.Err() != nil || response.Data == nil {
+			return nil
+		}
+
+		if incremental {
+			text += response.OutputText()
+		} else {
+			text = response.OutputText()
+		}
+
+		merger.Add(response)
+		for _, reference := range response.Data.DocReferences {
+			if !containsReference(references, reference) {
+				references = append(references, reference)
+			}
+		}
+	}
+
+	merged := copyResponse(responses[len(responses)-1])
+	if choice := merged.FirstChoice(); choice != nil && choice.Message != nil {
+		choice.Message.Content = text
+	} else {
+		merged.Data.Text = text
+	}
+	merged.Data.Thoughts, merged.Data.DocReferences = merger.thoughts, references
+	return merged
+}
+
+// streamFinished 流式响应的最后一个片段是否表示生成结束; text格式没有FinishReason, 读取中断时由ReadStream输出失败片段
+func streamFinished(last *CompletionResponse) bool {
+	if last.Err() != nil {
+		return false
+	}
+
+	if choice := last.FirstChoice(); choice != nil {
+		return choice.FinishReason.IsFinished()
+	}
+	return true
+}
+
+func containsReference(references []CompletionResponseDataDocReference, reference CompletionResponseDataDocReference) bool {
+	for _, existing := range references {
+		if existing == reference {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for exact-match completion cache
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"encoding/json"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestHash(t *testing.T) {
+	request := &client.CompletionRequest{
+		RequestId:  "r1",
+		SessionId:  "s1",
+		AppId:      "app",
+		Prompt:     "你好",
+		BizParams:  map[string]interface{}{"a": 1, "b": "x"},
+		Parameters: &client.CompletionRequestModelParameter{Seed: 42, Temperature: 0.1},
+	}
+	same := &client.CompletionRequest{
+		RequestId:  "r2",
+		AppId:      "app",
+		Prompt:     "你好",
+		Stream:     true,
+		BizParams:  map[string]interface{}{"b": "x", "a": 1},
+		Parameters: &client.CompletionRequestModelParameter{Seed: 42, Temperature: 0.1, IncrementalOutput: true},
+	}
+	if client.RequestHash(request) != client.RequestHash(same) {
+		t.Errorf("expected same hash")
+	}
+
+	different := *same
+	different.Parameters = &client.CompletionRequestModelParameter{Seed: 43, Temperature: 0.1}
+	if client.RequestHash(request) == client.RequestHash(&different) {
+		t.Errorf("expected different hash for different seed")
+	}
+}
+
+func TestMemoryCompletionCache(t *testing.T) {
+	var server *mockCompletionServer
+	server = newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		if !request.Stream {
+			return []*client.CompletionResponse{textResponse("这是一段用于测试流式回放的比较长的回答文本, 共有三十多个字符。")}
+		}
+		return []*client.CompletionResponse{textResponse("流式"), textResponse("回答")}
+	})
+
+	now := time.Unix(1700000000, 0)
+	cache := client.NewMemoryCompletionCache(time.Hour)
+	cache.Now = func() time.Time { return now }
+	completionClient := server.client()
+	completionClient.Cache = cache
+
+	request := func() *client.CompletionRequest {
+		return &client.CompletionRequest{AppId: "app", Prompt: "你好", SessionId: "s1",
+			Parameters: &client.CompletionRequestModelParameter{Seed: 42, IncrementalOutput: true}}
+	}
+
+	first, _ := completionClient.CreateCompletion(request())
+	second, err := completionClient.CreateCompletion(request())
+	if err != nil || first.Cached || !second.Cached || second.Data.Text != first.Data.Text || server.requestCount() != 1 {
+		t.Fatalf("expected cached response, got: %v, err: %v", second, err)
+	}
+
+	//流式调用回放缓存, 增量输出时拼接后为完整文本
+	ch, err := completionClient.CreateStreamCompletion(request())
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	var chunks []string
+	for response := range ch {
+		if !response.Cached {
+			t.Errorf("expected cached chunk: %s", response)
+		}
+		chunks = append(chunks, response.OutputText())
+	}
+
+	if len(chunks) != 2 || strings.Join(chunks, "") != first.Data.Text || server.requestCount() != 1 {
+		t.Errorf("unexpected replay: %q", chunks)
+	}
+
+	//非增量输出时每个片段为当前的完整文本
+	cumulative := request()
+	cumulative.Parameters.IncrementalOutput = false
+	ch, _ = completionClient.CreateStreamCompletion(cumulative)
+	var last string
+	for response := range ch {
+		last = response.OutputText()
+	}
+
+	if last != first.Data.Text {
+		t.Errorf("unexpected last chunk: %s", last)
+	}
+
+	//过期后重新请求, 流式响应合并后写入缓存
+	now = now.Add(2 * time.Hour)
+	ch, _ = completionClient.CreateStreamCompletion(request())
+	for range ch {
+	}
+
+	if response, _ := completionClient.CreateCompletion(request()); !response.Cached || response.Data.Text != "流式回答" || server.requestCount() != 2 {
+		t.Errorf("expected merged stream cached, got: %s, requests: %d", response, server.requestCount())
+	}
+
+	cache.Delete(request())
+	if cache.Len() != 0 {
+		t.Errorf("unexpected cache size: %d", cache.Len())
+	}
+}
+
+func TestFileCompletionCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := client.NewFileCompletionCache(dir, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	request := &client.CompletionRequest{AppId: "app", Prompt: "你好"}
+	if err = cache.Store(request, textResponse("你好")); err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+
+	//新实例读取同一目录
+	reopened := &client.FileCompletionCache{Dir: dir, TTL: time.Minute}
+	if response, err := reopened.Lookup(request); err != nil || response == nil || response.Data.Text != "你好" {
+		t.Fatalf("unexpected cached response: %v, err: %v", response, err)
+	}
+
+	reopened.Now = func() time.Time { return time.Now().Add(time.Hour) }
+	if response, _ := reopened.Lookup(request); response != nil {
+		t.Errorf("expected expired response")
+	}
+
+	if response, _ := cache.Lookup(request); response != nil {
+		t.Errorf("expected expired file removed")
+	}
+}
+
+func TestCompletionCacheIncompleteStream(t *testing.T) {
+	prompts := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &client.CompletionRequest{}
+		_ = json.NewDecoder(r.Body).Decode(request)
+		prompts <- request.Prompt
+
+		write := func(response *client.CompletionResponse) {
+			data, _ := json.Marshal(response)
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		}
+
+		thought := func(text string) *client.CompletionResponse {
+			response := messageResponse(text, client.FinishReasonNull)
+			response.Data.Thoughts = []client.CompletionResponseDataThought{{Thought: "检索"}, {Thought: text}}
+			return response
+		}
+
+		switch request.Prompt {
+		case "aborted":
+			//连接中断, 客户端读取失败
+			write(messageResponse("部分", client.FinishReasonNull))
+			panic(http.ErrAbortHandler)
+		case "unfinished":
+			write(messageResponse("部分", client.FinishReasonNull))
+		default:
+			first := thought("回答")
+			first.Data.Thoughts = first.Data.Thoughts[:1]
+			first.Data.DocReferences = []client.CompletionResponseDataDocReference{{DocId: "d1"}}
+			write(first)
+			last := thought("完成")
+			last.Data.Choices[0].FinishReason = client.FinishReasonStop
+			last.Data.DocReferences = []client.CompletionResponseDataDocReference{{DocId: "d1"}, {DocId: "d2"}}
+			write(last)
+		}
+	}))
+	defer server.Close()
+
+	completionClient := &client.CompletionClient{Token: "token", Endpoint: server.URL, Cache: client.NewMemoryCompletionCache(0)}
+	for _, prompt := range []string{"aborted", "unfinished"} {
+		ch, err := completionClient.CreateStreamCompletion(&client.CompletionRequest{AppId: "app", Prompt: prompt})
+		if err != nil {
+			t.Fatalf("failed to create stream: %v", err)
+		}
+
+		var last *client.CompletionResponse
+		for response := range ch {
+			last = response
+		}
+
+		if prompt == "aborted" && (last.Success || last.Code != client.ErrorCodeStreamInterrupted) {
+			t.Errorf("expected interrupted chunk, got: %s", last)
+		}
+	}
+
+	if size := completionClient.Cache.(*client.MemoryCompletionCache).Len(); size != 0 {
+		t.Errorf("incomplete streams should not be cached, size: %d", size)
+	}
+
+	ch, _ := completionClient.CreateStreamCompletion(&client.CompletionRequest{AppId: "app", Prompt: "finished"})
+	for range ch {
+	}
+
+	response, _ := completionClient.CreateCompletion(&client.CompletionRequest{AppId: "app", Prompt: "finished"})
+	if !response.Cached || response.OutputText() != "完成" || len(response.Data.Thoughts) != 2 ||
+		response.Data.Thoughts[0].Thought != "检索" || len(response.Data.DocReferences) != 2 {
+		t.Errorf("unexpected merged response: %s", response)
+	}
+}
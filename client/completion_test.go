@@ -64,7 +64,7 @@ func TestCreateModelCompletion(t *testing.T) {
 		return
 	}
 
-	log.Printf("requestId: %s, content : %s\n", response.RequestId, response.Data.Choices[0].Message.Content)
+	log.Printf("requestId: %s, content : %s\n", response.RequestId, response.OutputText())
 
 	usages := response.Data.Usage
 	if usages != nil && len(usages) > 0 {
@@ -138,7 +138,7 @@ func TestCreateCompletionWithParams(t *testing.T) {
 		return
 	}
 
-	log.Printf("requestId: %s, text: %s\n", response.RequestId, response.Data.Choices[0].Message.Content)
+	log.Printf("requestId: %s, text: %s\n", response.RequestId, response.OutputText())
 }
 
 /**
@@ -186,7 +186,7 @@ func TestCreateStreamCompletion(t *testing.T) {
 			log.Printf("get result with error, requestId: %s, code: %s, message: %s\n",
 				result.RequestId, result.Code, result.Message)
 		} else {
-			fmt.Printf("%s", result.Data.Choices[0].Message.Content)
+			fmt.Printf("%s", result.OutputText())
 		}
 	}
 	fmt.Printf("\n")
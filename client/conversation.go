@@ -0,0 +1,346 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief client side multi-turn conversation
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"errors"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// MessageFormat 应用接收对话上下文的格式
+type MessageFormat string
+
+const (
+	// MessageFormatMessages 官方大模型应用, 通过Messages传入上下文
+	MessageFormatMessages MessageFormat = "messages"
+	// MessageFormatHistory 三方模型等应用, 通过Prompt和History传入上下文
+	MessageFormatHistory MessageFormat = "history"
+)
+
+var (
+	ErrEmptyConversation = errors.New("Conversation has no user turn")
+	// ErrIncompleteStream 流式响应在生成结束前中断或回复为空, 回复未追加到对话
+	ErrIncompleteStream = errors.New("Stream ended before the reply finished")
+)
+
+// Conversation 由调用侧维护的多轮对话, 每次请求自动携带历史上下文并追加模型回复, 非并发安全.
+// 设置Store后每次操作前从存储加载最新历史, 操作后写回存储, 多实例部署时可共享上下文;
+// 同时设置SessionId时, 百炼平台优先使用调用侧传入的上下文, Id可直接作为SessionId使用.
+// 设置Summarizer后历史过长时较早的对话被替换为摘要发送, Turns中仍保留完整历史
+type Conversation struct {
+	Client       *CompletionClient                `json:"-"`
+	Store        ConversationStore                `json:"-"`
+	Summarizer   *Summarizer                      `json:"-"`
+	Id           string                           `json:"Id,omitempty"`
+	Version      int64                            `json:"Version,omitempty"`
+	AppId        string                           `json:"AppId"`
+	SystemPrompt string                           `json:"SystemPrompt,omitempty"`
+	Format       MessageFormat                    `json:"Format,omitempty"`
+	SessionId    string                           `json:"SessionId,omitempty"`
+	Parameters   *CompletionRequestModelParameter `json:"Parameters,omitempty"`
+	Turns        []ChatCompletionMessage          `json:"Turns,omitempty"`
+	Summary      *ConversationSummary             `json:"Summary,omitempty"`
+
+	streamErr error
+}
+
+func (c Conversation) String() string {
+	return tea.Prettify(c)
+}
+
+func (c Conversation) GoString() string {
+	return c.String()
+}
+
+// Messages 以Messages格式返回完整上下文, 包含system prompt
+func (c *Conversation) Messages() []ChatCompletionMessage {
+	return c.messages(c.Turns)
+}
+
+func (c *Conversation) messages(turns []ChatCompletionMessage) []ChatCompletionMessage {
+	messages := make([]ChatCompletionMessage, 0, len(turns)+1)
+	if c.SystemPrompt != "" {
+		messages = append(messages, ChatCompletionMessage{Role: RoleSystem, Content: c.SystemPrompt})
+	}
+	return append(messages, turns...)
+}
+
+// PromptAndHistory 以Prompt+History格式返回上下文, 最后一条用户消息作为Prompt, system prompt合并到第一条用户消息之前;
+// 转换规则与AdaptRequest一致, 没有回复的用户消息与下一条用户消息合并
+func (c *Conversation) PromptAndHistory() (string, []ChatQaMessage) {
+	return c.promptAndHistory(c.Turns)
+}
+
+func (c *Conversation) promptAndHistory(turns []ChatCompletionMessage) (string, []ChatQaMessage) {
+	request := &CompletionRequest{Messages: c.messages(turns)}
+	AdaptRequest(request, MessageFormatHistory)
+	return request.Prompt, request.History
+}
+
+// BuildRequest 根据当前上下文构造请求, 不修改对话状态
+func (c *Conversation) BuildRequest() *CompletionRequest {
+	return c.buildRequest(c.Turns)
+}
+
+func (c *Conversation) buildRequest(turns []ChatCompletionMessage) *CompletionRequest {
+	request := &CompletionRequest{
+		AppId:      c.AppId,
+		SessionId:  c.SessionId,
+		Parameters: c.Parameters,
+	}
+
+	summary := c.Summary
+	if summary != nil && summary.ReplacedTurns > len(turns) {
+		summary = nil
+	}
+
+	if summary != nil {
+		turns = turns[summary.ReplacedTurns:]
+	}
+
+	if c.Format == MessageFormatHistory {
+		request.Prompt, request.History = c.promptAndHistory(turns)
+		if summary != nil {
+			request.History = append([]ChatQaMessage{summary.QaMessage()}, request.History...)
+		}
+	} else {
+		if summary != nil {
+			turns = append([]ChatCompletionMessage{summary.Message()}, turns...)
+		}
+		request.Messages = c.messages(turns)
+	}
+
+	return request
+}
+
+// Load 从Store加载最新的对话历史, 未设置Store时不做任何操作
+func (c *Conversation) Load() error {
+	if c.Store == nil {
+		return nil
+	}
+
+	record, err := c.Store.Load(c.Id)
+	if err == ErrConversationNotFound {
+		c.Turns, c.Version, c.Summary = nil, 0, nil
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.Turns, c.Version, c.Summary = record.Messages, record.Version, record.Summary
+	return nil
+}
+
+// Ask 追加用户消息并请求模型, 成功后自动追加模型回复; 失败时对话历史保持不变
+func (c *Conversation) Ask(prompt string) (*CompletionResponse, error) {
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+
+	return c.complete(len(c.Turns), ChatCompletionMessage{Role: RoleUser, Content: prompt})
+}
+
+// AskStream 流式请求模型, 生成正常结束时在返回的channel关闭前自动追加模型回复; 未追加的原因通过StreamErr获取
+func (c *Conversation) AskStream(prompt string) (chan *CompletionResponse, error) {
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+
+	return c.completeStream(len(c.Turns), ChatCompletionMessage{Role: RoleUser, Content: prompt})
+}
+
+// StreamErr 返回最近一次流式请求未追加回复的原因, 如片段失败、ErrIncompleteStream或写入存储失败; 需在channel关闭后调用
+func (c *Conversation) StreamErr() error {
+	return c.streamErr
+}
+
+// Undo 撤销最后一轮对话, 包括用户消息及其回复
+func (c *Conversation) Undo() (bool, error) {
+	if err := c.Load(); err != nil {
+		return false, err
+	}
+
+	index := c.lastUserIndex()
+	if index < 0 {
+		return false, nil
+	}
+
+	if err := c.commit(index); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// EditLast 修改最后一条用户消息并重新请求模型
+func (c *Conversation) EditLast(prompt string) (*CompletionResponse, error) {
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+
+	index := c.lastUserIndex()
+	if index < 0 {
+		return nil, ErrEmptyConversation
+	}
+
+	return c.complete(index, ChatCompletionMessage{Role: RoleUser, Content: prompt})
+}
+
+// Regenerate 丢弃最后一条模型回复并重新生成
+func (c *Conversation) Regenerate() (*CompletionResponse, error) {
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+
+	index := c.lastUserIndex()
+	if index < 0 {
+		return nil, ErrEmptyConversation
+	}
+
+	return c.complete(index + 1)
+}
+
+func (c *Conversation) lastUserIndex() int {
+	for i := len(c.Turns) - 1; i >= 0; i-- {
+		if c.Turns[i].Role == RoleUser {
+			return i
+		}
+	}
+	return -1
+}
+
+// commit 保留前keep条消息并追加新消息, 设置Store时同步写回存储; 写入失败时对话历史保持不变
+func (c *Conversation) commit(keep int, appended ...ChatCompletionMessage) error {
+	turns := append(c.Turns[:keep:keep], appended...)
+	if c.Store != nil {
+		var version int64
+		var err error
+
+		//截断后追加通过一次Replace写入, 避免追加失败时存储中的历史已被截断
+		if keep < len(c.Turns) {
+			version, err = c.Store.Replace(c.Id, c.Version, turns)
+		} else if len(appended) > 0 {
+			version, err = c.Store.Append(c.Id, c.Version, appended...)
+		} else {
+			version = c.Version
+		}
+
+		if err != nil {
+			return err
+		}
+		c.Version = version
+	}
+
+	if c.Summary != nil && c.Summary.ReplacedTurns > keep {
+		c.Summary = nil
+	}
+
+	c.Turns = turns
+	return nil
+}
+
+// summarize 已保存的历史超过阈值时生成新的摘要, 设置Store时同步写回存储
+func (c *Conversation) summarize(keep int) error {
+	if c.Summarizer == nil {
+		return nil
+	}
+
+	summary, err := c.Summarizer.Apply(c.Summary, c.Turns[:keep])
+	if err != nil || summary == nil {
+		return err
+	}
+
+	if c.Store != nil {
+		version, err := c.Store.SaveSummary(c.Id, c.Version, summary)
+		if err != nil {
+			return err
+		}
+		c.Version = version
+	}
+
+	c.Summary = summary
+	return nil
+}
+
+func (c *Conversation) complete(keep int, pending ...ChatCompletionMessage) (*CompletionResponse, error) {
+	if err := c.summarize(keep); err != nil {
+		return nil, err
+	}
+
+	turns := append(c.Turns[:keep:keep], pending...)
+	response, err := c.Client.CreateCompletion(c.buildRequest(turns))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = response.Err(); err != nil {
+		return nil, err
+	}
+
+	reply := ChatCompletionMessage{Role: RoleAssistant, Content: response.OutputText()}
+	if err = c.commit(keep, append(pending, reply)...); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (c *Conversation) completeStream(keep int, pending ...ChatCompletionMessage) (chan *CompletionResponse, error) {
+	c.streamErr = nil
+	if err := c.summarize(keep); err != nil {
+		return nil, err
+	}
+
+	turns := append(c.Turns[:keep:keep], pending...)
+	request := c.buildRequest(turns)
+	stream, err := c.Client.CreateStreamCompletion(request)
+	if err != nil {
+		return nil, err
+	}
+
+	incremental := request.Parameters != nil && request.Parameters.IncrementalOutput
+	ch := make(chan *CompletionResponse)
+
+	go func() {
+		defer close(ch)
+
+		text := ""
+		var last *CompletionResponse
+		var failed error
+		for response := range stream {
+			if err := response.Err(); err != nil {
+				if failed == nil {
+					failed = err
+				}
+			} else if incremental {
+				text += response.OutputText()
+			} else {
+				text = response.OutputText()
+			}
+
+			last = response
+			ch <- response
+		}
+
+		//中断或未结束的回复不追加, 避免不完整的回复写入存储
+		switch {
+		case failed != nil:
+			c.streamErr = failed
+		case last == nil || !streamFinished(last) || text == "":
+			c.streamErr = ErrIncompleteStream
+		default:
+			reply := ChatCompletionMessage{Role: RoleAssistant, Content: text}
+			c.streamErr = c.commit(keep, append(pending, reply)...)
+		}
+	}()
+
+	return ch, nil
+}
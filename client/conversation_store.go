@@ -0,0 +1,407 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief conversation history store with optimistic versioning
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/internal/fileutil"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConversationNotFound = errors.New("Conversation not found")
+	ErrVersionConflict      = errors.New("Conversation version conflict")
+)
+
+// ConversationRecord 持久化的对话记录, Version在每次修改后递增
+type ConversationRecord struct {
+	Id        string                  `json:"Id"`
+	Version   int64                   `json:"Version"`
+	Messages  []ChatCompletionMessage `json:"Messages,omitempty"`
+	Summary   *ConversationSummary    `json:"Summary,omitempty"`
+	UpdatedAt int64                   `json:"UpdatedAt"`
+}
+
+func (r ConversationRecord) String() string {
+	return tea.Prettify(r)
+}
+
+func (r ConversationRecord) GoString() string {
+	return r.String()
+}
+
+// ConversationStore 对话历史存储, 多实例部署时可通过共享存储在实例间保持上下文.
+// 写操作需传入调用方读取到的版本号, 与存储中的版本不一致时返回ErrVersionConflict, 新对话的版本号为0
+type ConversationStore interface {
+	// Load 读取对话记录, 不存在时返回ErrConversationNotFound
+	Load(id string) (*ConversationRecord, error)
+	// Append 追加消息, 返回新的版本号
+	Append(id string, version int64, messages ...ChatCompletionMessage) (int64, error)
+	// Truncate 仅保留前length条消息, 摘要替换的消息被截断时同时删除摘要, 返回新的版本号
+	Truncate(id string, version int64, length int) (int64, error)
+	// Replace 以messages替换全部消息, 摘要替换的消息被修改时同时删除摘要, 返回新的版本号
+	Replace(id string, version int64, messages []ChatCompletionMessage) (int64, error)
+	// SaveSummary 保存对话摘要, 返回新的版本号
+	SaveSummary(id string, version int64, summary *ConversationSummary) (int64, error)
+	// Delete 删除对话记录, 记录不存在时不返回错误
+	Delete(id string) error
+}
+
+func appendRecord(record *ConversationRecord, id string, version int64, messages []ChatCompletionMessage) error {
+	if record.Version != version {
+		return ErrVersionConflict
+	}
+
+	record.Id = id
+	record.Messages = append(record.Messages, messages...)
+	record.Version++
+	record.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func truncateRecord(record *ConversationRecord, version int64, length int) error {
+	if record.Version != version {
+		return ErrVersionConflict
+	}
+
+	if length < 0 || length > len(record.Messages) {
+		return fmt.Errorf("Invalid truncate length: %d, messages: %d", length, len(record.Messages))
+	}
+
+	record.Messages = record.Messages[:length:length]
+	if record.Summary != nil && record.Summary.ReplacedTurns > length {
+		record.Summary = nil
+	}
+	record.Version++
+	record.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func replaceRecord(record *ConversationRecord, id string, version int64, messages []ChatCompletionMessage) error {
+	if record.Version != version {
+		return ErrVersionConflict
+	}
+
+	//摘要只在其替换的消息未被修改时保留
+	same := 0
+	for same < len(messages) && same < len(record.Messages) && messages[same] == record.Messages[same] {
+		same++
+	}
+
+	if record.Summary != nil && record.Summary.ReplacedTurns > same {
+		record.Summary = nil
+	}
+
+	record.Id = id
+	record.Messages = append([]ChatCompletionMessage(nil), messages...)
+	record.Version++
+	record.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func summarizeRecord(record *ConversationRecord, id string, version int64, summary *ConversationSummary) error {
+	if record.Version != version {
+		return ErrVersionConflict
+	}
+
+	if summary != nil && summary.ReplacedTurns > len(record.Messages) {
+		return fmt.Errorf("Invalid summary, replaced turns: %d, messages: %d", summary.ReplacedTurns, len(record.Messages))
+	}
+
+	record.Id = id
+	record.Summary = summary
+	record.Version++
+	record.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func copyRecord(record *ConversationRecord) *ConversationRecord {
+	result := *record
+	result.Messages = append([]ChatCompletionMessage(nil), record.Messages...)
+	if record.Summary != nil {
+		summary := *record.Summary
+		result.Summary = &summary
+	}
+	return &result
+}
+
+// MemoryConversationStore 进程内存储, 适用于单实例或测试
+type MemoryConversationStore struct {
+	mutex   sync.Mutex
+	records map[string]*ConversationRecord
+}
+
+func NewMemoryConversationStore() *MemoryConversationStore {
+	return &MemoryConversationStore{records: make(map[string]*ConversationRecord)}
+}
+
+func (s *MemoryConversationStore) Load(id string) (*ConversationRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+	return copyRecord(record), nil
+}
+
+func (s *MemoryConversationStore) Append(id string, version int64, messages ...ChatCompletionMessage) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		record = &ConversationRecord{Id: id}
+	} else {
+		record = copyRecord(record)
+	}
+
+	if err := appendRecord(record, id, version, messages); err != nil {
+		return 0, err
+	}
+
+	s.records[id] = record
+	return record.Version, nil
+}
+
+func (s *MemoryConversationStore) Truncate(id string, version int64, length int) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return 0, ErrConversationNotFound
+	}
+
+	record = copyRecord(record)
+	if err := truncateRecord(record, version, length); err != nil {
+		return 0, err
+	}
+
+	s.records[id] = record
+	return record.Version, nil
+}
+
+func (s *MemoryConversationStore) Replace(id string, version int64, messages []ChatCompletionMessage) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		record = &ConversationRecord{Id: id}
+	} else {
+		record = copyRecord(record)
+	}
+
+	if err := replaceRecord(record, id, version, messages); err != nil {
+		return 0, err
+	}
+
+	s.records[id] = record
+	return record.Version, nil
+}
+
+func (s *MemoryConversationStore) SaveSummary(id string, version int64, summary *ConversationSummary) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		record = &ConversationRecord{Id: id}
+	} else {
+		record = copyRecord(record)
+	}
+
+	if err := summarizeRecord(record, id, version, summary); err != nil {
+		return 0, err
+	}
+
+	s.records[id] = record
+	return record.Version, nil
+}
+
+func (s *MemoryConversationStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, id)
+	return nil
+}
+
+// FileConversationStore 以JSON文件保存对话记录, 每个对话一个文件; 目录可挂载为多实例共享存储,
+// 写入时通过锁文件在进程间互斥
+type FileConversationStore struct {
+	Dir         string
+	LockTimeout time.Duration
+}
+
+func NewFileConversationStore(dir string) (*FileConversationStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileConversationStore{Dir: dir, LockTimeout: 10 * time.Second}, nil
+}
+
+func (s *FileConversationStore) path(id string) string {
+	return filepath.Join(s.Dir, base64.RawURLEncoding.EncodeToString([]byte(id))+".json")
+}
+
+func (s *FileConversationStore) Load(id string) (*ConversationRecord, error) {
+	data, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrConversationNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	record := &ConversationRecord{}
+	if err = json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("Failed to parse conversation %s: %v", id, err)
+	}
+	return record, nil
+}
+
+func (s *FileConversationStore) Append(id string, version int64, messages ...ChatCompletionMessage) (int64, error) {
+	return s.update(id, true, func(record *ConversationRecord) error {
+		return appendRecord(record, id, version, messages)
+	})
+}
+
+func (s *FileConversationStore) Truncate(id string, version int64, length int) (int64, error) {
+	return s.update(id, false, func(record *ConversationRecord) error {
+		return truncateRecord(record, version, length)
+	})
+}
+
+func (s *FileConversationStore) Replace(id string, version int64, messages []ChatCompletionMessage) (int64, error) {
+	return s.update(id, true, func(record *ConversationRecord) error {
+		return replaceRecord(record, id, version, messages)
+	})
+}
+
+func (s *FileConversationStore) SaveSummary(id string, version int64, summary *ConversationSummary) (int64, error) {
+	return s.update(id, true, func(record *ConversationRecord) error {
+		return summarizeRecord(record, id, version, summary)
+	})
+}
+
+func (s *FileConversationStore) Delete(id string) error {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = os.Remove(s.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileConversationStore) update(id string, create bool, modify func(record *ConversationRecord) error) (int64, error) {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	record, err := s.Load(id)
+	if err == ErrConversationNotFound && create {
+		record, err = &ConversationRecord{Id: id}, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = modify(record); err != nil {
+		return 0, err
+	}
+
+	err = fileutil.WriteFileAtomic(s.path(id), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(record)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return record.Version, nil
+}
+
+func (s *FileConversationStore) lock(id string) (func(), error) {
+	lockPath := s.path(id) + ".lock"
+	timeout := s.LockTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			info, statErr := file.Stat()
+			_ = file.Close()
+			if statErr != nil {
+				_ = os.Remove(lockPath)
+				return nil, statErr
+			}
+			//锁可能已被其他进程视为失效并接管, 只删除自己创建的锁文件
+			return func() { removeLock(lockPath, info) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		//持有锁的进程异常退出时, 锁文件超时后视为失效
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > timeout {
+			removeLock(lockPath, info)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Failed to lock conversation %s, timeout: %v", id, timeout)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// removeLock 删除与info相同的锁文件. 先重命名为唯一的文件名再比较, 避免在检查和删除之间其他进程新建的锁被删除;
+// 重命名得到的不是info对应的文件时放回原处, 返回是否删除
+func removeLock(lockPath string, info os.FileInfo) bool {
+	renamedPath := fmt.Sprintf("%s.%d.stale", lockPath, time.Now().UnixNano())
+	if err := os.Rename(lockPath, renamedPath); err != nil {
+		return false
+	}
+	defer os.Remove(renamedPath)
+
+	renamed, err := os.Stat(renamedPath)
+	if err == nil && os.SameFile(info, renamed) && renamed.ModTime().Equal(info.ModTime()) {
+		return true
+	}
+
+	//Link在lockPath已存在时失败, 不会覆盖其他进程刚创建的锁
+	_ = os.Link(renamedPath, lockPath)
+	return false
+}
@@ -0,0 +1,252 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for conversation store
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"encoding/base64"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testConversationStore(t *testing.T, store client.ConversationStore) {
+	if _, err := store.Load("c1"); err != client.ErrConversationNotFound {
+		t.Fatalf("expected not found, got: %v", err)
+	}
+
+	version, err := store.Append("c1", 0,
+		client.ChatCompletionMessage{Role: client.RoleUser, Content: "q1"},
+		client.ChatCompletionMessage{Role: client.RoleAssistant, Content: "a1"})
+	if err != nil || version != 1 {
+		t.Fatalf("failed to append, version: %d, err: %v", version, err)
+	}
+
+	if _, err = store.Append("c1", 0, client.ChatCompletionMessage{Role: client.RoleUser, Content: "stale"}); err != client.ErrVersionConflict {
+		t.Errorf("expected version conflict, got: %v", err)
+	}
+
+	version, err = store.Append("c1", 1, client.ChatCompletionMessage{Role: client.RoleUser, Content: "q2"})
+	if err != nil || version != 2 {
+		t.Fatalf("failed to append, version: %d, err: %v", version, err)
+	}
+
+	version, err = store.Truncate("c1", 2, 2)
+	if err != nil || version != 3 {
+		t.Fatalf("failed to truncate, version: %d, err: %v", version, err)
+	}
+
+	if _, err = store.Truncate("c1", 3, 5); err == nil {
+		t.Errorf("expected invalid length error")
+	}
+
+	record, err := store.Load("c1")
+	if err != nil || record.Version != 3 || len(record.Messages) != 2 || record.Messages[1].Content != "a1" {
+		t.Fatalf("unexpected record: %v, err: %v", record, err)
+	}
+
+	//修改读取到的记录不影响存储
+	record.Messages[0].Content = "changed"
+	record, _ = store.Load("c1")
+	if record.Messages[0].Content != "q1" {
+		t.Errorf("store should not share messages with caller")
+	}
+
+	var wg sync.WaitGroup
+	succeeded := make(chan int64, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if version, err := store.Append("c1", 3, client.ChatCompletionMessage{Role: client.RoleUser, Content: "race"}); err == nil {
+				succeeded <- version
+			}
+		}()
+	}
+	wg.Wait()
+	close(succeeded)
+
+	if len(succeeded) != 1 {
+		t.Errorf("only one concurrent append should succeed, got: %d", len(succeeded))
+	}
+
+	record, _ = store.Load("c1")
+	replaced := []client.ChatCompletionMessage{record.Messages[0], {Role: client.RoleAssistant, Content: "a1 edited"}}
+	if _, err = store.Replace("c1", record.Version-1, replaced); err != client.ErrVersionConflict {
+		t.Errorf("expected version conflict, got: %v", err)
+	}
+
+	version, err = store.Replace("c1", record.Version, replaced)
+	if err != nil || version != record.Version+1 {
+		t.Fatalf("failed to replace, version: %d, err: %v", version, err)
+	}
+
+	if record, _ = store.Load("c1"); len(record.Messages) != 2 || record.Messages[1].Content != "a1 edited" {
+		t.Errorf("unexpected replaced record: %v", record)
+	}
+
+	if err = store.Delete("c1"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	if _, err = store.Load("c1"); err != client.ErrConversationNotFound {
+		t.Errorf("expected not found after delete, got: %v", err)
+	}
+
+	if err = store.Delete("c1"); err != nil {
+		t.Errorf("delete missing conversation should not fail: %v", err)
+	}
+}
+
+func TestMemoryConversationStore(t *testing.T) {
+	testConversationStore(t, client.NewMemoryConversationStore())
+}
+
+func TestFileConversationStore(t *testing.T) {
+	store, err := client.NewFileConversationStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	testConversationStore(t, store)
+}
+
+func TestFileConversationStoreStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	store, err := client.NewFileConversationStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.LockTimeout = 100 * time.Millisecond
+
+	//模拟持有锁的进程异常退出
+	lockPath := filepath.Join(dir, base64.RawURLEncoding.EncodeToString([]byte("c1"))+".json.lock")
+	if err = ioutil.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatalf("failed to write lock: %v", err)
+	}
+	stale := time.Now().Add(-time.Minute)
+	_ = os.Chtimes(lockPath, stale, stale)
+
+	if _, err = store.Append("c1", 0, client.ChatCompletionMessage{Role: client.RoleUser, Content: "q1"}); err != nil {
+		t.Fatalf("failed to append with stale lock: %v", err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("lock files should be removed, got: %d files", len(files))
+	}
+}
+
+func TestConversationWithStore(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		last := request.Messages[len(request.Messages)-1]
+		return []*client.CompletionResponse{messageResponse("reply to "+last.Content, client.FinishReasonStop)}
+	})
+
+	store, err := client.NewFileConversationStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	//模拟请求被路由到不同实例
+	first := &client.Conversation{Client: server.client(), Store: store, Id: "user-1", AppId: "app", SystemPrompt: "sys"}
+	second := &client.Conversation{Client: server.client(), Store: store, Id: "user-1", AppId: "app", SystemPrompt: "sys"}
+
+	if _, err = first.Ask("q1"); err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	if _, err = second.Ask("q2"); err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	request := server.lastRequest()
+	if len(request.Messages) != 4 || request.Messages[2].Content != "reply to q1" {
+		t.Errorf("history should be shared between instances, got: %v", request.Messages)
+	}
+
+	if _, err = first.Regenerate(); err != nil {
+		t.Fatalf("failed to regenerate: %v", err)
+	}
+
+	if ok, err := second.Undo(); !ok || err != nil {
+		t.Fatalf("failed to undo, err: %v", err)
+	}
+
+	record, err := store.Load("user-1")
+	if err != nil || len(record.Messages) != 2 || record.Messages[0].Content != "q1" {
+		t.Errorf("unexpected record: %v, err: %v", record, err)
+	}
+
+	stream, err := first.AskStream("q3")
+	if err != nil {
+		t.Fatalf("failed to ask stream: %v", err)
+	}
+	for range stream {
+	}
+
+	record, _ = store.Load("user-1")
+	if len(record.Messages) != 4 || record.Messages[3].Content != "reply to q3" || record.Version != first.Version {
+		t.Errorf("stream reply should be saved, got: %v", record)
+	}
+}
+
+// failingStore 设置fail后写入消息时返回版本冲突, 模拟其他实例并发修改
+type failingStore struct {
+	*client.MemoryConversationStore
+	fail bool
+}
+
+func (s *failingStore) Append(id string, version int64, messages ...client.ChatCompletionMessage) (int64, error) {
+	if s.fail {
+		return 0, client.ErrVersionConflict
+	}
+	return s.MemoryConversationStore.Append(id, version, messages...)
+}
+
+func (s *failingStore) Replace(id string, version int64, messages []client.ChatCompletionMessage) (int64, error) {
+	if s.fail {
+		return 0, client.ErrVersionConflict
+	}
+	return s.MemoryConversationStore.Replace(id, version, messages)
+}
+
+func TestConversationStoreWriteFailure(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		last := request.Messages[len(request.Messages)-1]
+		return []*client.CompletionResponse{messageResponse("reply to "+last.Content, client.FinishReasonStop)}
+	})
+
+	store := &failingStore{MemoryConversationStore: client.NewMemoryConversationStore()}
+	conversation := &client.Conversation{Client: server.client(), Store: store, Id: "c1", AppId: "app"}
+	if _, err := conversation.Ask("q1"); err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	store.fail = true
+	if _, err := conversation.EditLast("q1 edited"); err != client.ErrVersionConflict {
+		t.Fatalf("expected version conflict, got: %v", err)
+	}
+
+	if _, err := conversation.Ask("q2"); err != client.ErrVersionConflict {
+		t.Fatalf("expected version conflict, got: %v", err)
+	}
+
+	record, err := store.Load("c1")
+	if err != nil || len(record.Messages) != 2 || record.Messages[0].Content != "q1" || record.Version != 1 {
+		t.Errorf("stored history should be unchanged, got: %v, err: %v", record, err)
+	}
+
+	if len(conversation.Turns) != 2 || conversation.Turns[1].Content != "reply to q1" || conversation.Version != 1 {
+		t.Errorf("local history should be unchanged, got: %v", conversation.Turns)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for client side conversation
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"reflect"
+	"testing"
+)
+
+func TestConversationMessages(t *testing.T) {
+	count := 0
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		count++
+		return []*client.CompletionResponse{messageResponse(fmt.Sprintf("answer %d", count), client.FinishReasonStop)}
+	})
+
+	conversation := &client.Conversation{Client: server.client(), AppId: "app", SystemPrompt: "你是一个旅行专家"}
+	if _, err := conversation.Ask("我想去北京"); err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	response, err := conversation.Ask("有什么景点")
+	if err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	if response.OutputText() != "answer 2" {
+		t.Errorf("unexpected answer: %q", response.OutputText())
+	}
+
+	request := server.lastRequest()
+	if len(request.Messages) != 4 || request.Messages[0].Role != client.RoleSystem ||
+		request.Messages[2].Content != "answer 1" || request.Messages[3].Content != "有什么景点" {
+		t.Errorf("unexpected messages: %v", request.Messages)
+	}
+
+	if len(conversation.Turns) != 4 || conversation.Turns[3].Content != "answer 2" {
+		t.Errorf("unexpected turns: %v", conversation.Turns)
+	}
+
+	response, err = conversation.Regenerate()
+	if err != nil || response.OutputText() != "answer 3" {
+		t.Fatalf("failed to regenerate, response: %v, err: %v", response, err)
+	}
+
+	if len(conversation.Turns) != 4 || conversation.Turns[3].Content != "answer 3" {
+		t.Errorf("unexpected turns after regenerate: %v", conversation.Turns)
+	}
+
+	if _, err = conversation.EditLast("有什么美食"); err != nil {
+		t.Fatalf("failed to edit last turn: %v", err)
+	}
+
+	if conversation.Turns[2].Content != "有什么美食" || conversation.Turns[3].Content != "answer 4" {
+		t.Errorf("unexpected turns after edit: %v", conversation.Turns)
+	}
+
+	if ok, _ := conversation.Undo(); !ok || len(conversation.Turns) != 2 {
+		t.Errorf("unexpected turns after undo: %v", conversation.Turns)
+	}
+}
+
+func TestConversationHistoryFormat(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{textResponse("reply to " + request.Prompt)}
+	})
+
+	conversation := &client.Conversation{Client: server.client(), AppId: "app", Format: client.MessageFormatHistory}
+	_, _ = conversation.Ask("q1")
+	_, _ = conversation.Ask("q2")
+
+	request := server.lastRequest()
+	if request.Prompt != "q2" || len(request.Messages) != 0 || len(request.History) != 1 ||
+		request.History[0].User != "q1" || request.History[0].Bot != "reply to q1" {
+		t.Errorf("unexpected request: %s", request)
+	}
+
+	if conversation.Turns[3].Content != "reply to q2" {
+		t.Errorf("unexpected turns: %v", conversation.Turns)
+	}
+}
+
+// TestConversationPromptAndHistory 固定History格式的转换结果: system prompt合并到第一条用户消息, 没有回复的用户消息与下一条合并
+func TestConversationPromptAndHistory(t *testing.T) {
+	conversation := &client.Conversation{SystemPrompt: "sys", Turns: []client.ChatCompletionMessage{
+		{Role: client.RoleUser, Content: "q1"},
+		{Role: client.RoleAssistant, Content: "a1"},
+		{Role: client.RoleUser, Content: "q2"},
+		{Role: client.RoleUser, Content: "q3"},
+		{Role: client.RoleAssistant, Content: "a3"},
+		{Role: client.RoleUser, Content: "q4"},
+	}}
+
+	prompt, history := conversation.PromptAndHistory()
+	expected := []client.ChatQaMessage{{User: "sys\n\nq1", Bot: "a1"}, {User: "q2\n\nq3", Bot: "a3"}}
+	if prompt != "q4" || !reflect.DeepEqual(history, expected) {
+		t.Errorf("unexpected prompt: %s, history: %v", prompt, history)
+	}
+
+	//没有历史时system prompt合并到Prompt
+	conversation.Turns = conversation.Turns[5:]
+	if prompt, history = conversation.PromptAndHistory(); prompt != "sys\n\nq4" || len(history) != 0 {
+		t.Errorf("unexpected prompt: %s, history: %v", prompt, history)
+	}
+}
+
+func TestConversationStream(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{
+			messageResponse("黑洞", client.FinishReasonNull),
+			messageResponse("是", client.FinishReasonNull),
+			messageResponse("天体", client.FinishReasonStop),
+		}
+	})
+
+	conversation := &client.Conversation{
+		Client:     server.client(),
+		AppId:      "app",
+		Parameters: &client.CompletionRequestModelParameter{ResultFormat: client.ResultFormatMessage, IncrementalOutput: true},
+	}
+
+	stream, err := conversation.AskStream("什么是黑洞")
+	if err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	text := ""
+	for response := range stream {
+		text += response.OutputText()
+	}
+
+	if text != "黑洞是天体" || len(conversation.Turns) != 2 || conversation.Turns[1].Content != text {
+		t.Errorf("unexpected turns: %v", conversation.Turns)
+	}
+}
+
+func TestConversationStreamIncomplete(t *testing.T) {
+	var responses []*client.CompletionResponse
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return responses
+	})
+
+	store := &failingStore{MemoryConversationStore: client.NewMemoryConversationStore()}
+	conversation := &client.Conversation{Client: server.client(), Store: store, Id: "c1", AppId: "app"}
+	ask := func() error {
+		stream, err := conversation.AskStream("什么是黑洞")
+		if err != nil {
+			t.Fatalf("failed to ask: %v", err)
+		}
+		for range stream {
+		}
+		return conversation.StreamErr()
+	}
+
+	//生成未结束或回复为空时不追加
+	for _, chunks := range [][]*client.CompletionResponse{
+		{messageResponse("黑洞", client.FinishReasonNull)},
+		{messageResponse("", client.FinishReasonStop)},
+		nil,
+	} {
+		responses = chunks
+		if err := ask(); err != client.ErrIncompleteStream || len(conversation.Turns) != 0 {
+			t.Errorf("expected incomplete stream, got: %v, turns: %v", err, conversation.Turns)
+		}
+	}
+
+	responses = []*client.CompletionResponse{messageResponse("天体", client.FinishReasonStop)}
+	store.fail = true
+	if err := ask(); err != client.ErrVersionConflict || len(conversation.Turns) != 0 {
+		t.Errorf("expected store error, got: %v, turns: %v", err, conversation.Turns)
+	}
+
+	store.fail = false
+	if err := ask(); err != nil || len(conversation.Turns) != 2 {
+		t.Errorf("unexpected result: %v, turns: %v", err, conversation.Turns)
+	}
+}
+
+func TestConversationRollbackOnFailure(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{{Success: false, Code: "Throttling", Message: "too many requests"}}
+	})
+
+	conversation := &client.Conversation{Client: server.client(), AppId: "app"}
+	if _, err := conversation.Ask("hello"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if len(conversation.Turns) != 0 {
+		t.Errorf("user turn should be rolled back, got: %v", conversation.Turns)
+	}
+
+	if _, err := conversation.Regenerate(); err != client.ErrEmptyConversation {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
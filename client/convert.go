@@ -0,0 +1,120 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief converters between History and Messages formats
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"strings"
+)
+
+// HistoryToMessages 将Prompt+History转换为Messages, system为空时不添加system消息;
+// 空的User或Bot被忽略, 相邻的同角色消息合并为一条
+func HistoryToMessages(system string, prompt string, history []ChatQaMessage) []ChatCompletionMessage {
+	var messages []ChatCompletionMessage
+	if system != "" {
+		messages = append(messages, ChatCompletionMessage{Role: RoleSystem, Content: system})
+	}
+
+	for _, message := range history {
+		messages = appendMerged(messages, RoleUser, message.User)
+		messages = appendMerged(messages, RoleAssistant, message.Bot)
+	}
+
+	return appendMerged(messages, RoleUser, prompt)
+}
+
+// MessagesToHistory 将Messages转换为system、Prompt和History.
+// 所有system消息合并返回; tool消息视为用户提供的内容; 相邻的同角色消息合并;
+// 没有回复的用户消息与下一条用户消息合并, 没有提问的回复以空User保留;
+// 最后一条为用户消息时作为Prompt, 否则Prompt为空
+func MessagesToHistory(messages []ChatCompletionMessage) (string, string, []ChatQaMessage) {
+	var systems []string
+	var turns []ChatCompletionMessage
+
+	for _, message := range messages {
+		switch message.Role {
+		case RoleSystem:
+			if message.Content != "" {
+				systems = append(systems, message.Content)
+			}
+		case RoleAssistant:
+			turns = appendMerged(turns, RoleAssistant, message.Content)
+		default:
+			turns = appendMerged(turns, RoleUser, message.Content)
+		}
+	}
+
+	var history []ChatQaMessage
+	prompt := ""
+	for _, turn := range turns {
+		if turn.Role == RoleUser {
+			prompt = turn.Content
+			continue
+		}
+
+		history = append(history, ChatQaMessage{User: prompt, Bot: turn.Content})
+		prompt = ""
+	}
+
+	return strings.Join(systems, "\n"), prompt, history
+}
+
+// AdaptRequest 将请求转换为应用接收的上下文格式; 转换为History格式时system内容合并到第一条用户消息之前
+func AdaptRequest(request *CompletionRequest, format MessageFormat) {
+	switch format {
+	case MessageFormatMessages:
+		if len(request.Messages) > 0 || (request.Prompt == "" && len(request.History) == 0) {
+			return
+		}
+
+		request.Messages = HistoryToMessages("", request.Prompt, request.History)
+		request.Prompt, request.History = "", nil
+	case MessageFormatHistory:
+		if len(request.Messages) == 0 {
+			return
+		}
+
+		system, prompt, history := MessagesToHistory(request.Messages)
+		if system != "" {
+			if len(history) > 0 {
+				history[0].User = joinContent(system, history[0].User)
+			} else {
+				prompt = joinContent(system, prompt)
+			}
+		}
+
+		request.Prompt, request.History, request.Messages = prompt, history, nil
+	}
+}
+
+func appendMerged(messages []ChatCompletionMessage, role Role, content string) []ChatCompletionMessage {
+	if content == "" {
+		return messages
+	}
+
+	last := len(messages) - 1
+	if last >= 0 && messages[last].Role == role {
+		messages[last].Content = joinContent(messages[last].Content, content)
+		return messages
+	}
+
+	return append(messages, ChatCompletionMessage{Role: role, Content: content})
+}
+
+func joinContent(first string, second string) string {
+	if first == "" {
+		return second
+	}
+
+	if second == "" {
+		return first
+	}
+
+	return first + "\n\n" + second
+}
@@ -0,0 +1,145 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for History and Messages converters
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryToMessages(t *testing.T) {
+	messages := client.HistoryToMessages("sys", "q3", []client.ChatQaMessage{
+		{User: "q1", Bot: "a1"},
+		{User: "q2"},
+		{Bot: "a2"},
+	})
+
+	expected := []client.ChatCompletionMessage{
+		{Role: client.RoleSystem, Content: "sys"},
+		{Role: client.RoleUser, Content: "q1"},
+		{Role: client.RoleAssistant, Content: "a1"},
+		{Role: client.RoleUser, Content: "q2"},
+		{Role: client.RoleAssistant, Content: "a2"},
+		{Role: client.RoleUser, Content: "q3"},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("unexpected messages: %v", messages)
+	}
+
+	//未回复的用户消息与当前Prompt合并
+	messages = client.HistoryToMessages("", "q2", []client.ChatQaMessage{{User: "q1"}})
+	if len(messages) != 1 || messages[0].Content != "q1\n\nq2" {
+		t.Errorf("unexpected messages: %v", messages)
+	}
+}
+
+func TestMessagesToHistory(t *testing.T) {
+	system, prompt, history := client.MessagesToHistory([]client.ChatCompletionMessage{
+		{Role: client.RoleSystem, Content: "sys"},
+		{Role: client.RoleAssistant, Content: "welcome"},
+		{Role: client.RoleUser, Content: "q1"},
+		{Role: client.RoleUser, Content: "q1 again"},
+		{Role: client.RoleAssistant, Content: "a1"},
+		{Role: client.RoleAssistant, Content: "a1 more"},
+		{Role: client.RoleUser, Content: "q2"},
+		{Role: client.RoleTool, Content: "observation"},
+	})
+
+	if system != "sys" || prompt != "q2\n\nobservation" {
+		t.Errorf("unexpected system: %q, prompt: %q", system, prompt)
+	}
+
+	expected := []client.ChatQaMessage{
+		{Bot: "welcome"},
+		{User: "q1\n\nq1 again", Bot: "a1\n\na1 more"},
+	}
+	if !reflect.DeepEqual(history, expected) {
+		t.Errorf("unexpected history: %v", history)
+	}
+
+	_, prompt, history = client.MessagesToHistory([]client.ChatCompletionMessage{
+		{Role: client.RoleUser, Content: "q1"},
+		{Role: client.RoleAssistant, Content: "a1"},
+	})
+	if prompt != "" || len(history) != 1 {
+		t.Errorf("unexpected prompt: %q, history: %v", prompt, history)
+	}
+}
+
+func TestAdaptRequestRoundTrip(t *testing.T) {
+	request := &client.CompletionRequest{
+		Messages: []client.ChatCompletionMessage{
+			{Role: client.RoleSystem, Content: "sys"},
+			{Role: client.RoleUser, Content: "q1"},
+			{Role: client.RoleAssistant, Content: "a1"},
+			{Role: client.RoleUser, Content: "q2"},
+		},
+	}
+
+	client.AdaptRequest(request, client.MessageFormatHistory)
+	if request.Messages != nil || request.Prompt != "q2" || len(request.History) != 1 || request.History[0].User != "sys\n\nq1" {
+		t.Fatalf("unexpected history request: %s", request)
+	}
+
+	client.AdaptRequest(request, client.MessageFormatMessages)
+	if request.Prompt != "" || request.History != nil || len(request.Messages) != 3 || request.Messages[0].Content != "sys\n\nq1" {
+		t.Fatalf("unexpected messages request: %s", request)
+	}
+
+	//system消息且没有历史时合并到Prompt
+	request = &client.CompletionRequest{Messages: []client.ChatCompletionMessage{
+		{Role: client.RoleSystem, Content: "sys"},
+		{Role: client.RoleUser, Content: "q"},
+	}}
+	client.AdaptRequest(request, client.MessageFormatHistory)
+	if request.Prompt != "sys\n\nq" {
+		t.Errorf("unexpected prompt: %q", request.Prompt)
+	}
+}
+
+func TestCompletionClientAppMessageFormats(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{textResponse("ok")}
+	})
+
+	cc := server.client()
+	cc.AppMessageFormats = map[string]client.MessageFormat{
+		"third-party-app": client.MessageFormatHistory,
+		"official-app":    client.MessageFormatMessages,
+	}
+
+	conversation := &client.Conversation{Client: cc, AppId: "third-party-app", Turns: []client.ChatCompletionMessage{
+		{Role: client.RoleUser, Content: "q1"},
+		{Role: client.RoleAssistant, Content: "a1"},
+	}}
+	if _, err := conversation.Ask("q2"); err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	request := server.lastRequest()
+	if request.Prompt != "q2" || len(request.Messages) != 0 || len(request.History) != 1 {
+		t.Errorf("request should be adapted to history format: %s", request)
+	}
+
+	_, err := cc.CreateCompletion(&client.CompletionRequest{
+		AppId:   "official-app",
+		Prompt:  "q2",
+		History: []client.ChatQaMessage{{User: "q1", Bot: "a1"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create completion: %v", err)
+	}
+
+	request = server.lastRequest()
+	if request.Prompt != "" || len(request.History) != 0 || len(request.Messages) != 3 {
+		t.Errorf("request should be adapted to messages format: %s", request)
+	}
+}
@@ -0,0 +1,447 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief doc tag discovery and name resolution for retrieval scoping
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	client "github.com/alibabacloud-go/bailian-20230601/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/internal/fileutil"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDocTagCacheTTL = 10 * time.Minute
+	DefaultDocTagPageSize = 100
+)
+
+var ErrDocTagNotFound = errors.New("Doc tag not found")
+
+// DocTag 文档标签, 检索时通过Id或Code限定范围
+type DocTag struct {
+	Id   int64  `json:"Id,omitempty"`
+	Code string `json:"Code,omitempty"`
+	Name string `json:"Name"`
+	// DataCount 带有该标签的文档数, 仅统计通过查询文档发现的标签
+	DataCount int `json:"DataCount,omitempty"`
+}
+
+func (t DocTag) String() string {
+	return tea.Prettify(t)
+}
+
+func (t DocTag) GoString() string {
+	return t.String()
+}
+
+// DocTagAPI DocTagClient使用的OpenAPI接口, *client.Client实现了该接口, 测试时可替换
+type DocTagAPI interface {
+	QueryEnterpriseDataList(request *client.QueryEnterpriseDataListRequest) (*client.QueryEnterpriseDataListResponse, error)
+}
+
+type docTagCache struct {
+	UpdatedAt int64    `json:"UpdatedAt"`
+	Tags      []DocTag `json:"Tags"`
+}
+
+// DocTagClient 通过查询企业数据发现文档上的标签, 在本地缓存标签名称到Id和Code的映射,
+// 以便使用可读的标签名构建检索请求. 并发安全.
+// bailian-20230601 OpenAPI没有创建标签的接口, 标签需在控制台创建, 或导入文档时通过ImportEnterpriseDocumentRequest.Tags指定;
+// 文档上只有名称、没有Id和Code的标签无法用于检索, 不会被发现, 可通过Define登记
+type DocTagClient struct {
+	// TokenClient 提供AccessKey、AgentKey和OpenAPI地址
+	TokenClient *AccessTokenClient
+	// StoreType 查询的数据存储类型, 为空时查询全部
+	StoreType string
+	// CacheTTL 缓存有效期, 为0时使用DefaultDocTagCacheTTL
+	CacheTTL time.Duration
+	// CacheFile 缓存文件路径, 非空时缓存持久化到文件, 多个进程可共享
+	CacheFile string
+	// API 为nil时使用TokenClient的AccessKey创建OpenAPI客户端
+	API DocTagAPI
+
+	mutex     sync.Mutex
+	tags      map[string]DocTag
+	defined   map[string]DocTag
+	updatedAt time.Time
+}
+
+// NewDocTagClient 使用AccessTokenClient的AccessKey和AgentKey创建DocTagClient
+func NewDocTagClient(tokenClient *AccessTokenClient) *DocTagClient {
+	return &DocTagClient{TokenClient: tokenClient}
+}
+
+func (c *DocTagClient) String() string {
+	return tea.Prettify(map[string]interface{}{"AgentKey": c.TokenClient.AgentKey, "Endpoint": c.TokenClient.Endpoint, "StoreType": c.StoreType})
+}
+
+func (c *DocTagClient) GoString() string {
+	return c.String()
+}
+
+// Define 登记已知的标签, 如控制台中创建但尚未关联文档的标签; 同名标签以登记的为准
+func (c *DocTagClient) Define(tags ...DocTag) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.defined == nil {
+		c.defined = make(map[string]DocTag)
+	}
+
+	for _, tag := range tags {
+		c.defined[tag.Name] = tag
+	}
+}
+
+// ListTags 返回所有标签, 按名称排序; 缓存过期时重新查询
+func (c *DocTagClient) ListTags() ([]DocTag, error) {
+	if _, err := c.load(false); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.sortedTags(), nil
+}
+
+// Refresh 忽略缓存重新查询标签
+func (c *DocTagClient) Refresh() error {
+	_, err := c.load(true)
+	return err
+}
+
+// Resolve 按名称查找标签, 有未找到的名称时刷新一次缓存, 仍未找到时返回ErrDocTagNotFound
+func (c *DocTagClient) Resolve(names ...string) ([]DocTag, error) {
+	queried, err := c.load(false)
+	if err != nil {
+		return nil, err
+	}
+
+	//标签可能在缓存之后才添加到文档上
+	tags, missing := c.lockedLookup(names)
+	if len(missing) > 0 && !queried {
+		if _, err = c.load(true); err != nil {
+			return nil, err
+		}
+		tags, missing = c.lockedLookup(names)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDocTagNotFound, strings.Join(missing, ", "))
+	}
+	return tags, nil
+}
+
+// ApplyTags 按名称设置请求的检索标签, 标签有Code时写入DocTagCodes, 否则写入DocTagIds
+func (c *DocTagClient) ApplyTags(request *CompletionRequest, names ...string) error {
+	tags, err := c.Resolve(names...)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if tag.Code != "" {
+			request.DocTagCodes = append(request.DocTagCodes, tag.Code)
+		} else {
+			request.DocTagIds = append(request.DocTagIds, tag.Id)
+		}
+	}
+	return nil
+}
+
+func (c *DocTagClient) lockedLookup(names []string) ([]DocTag, []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lookup(names)
+}
+
+func (c *DocTagClient) lookup(names []string) ([]DocTag, []string) {
+	var tags []DocTag
+	var missing []string
+	for _, name := range names {
+		if tag, ok := c.defined[name]; ok {
+			tags = append(tags, tag)
+		} else if tag, ok := c.tags[name]; ok {
+			tags = append(tags, tag)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	return tags, missing
+}
+
+func (c *DocTagClient) sortedTags() []DocTag {
+	merged := make(map[string]DocTag)
+	for name, tag := range c.tags {
+		merged[name] = tag
+	}
+
+	for name, tag := range c.defined {
+		if discovered, ok := merged[name]; ok {
+			tag.DataCount = discovered.DataCount
+		}
+		merged[name] = tag
+	}
+
+	tags := make([]DocTag, 0, len(merged))
+	for _, tag := range merged {
+		tags = append(tags, tag)
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags
+}
+
+// load 加载标签, 依次使用内存缓存、缓存文件和OpenAPI查询, 返回是否调用了OpenAPI;
+// 查询和读写文件时不持有锁, 并发刷新时以最新的结果为准
+func (c *DocTagClient) load(force bool) (bool, error) {
+	ttl := c.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultDocTagCacheTTL
+	}
+
+	c.mutex.Lock()
+	fresh := c.tags != nil && time.Since(c.updatedAt) < ttl
+	c.mutex.Unlock()
+
+	if !force && fresh {
+		return false, nil
+	}
+
+	if !force && c.CacheFile != "" {
+		cache, err := readDocTagCache(c.CacheFile)
+		if err == nil && time.Since(time.Unix(cache.UpdatedAt, 0)) < ttl {
+			c.setTags(cache.Tags, time.Unix(cache.UpdatedAt, 0))
+			return false, nil
+		}
+	}
+
+	tags, err := c.queryTags()
+	if err != nil {
+		return true, err
+	}
+
+	updatedAt := time.Now()
+	c.setTags(tags, updatedAt)
+	if c.CacheFile != "" {
+		cache := &docTagCache{UpdatedAt: updatedAt.Unix(), Tags: tags}
+		if err = writeDocTagCache(c.CacheFile, cache); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// setTags 更新内存缓存, 早于当前缓存的结果被忽略
+func (c *DocTagClient) setTags(tags []DocTag, updatedAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.tags != nil && updatedAt.Before(c.updatedAt) {
+		return
+	}
+
+	c.tags = make(map[string]DocTag, len(tags))
+	for _, tag := range tags {
+		c.tags[tag.Name] = tag
+	}
+	c.updatedAt = updatedAt
+}
+
+func (c *DocTagClient) api() (DocTagAPI, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.API != nil {
+		return c.API, nil
+	}
+
+	apiClient, err := c.TokenClient.newAPIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c.API = apiClient
+	return apiClient, nil
+}
+
+// queryTags 分页查询全部文档并汇总文档上的标签
+func (c *DocTagClient) queryTags() ([]DocTag, error) {
+	api, err := c.api()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]*DocTag)
+	var names []string
+	for pageNo := int32(1); ; pageNo++ {
+		request := &client.QueryEnterpriseDataListRequest{AgentKey: &c.TokenClient.AgentKey}
+		request.SetPageNo(pageNo).SetPageSize(DefaultDocTagPageSize)
+		if c.StoreType != "" {
+			request.SetStoreType(c.StoreType)
+		}
+
+		result, err := api.QueryEnterpriseDataList(request)
+		if err != nil {
+			return nil, err
+		}
+
+		resultBody := result.Body
+		if resultBody == nil || !tea.BoolValue(resultBody.Success) {
+			var requestId, message *string
+			if resultBody != nil {
+				requestId, message = resultBody.RequestId, resultBody.ErrorMsg
+			}
+
+			if requestId == nil {
+				requestId = result.Headers["x-acs-request-id"]
+			}
+
+			return nil, fmt.Errorf("Failed to query enterprise data, reason: %s RequestId: %s",
+				ToString(message), ToString(requestId))
+		}
+
+		if resultBody.Data == nil {
+			break
+		}
+
+		for _, data := range resultBody.Data.List {
+			if data == nil {
+				continue
+			}
+
+			for _, tag := range ParseDocTags(ToString(data.Tags)) {
+				existing, ok := tags[tag.Name]
+				if !ok {
+					existing = &DocTag{Name: tag.Name}
+					tags[tag.Name] = existing
+					names = append(names, tag.Name)
+				}
+
+				if existing.Id == 0 {
+					existing.Id = tag.Id
+				}
+				if existing.Code == "" {
+					existing.Code = tag.Code
+				}
+				existing.DataCount++
+			}
+		}
+
+		total := tea.Int64Value(resultBody.Data.Total)
+		if len(resultBody.Data.List) < DefaultDocTagPageSize || int64(pageNo)*DefaultDocTagPageSize >= total {
+			break
+		}
+	}
+
+	//只有名称的标签无法设置到请求中, 不作为发现的标签
+	result := make([]DocTag, 0, len(names))
+	for _, name := range names {
+		if tag := tags[name]; tag.Id != 0 || tag.Code != "" {
+			result = append(result, *tag)
+		}
+	}
+	return result, nil
+}
+
+// ParseDocTags 解析文档数据中的Tags字段, 兼容JSON对象数组、JSON字符串数组和逗号分隔的标签名
+func ParseDocTags(text string) []DocTag {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	var objects []map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &objects); err == nil {
+		var tags []DocTag
+		for _, object := range objects {
+			tag := DocTag{
+				Id:   int64Field(object, "id", "tagId", "TagId", "Id"),
+				Code: stringField(object, "code", "tagCode", "TagCode", "Code"),
+				Name: stringField(object, "name", "tagName", "TagName", "Name"),
+			}
+
+			if tag.Name != "" {
+				tags = append(tags, tag)
+			}
+		}
+		return tags
+	}
+
+	var names []string
+	if err := json.Unmarshal([]byte(text), &names); err != nil {
+		names = strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '，' })
+	}
+
+	var tags []DocTag
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			tags = append(tags, DocTag{Name: name})
+		}
+	}
+	return tags
+}
+
+func stringField(object map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch v := object[key].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatInt(int64(v), 10)
+		}
+	}
+	return ""
+}
+
+func int64Field(object map[string]interface{}, keys ...string) int64 {
+	for _, key := range keys {
+		switch v := object[key].(type) {
+		case float64:
+			return int64(v)
+		case string:
+			if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return id
+			}
+		}
+	}
+	return 0
+}
+
+func readDocTagCache(path string) (*docTagCache, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &docTagCache{}
+	if err = json.Unmarshal(data, cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+func writeDocTagCache(path string, cache *docTagCache) error {
+	return fileutil.WriteFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(cache)
+	})
+}
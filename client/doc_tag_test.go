@@ -0,0 +1,152 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for doc tag client
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"errors"
+	apiClient "github.com/alibabacloud-go/bailian-20230601/client"
+	"github.com/alibabacloud-go/tea/tea"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDocTagAPI 按分页参数返回文档数据, 每个文档带有一组标签
+type fakeDocTagAPI struct {
+	tags  []string
+	calls int
+}
+
+func (f *fakeDocTagAPI) QueryEnterpriseDataList(request *apiClient.QueryEnterpriseDataListRequest) (*apiClient.QueryEnterpriseDataListResponse, error) {
+	f.calls++
+	pageSize := int(tea.Int32Value(request.PageSize))
+	start := (int(tea.Int32Value(request.PageNo)) - 1) * pageSize
+	data := &apiClient.QueryEnterpriseDataListResponseBodyData{Total: tea.Int64(int64(len(f.tags)))}
+	for i := start; i < len(f.tags) && i < start+pageSize; i++ {
+		data.List = append(data.List, &apiClient.QueryEnterpriseDataListResponseBodyDataList{
+			DataId: tea.String("doc"),
+			Tags:   tea.String(f.tags[i]),
+		})
+	}
+
+	body := &apiClient.QueryEnterpriseDataListResponseBody{Success: tea.Bool(true), Data: data}
+	return &apiClient.QueryEnterpriseDataListResponse{Body: body}, nil
+}
+
+func TestParseDocTags(t *testing.T) {
+	tags := client.ParseDocTags(`[{"tagId":12,"tagCode":"471d3427","tagName":"售后"}]`)
+	if len(tags) != 1 || tags[0].Id != 12 || tags[0].Code != "471d3427" || tags[0].Name != "售后" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+
+	tags = client.ParseDocTags(`["售后", "物流"]`)
+	if len(tags) != 2 || tags[1].Name != "物流" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+
+	tags = client.ParseDocTags("售后, 物流，发票")
+	if len(tags) != 3 || tags[2].Name != "发票" {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
+
+func TestDocTagClient(t *testing.T) {
+	api := &fakeDocTagAPI{tags: []string{
+		`[{"id":1,"code":"c-after-sale","name":"售后"}]`,
+		`[{"id":1,"code":"c-after-sale","name":"售后"},{"id":2,"name":"物流"}]`,
+		//只有名称的标签无法用于检索, 不被发现
+		"退款",
+	}}
+
+	cacheFile := filepath.Join(t.TempDir(), "tags.json")
+	tagClient := &client.DocTagClient{TokenClient: &client.AccessTokenClient{AgentKey: "agent"}, API: api, CacheFile: cacheFile}
+	tagClient.Define(client.DocTag{Name: "发票", Code: "c-invoice"})
+
+	tags, err := tagClient.ListTags()
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+
+	if len(tags) != 3 || tags[0].Name != "发票" || tags[1].Name != "售后" || tags[1].DataCount != 2 || api.calls != 1 {
+		t.Fatalf("unexpected tags: %v, calls: %d", tags, api.calls)
+	}
+
+	request := &client.CompletionRequest{AppId: "app", Prompt: "怎么退货"}
+	if err = tagClient.ApplyTags(request, "售后", "物流", "发票"); err != nil {
+		t.Fatalf("failed to apply tags: %v", err)
+	}
+
+	if strings.Join(request.DocTagCodes, ",") != "c-after-sale,c-invoice" || len(request.DocTagIds) != 1 || request.DocTagIds[0] != 2 {
+		t.Errorf("unexpected request: %s", request)
+	}
+
+	//未找到时刷新一次缓存
+	_, err = tagClient.Resolve("退款")
+	if !errors.Is(err, client.ErrDocTagNotFound) || api.calls != 2 {
+		t.Errorf("expected not found after refresh, got: %v, calls: %d", err, api.calls)
+	}
+
+	//新的客户端从缓存文件加载
+	cached := &client.DocTagClient{TokenClient: &client.AccessTokenClient{AgentKey: "agent"}, API: &fakeDocTagAPI{}, CacheFile: cacheFile}
+	if tags, err = cached.Resolve("物流"); err != nil || tags[0].Id != 2 {
+		t.Errorf("failed to resolve from cache file: %v, err: %v", tags, err)
+	}
+}
+
+// blockingDocTagAPI 收到release前阻塞查询, 模拟慢速的OpenAPI调用
+type blockingDocTagAPI struct {
+	fakeDocTagAPI
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingDocTagAPI) QueryEnterpriseDataList(request *apiClient.QueryEnterpriseDataListRequest) (*apiClient.QueryEnterpriseDataListResponse, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeDocTagAPI.QueryEnterpriseDataList(request)
+}
+
+func TestDocTagClientUnlockedQuery(t *testing.T) {
+	api := &blockingDocTagAPI{fakeDocTagAPI: fakeDocTagAPI{tags: []string{`[{"id":1,"name":"售后"}]`}},
+		started: make(chan struct{}, 1), release: make(chan struct{})}
+	tagClient := client.NewDocTagClient(&client.AccessTokenClient{AgentKey: "agent"})
+	tagClient.API = api
+	tagClient.Define(client.DocTag{Name: "发票", Code: "c-invoice"})
+
+	refreshed := make(chan error, 1)
+	go func() {
+		refreshed <- tagClient.Refresh()
+	}()
+	<-api.started
+
+	//查询期间登记标签不被阻塞
+	defined := make(chan struct{})
+	go func() {
+		tagClient.Define(client.DocTag{Name: "物流", Id: 2})
+		close(defined)
+	}()
+
+	select {
+	case <-defined:
+	case <-time.After(time.Second):
+		t.Fatalf("define should not wait for the query")
+	}
+
+	close(api.release)
+	if err := <-refreshed; err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+
+	if tags, err := tagClient.Resolve("售后", "物流"); err != nil || len(tags) != 2 || tags[1].Id != 2 {
+		t.Errorf("unexpected tags: %v, err: %v", tags, err)
+	}
+}
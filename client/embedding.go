@@ -0,0 +1,308 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief text embedding client
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"errors"
+	"fmt"
+	client "github.com/alibabacloud-go/bailian-20230601/client"
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"github.com/alibabacloud-go/tea/tea"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// TextTypeQuery 检索时的查询文本
+	TextTypeQuery = "query"
+	// TextTypeDocument 入库的文档文本
+	TextTypeDocument = "document"
+)
+
+var ErrEmptyInput = errors.New("Embedding input is empty")
+
+// EmbeddingError 生成向量失败, 服务端返回Success为false或接口调用失败
+type EmbeddingError struct {
+	Code       string `json:"Code,omitempty"`
+	Message    string `json:"Message,omitempty"`
+	RequestId  string `json:"RequestId,omitempty"`
+	StatusCode int    `json:"StatusCode,omitempty"`
+}
+
+func (e *EmbeddingError) Error() string {
+	return fmt.Sprintf("Failed to create embeddings, code: %s, message: %s, requestId: %s",
+		e.Code, e.Message, e.RequestId)
+}
+
+// Temporary 是否为限流或服务端错误, 可以重试
+func (e *EmbeddingError) Temporary() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500 || e.Code == "Throttling" || e.Code == "ServiceUnavailable"
+}
+
+// EmbeddingUsage 用量信息; 接口不返回token数, Characters为本地统计的输入字符数
+type EmbeddingUsage struct {
+	Texts      int `json:"Texts"`
+	Characters int `json:"Characters"`
+}
+
+func (u EmbeddingUsage) String() string {
+	return tea.Prettify(u)
+}
+
+func (u EmbeddingUsage) GoString() string {
+	return u.String()
+}
+
+// EmbeddingResult 向量结果, Embeddings与输入文本顺序一致
+type EmbeddingResult struct {
+	Embeddings [][]float64    `json:"Embeddings"`
+	Usage      EmbeddingUsage `json:"Usage"`
+	RequestId  string         `json:"RequestId,omitempty"`
+}
+
+func (r EmbeddingResult) String() string {
+	return tea.Prettify(r)
+}
+
+func (r EmbeddingResult) GoString() string {
+	return r.String()
+}
+
+// Float32 返回float32精度的向量, 用于节省内存
+func (r *EmbeddingResult) Float32() [][]float32 {
+	result := make([][]float32, len(r.Embeddings))
+	for i, embedding := range r.Embeddings {
+		result[i] = make([]float32, len(embedding))
+		for j, v := range embedding {
+			result[i][j] = float32(v)
+		}
+	}
+	return result
+}
+
+// Embedder 文本向量化接口, 返回的向量与输入顺序一致
+type Embedder interface {
+	Embed(texts []string) (*EmbeddingResult, error)
+}
+
+// EmbeddingAPI EmbeddingClient使用的OpenAPI接口, *client.Client实现了该接口, 测试时可替换
+type EmbeddingAPI interface {
+	CreateTextEmbeddings(request *client.CreateTextEmbeddingsRequest) (*client.CreateTextEmbeddingsResponse, error)
+}
+
+// EmbeddingClient 调用CreateTextEmbeddings生成文本向量, 并发安全
+type EmbeddingClient struct {
+	AccessKeyId     string
+	AccessKeySecret string
+	AgentKey        string
+	Endpoint        string
+	// TextType 文本类型, TextTypeQuery或TextTypeDocument, 为空时使用服务端默认值
+	TextType string
+	// API 为nil时使用AccessKey创建OpenAPI客户端
+	API EmbeddingAPI
+	// Cache 向量缓存, 为nil时不缓存
+	Cache EmbeddingCache
+	// Model 模型标识, 与AgentKey、TextType一起作为缓存键; 服务端模型升级时修改该值使旧缓存失效
+	Model string
+
+	mutex sync.Mutex
+	stats EmbeddingCacheStats
+}
+
+// NewEmbeddingClient 使用AccessTokenClient的AccessKey和AgentKey创建EmbeddingClient
+func NewEmbeddingClient(tokenClient *AccessTokenClient) *EmbeddingClient {
+	return &EmbeddingClient{
+		AccessKeyId:     tokenClient.AccessKeyId,
+		AccessKeySecret: tokenClient.AccessKeySecret,
+		AgentKey:        tokenClient.AgentKey,
+		Endpoint:        tokenClient.Endpoint,
+	}
+}
+
+func (c *EmbeddingClient) String() string {
+	return tea.Prettify(map[string]interface{}{"AgentKey": c.AgentKey, "Endpoint": c.Endpoint, "TextType": c.TextType, "Model": c.Model})
+}
+
+func (c *EmbeddingClient) GoString() string {
+	return c.String()
+}
+
+func (c *EmbeddingClient) api() (EmbeddingAPI, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.API != nil {
+		return c.API, nil
+	}
+
+	if c.Endpoint == "" {
+		c.Endpoint = BroadscopeBailianPopEndpoint
+	}
+
+	config := &openapi.Config{AccessKeyId: &c.AccessKeyId,
+		AccessKeySecret: &c.AccessKeySecret,
+		Endpoint:        &c.Endpoint}
+
+	apiClient, err := client.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.API = apiClient
+	return apiClient, nil
+}
+
+// CacheStats 返回缓存命中统计
+func (c *EmbeddingClient) CacheStats() EmbeddingCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.stats
+}
+
+// Embed 生成文本向量, 返回的向量与texts顺序一致; 失败时返回*EmbeddingError.
+// 设置Cache时仅请求未命中缓存的文本, Usage只统计实际请求的文本
+func (c *EmbeddingClient) Embed(texts []string) (*EmbeddingResult, error) {
+	if len(texts) == 0 {
+		return nil, ErrEmptyInput
+	}
+
+	if c.Cache == nil {
+		return c.embed(texts)
+	}
+
+	embeddings := make([][]float64, len(texts))
+	keys := make([]string, len(texts))
+	//相同文本只请求一次
+	missing := make(map[string][]int)
+	var missingTexts []string
+	for i, text := range texts {
+		keys[i] = EmbeddingCacheKey(c.Model, c.AgentKey, c.TextType, text)
+		if embedding, ok := c.Cache.Get(keys[i]); ok {
+			embeddings[i] = embedding
+			continue
+		}
+
+		if _, ok := missing[text]; !ok {
+			missingTexts = append(missingTexts, text)
+		}
+		missing[text] = append(missing[text], i)
+	}
+
+	c.mutex.Lock()
+	c.stats.Hits += int64(len(texts) - len(missingTexts))
+	c.stats.Misses += int64(len(missingTexts))
+	c.mutex.Unlock()
+
+	result := &EmbeddingResult{Embeddings: embeddings}
+	if len(missingTexts) == 0 {
+		return result, nil
+	}
+
+	missed, err := c.embed(missingTexts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, text := range missingTexts {
+		for _, index := range missing[text] {
+			embeddings[index] = missed.Embeddings[i]
+		}
+
+		if err = c.Cache.Set(keys[missing[text][0]], missed.Embeddings[i]); err != nil {
+			return nil, fmt.Errorf("Failed to save embedding cache: %w", err)
+		}
+	}
+
+	result.Usage, result.RequestId = missed.Usage, missed.RequestId
+	return result, nil
+}
+
+func (c *EmbeddingClient) embed(texts []string) (*EmbeddingResult, error) {
+	api, err := c.api()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := EmbeddingUsage{Texts: len(texts)}
+	input := make([]*string, len(texts))
+	for i := range texts {
+		input[i] = tea.String(texts[i])
+		usage.Characters += utf8.RuneCountInString(texts[i])
+	}
+
+	request := &client.CreateTextEmbeddingsRequest{AgentKey: &c.AgentKey, Input: input}
+	if c.TextType != "" {
+		request.SetTextType(c.TextType)
+	}
+
+	result, err := api.CreateTextEmbeddings(request)
+	if err != nil {
+		var sdkErr *tea.SDKError
+		if errors.As(err, &sdkErr) {
+			return nil, &EmbeddingError{
+				Code:       tea.StringValue(sdkErr.Code),
+				Message:    tea.StringValue(sdkErr.Message),
+				StatusCode: tea.IntValue(sdkErr.StatusCode),
+			}
+		}
+		return nil, err
+	}
+
+	resultBody := result.Body
+	if resultBody == nil {
+		return nil, &EmbeddingError{Message: "empty response", StatusCode: int(tea.Int32Value(result.StatusCode))}
+	}
+
+	requestId := resultBody.RequestId
+	if requestId == nil {
+		requestId = result.Headers["x-acs-request-id"]
+	}
+
+	if !tea.BoolValue(resultBody.Success) {
+		statusCode, _ := strconv.Atoi(ToString(resultBody.HttpStatusCode))
+		return nil, &EmbeddingError{
+			Code:       ToString(resultBody.Code),
+			Message:    ToString(resultBody.Message),
+			RequestId:  ToString(requestId),
+			StatusCode: statusCode,
+		}
+	}
+
+	//按TextIndex还原为输入顺序
+	embeddings := make([][]float64, len(texts))
+	if resultBody.Data != nil {
+		for _, item := range resultBody.Data.Embeddings {
+			if item == nil || item.TextIndex == nil {
+				continue
+			}
+
+			index := int(*item.TextIndex)
+			if index < 0 || index >= len(texts) {
+				return nil, &EmbeddingError{Message: fmt.Sprintf("invalid text index %d", index), RequestId: ToString(requestId)}
+			}
+
+			embedding := make([]float64, len(item.Embedding))
+			for j, v := range item.Embedding {
+				embedding[j] = tea.Float64Value(v)
+			}
+			embeddings[index] = embedding
+		}
+	}
+
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return nil, &EmbeddingError{Message: fmt.Sprintf("missing embedding of text %d", i), RequestId: ToString(requestId)}
+		}
+	}
+
+	return &EmbeddingResult{Embeddings: embeddings, Usage: usage, RequestId: ToString(requestId)}, nil
+}
@@ -0,0 +1,326 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief bulk embedding with batching, concurrency, retries and checkpoints
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEmbeddingBatchSize 单次CreateTextEmbeddings调用的文本数
+	DefaultEmbeddingBatchSize     = 10
+	DefaultEmbeddingConcurrency   = 4
+	DefaultEmbeddingMaxRetries    = 3
+	DefaultEmbeddingRetryInterval = time.Second
+)
+
+// EmbeddingProgress 批量向量化进度
+type EmbeddingProgress struct {
+	// Done 已完成的文本数, 包含从检查点恢复的文本
+	Done  int `json:"Done"`
+	Total int `json:"Total"`
+	// Resumed 从检查点恢复的文本数
+	Resumed int `json:"Resumed"`
+	// Retries 累计重试次数
+	Retries int `json:"Retries"`
+}
+
+func (p EmbeddingProgress) String() string {
+	return tea.Prettify(p)
+}
+
+func (p EmbeddingProgress) GoString() string {
+	return p.String()
+}
+
+// BulkEmbedder 将大量文本按批次并发向量化, 失败的批次按指数退避重试, 结果与输入顺序一致.
+// 设置CheckpointFile时每个完成的批次追加写入检查点文件, 中断后使用相同输入重新调用会跳过已完成的批次,
+// 全部完成后删除检查点文件. BulkEmbedder本身也实现了Embedder
+type BulkEmbedder struct {
+	Embedder Embedder
+	// BatchSize 每批文本数, 为0时使用DefaultEmbeddingBatchSize
+	BatchSize int
+	// Concurrency 并发批次数, 为0时使用DefaultEmbeddingConcurrency
+	Concurrency int
+	// MaxRetries 每批最大重试次数, 为0时使用DefaultEmbeddingMaxRetries, 小于0时不重试
+	MaxRetries int
+	// RetryInterval 首次重试间隔, 之后每次翻倍, 为0时使用DefaultEmbeddingRetryInterval
+	RetryInterval time.Duration
+	// CheckpointFile 检查点文件路径, 为空时不记录检查点
+	CheckpointFile string
+	// OnProgress 每完成一批时回调, 回调不会并发执行
+	OnProgress func(progress EmbeddingProgress)
+}
+
+// checkpointRecord 检查点文件中的一行, Hash用于校验输入是否与记录时一致
+type checkpointRecord struct {
+	Start      int         `json:"Start"`
+	Hash       string      `json:"Hash"`
+	Embeddings [][]float64 `json:"Embeddings"`
+}
+
+type embeddingBatch struct {
+	start int
+	texts []string
+}
+
+func (b *BulkEmbedder) Embed(texts []string) (*EmbeddingResult, error) {
+	return b.EmbedContext(context.Background(), texts)
+}
+
+// EmbedContext 批量向量化, ctx取消或某批重试后仍失败时停止, 已完成的批次保留在检查点中.
+// Usage只统计本次调用实际请求的文本
+func (b *BulkEmbedder) EmbedContext(ctx context.Context, texts []string) (*EmbeddingResult, error) {
+	if len(texts) == 0 {
+		return nil, ErrEmptyInput
+	}
+
+	embeddings := make([][]float64, len(texts))
+	progress := EmbeddingProgress{Total: len(texts)}
+
+	var checkpoint *os.File
+	if b.CheckpointFile != "" {
+		resumed, err := loadCheckpoint(b.CheckpointFile, texts, embeddings)
+		if err != nil {
+			return nil, err
+		}
+
+		progress.Done, progress.Resumed = resumed, resumed
+		checkpoint, err = os.OpenFile(b.CheckpointFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		defer checkpoint.Close()
+
+		if err = terminateLastLine(checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	batches := b.batches(texts, embeddings)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var firstErr error
+	result := &EmbeddingResult{Embeddings: embeddings}
+
+	//完成一批后更新结果、写检查点并回调进度, 在锁内执行以保证顺序
+	complete := func(batch embeddingBatch, batchResult *EmbeddingResult, retries int, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		progress.Retries += retries
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Failed to embed texts %d-%d: %w", batch.start, batch.start+len(batch.texts)-1, err)
+				cancel()
+			}
+			return
+		}
+
+		copy(embeddings[batch.start:], batchResult.Embeddings)
+		result.Usage.Texts += batchResult.Usage.Texts
+		result.Usage.Characters += batchResult.Usage.Characters
+		progress.Done += len(batch.texts)
+
+		if checkpoint != nil && firstErr == nil {
+			record := checkpointRecord{Start: batch.start, Hash: hashTexts(batch.texts), Embeddings: batchResult.Embeddings}
+			if err := writeCheckpoint(checkpoint, &record); err != nil {
+				firstErr = err
+				cancel()
+				return
+			}
+		}
+
+		if b.OnProgress != nil {
+			b.OnProgress(progress)
+		}
+	}
+
+	concurrency := b.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultEmbeddingConcurrency
+	}
+
+	ch := make(chan embeddingBatch)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range ch {
+				batchResult, retries, err := b.embedBatch(ctx, batch.texts)
+				complete(batch, batchResult, retries, err)
+			}
+		}()
+	}
+
+	for _, batch := range batches {
+		select {
+		case ch <- batch:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(ch)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil {
+		_ = checkpoint.Close()
+		if err := os.Remove(b.CheckpointFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// batches 将尚未完成的连续文本按BatchSize划分批次
+func (b *BulkEmbedder) batches(texts []string, embeddings [][]float64) []embeddingBatch {
+	size := b.BatchSize
+	if size <= 0 {
+		size = DefaultEmbeddingBatchSize
+	}
+
+	var batches []embeddingBatch
+	for start := 0; start < len(texts); {
+		if embeddings[start] != nil {
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(texts) && end-start < size && embeddings[end] == nil {
+			end++
+		}
+
+		batches = append(batches, embeddingBatch{start: start, texts: texts[start:end]})
+		start = end
+	}
+	return batches
+}
+
+// embedBatch 调用Embedder, 可重试的错误按指数退避重试, 返回结果和重试次数
+func (b *BulkEmbedder) embedBatch(ctx context.Context, texts []string) (*EmbeddingResult, int, error) {
+	maxRetries := b.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultEmbeddingMaxRetries
+	}
+
+	interval := b.RetryInterval
+	if interval <= 0 {
+		interval = DefaultEmbeddingRetryInterval
+	}
+
+	for retries := 0; ; retries++ {
+		if err := ctx.Err(); err != nil {
+			return nil, retries, err
+		}
+
+		result, err := b.Embedder.Embed(texts)
+		if err == nil && len(result.Embeddings) != len(texts) {
+			err = &EmbeddingError{Message: fmt.Sprintf("expected %d embeddings, got %d", len(texts), len(result.Embeddings))}
+		}
+
+		if err == nil {
+			return result, retries, nil
+		}
+
+		if retries >= maxRetries || !isTemporary(ctx, err) {
+			return nil, retries, err
+		}
+
+		select {
+		case <-time.After(interval << uint(retries)):
+		case <-ctx.Done():
+			return nil, retries, ctx.Err()
+		}
+	}
+}
+
+func hashTexts(texts []string) string {
+	hash := sha256.New()
+	for _, text := range texts {
+		_, _ = fmt.Fprintf(hash, "%d:%s", len(text), text)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// loadCheckpoint 读取检查点并填充与当前输入一致的批次, 返回恢复的文本数; 文件不存在时返回0
+func loadCheckpoint(path string, texts []string, embeddings [][]float64) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	resumed := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			record := checkpointRecord{}
+			//进程中断时最后一行可能不完整, 跳过无法解析的行
+			if err = json.Unmarshal(line, &record); err == nil {
+				end := record.Start + len(record.Embeddings)
+				if record.Start >= 0 && end <= len(texts) && len(record.Embeddings) > 0 &&
+					hashTexts(texts[record.Start:end]) == record.Hash {
+					for i, embedding := range record.Embeddings {
+						if embeddings[record.Start+i] == nil {
+							resumed++
+						}
+						embeddings[record.Start+i] = embedding
+					}
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			return resumed, nil
+		}
+
+		if readErr != nil {
+			return 0, readErr
+		}
+	}
+}
+
+func writeCheckpoint(file *os.File, record *checkpointRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
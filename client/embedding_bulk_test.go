@@ -0,0 +1,198 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for bulk embedding
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"errors"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEmbedder 向量为[文本对应的数字], failures记录每个文本剩余的失败次数
+type fakeEmbedder struct {
+	mutex    sync.Mutex
+	calls    int
+	texts    int
+	failures map[string]int
+	err      error
+}
+
+func (f *fakeEmbedder) Embed(texts []string) (*client.EmbeddingResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls++
+	for _, text := range texts {
+		if f.failures[text] > 0 {
+			f.failures[text]--
+			return nil, f.err
+		}
+	}
+
+	result := &client.EmbeddingResult{Usage: client.EmbeddingUsage{Texts: len(texts)}}
+	for _, text := range texts {
+		n, _ := strconv.Atoi(text)
+		result.Embeddings = append(result.Embeddings, []float64{float64(n)})
+	}
+	f.texts += len(texts)
+	return result, nil
+}
+
+func numberTexts(n int) []string {
+	texts := make([]string, n)
+	for i := range texts {
+		texts[i] = strconv.Itoa(i)
+	}
+	return texts
+}
+
+func TestBulkEmbedder(t *testing.T) {
+	embedder := &fakeEmbedder{
+		failures: map[string]int{"5": 2},
+		err:      &client.EmbeddingError{Code: "Throttling", StatusCode: 429},
+	}
+
+	var progresses []client.EmbeddingProgress
+	bulk := &client.BulkEmbedder{
+		Embedder:      embedder,
+		BatchSize:     4,
+		Concurrency:   3,
+		RetryInterval: time.Millisecond,
+		OnProgress: func(progress client.EmbeddingProgress) {
+			progresses = append(progresses, progress)
+		},
+	}
+
+	result, err := bulk.Embed(numberTexts(23))
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+
+	for i, embedding := range result.Embeddings {
+		if embedding[0] != float64(i) {
+			t.Fatalf("unexpected embedding %d: %v", i, embedding)
+		}
+	}
+
+	last := progresses[len(progresses)-1]
+	if len(progresses) != 6 || last.Done != 23 || last.Retries != 2 || result.Usage.Texts != 23 || embedder.calls != 8 {
+		t.Errorf("unexpected progress: %v, usage: %s, calls: %d", last, result.Usage, embedder.calls)
+	}
+
+	//不可重试的错误直接返回
+	embedder.failures["1"], embedder.err = 1, &client.EmbeddingError{Code: "InvalidParameter", StatusCode: 400}
+	_, err = bulk.Embed(numberTexts(3))
+	var embeddingErr *client.EmbeddingError
+	if !errors.As(err, &embeddingErr) || embeddingErr.Code != "InvalidParameter" {
+		t.Errorf("expected invalid parameter error, got: %v", err)
+	}
+
+	//与BatchExecutor一致, 网络错误重试, 其他未知错误不重试
+	for _, c := range []struct {
+		err   error
+		calls int
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, 2},
+		{errors.New("failed to save embedding cache"), 1},
+	} {
+		embedder.calls, embedder.failures["1"], embedder.err = 0, 1, c.err
+		_, err = bulk.Embed(numberTexts(3))
+		if embedder.calls != c.calls {
+			t.Errorf("unexpected calls for %v: %d, err: %v", c.err, embedder.calls, err)
+		}
+	}
+}
+
+func TestBulkEmbedderCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "embedding.checkpoint")
+	embedder := &fakeEmbedder{failures: map[string]int{"12": 1}, err: errors.New("connection reset")}
+	bulk := &client.BulkEmbedder{
+		Embedder:       embedder,
+		BatchSize:      5,
+		Concurrency:    1,
+		MaxRetries:     -1,
+		CheckpointFile: checkpointFile,
+	}
+
+	texts := numberTexts(20)
+	if _, err := bulk.Embed(texts); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if _, err := os.Stat(checkpointFile); err != nil {
+		t.Fatalf("expected checkpoint file: %v", err)
+	}
+
+	//修改已完成批次中的文本, 该批次需要重新计算
+	texts[7] = "70"
+	embedder.texts = 0
+	var last client.EmbeddingProgress
+	bulk.OnProgress = func(progress client.EmbeddingProgress) {
+		last = progress
+	}
+
+	result, err := bulk.Embed(texts)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+
+	if embedder.texts != 15 || last.Resumed != 5 || last.Done != 20 || result.Embeddings[7][0] != 70 || result.Embeddings[19][0] != 19 {
+		t.Errorf("unexpected resume, texts: %d, progress: %v", embedder.texts, last)
+	}
+
+	if _, err = os.Stat(checkpointFile); !os.IsNotExist(err) {
+		t.Errorf("expected checkpoint removed, got: %v", err)
+	}
+}
+
+func TestBulkEmbedderCheckpointPartialLine(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "embedding.checkpoint")
+	embedder := &fakeEmbedder{failures: map[string]int{"12": 1}, err: errors.New("connection reset")}
+	bulk := &client.BulkEmbedder{Embedder: embedder, BatchSize: 5, Concurrency: 1, MaxRetries: -1, CheckpointFile: checkpointFile}
+
+	texts := numberTexts(20)
+	if _, err := bulk.Embed(texts); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	//模拟写检查点时进程中断, 最后一行不完整
+	file, err := os.OpenFile(checkpointFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open checkpoint: %v", err)
+	}
+	_, _ = file.WriteString(`{"Start":10,"Hash":"`)
+	_ = file.Close()
+
+	embedder.failures["17"] = 1
+	if _, err = bulk.Embed(texts); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	//第二次运行完成的批次写在新的一行, 第三次运行时可以恢复
+	embedder.texts = 0
+	var last client.EmbeddingProgress
+	bulk.OnProgress = func(progress client.EmbeddingProgress) {
+		last = progress
+	}
+
+	if _, err = bulk.Embed(texts); err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+
+	if embedder.texts != 5 || last.Resumed != 15 {
+		t.Errorf("unexpected resume, texts: %d, progress: %v", embedder.texts, last)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief embedding cache with memory lru and file backends
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/internal/fileutil"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const DefaultEmbeddingCacheCapacity = 10000
+
+// EmbeddingCache 向量缓存, 键由EmbeddingCacheKey生成
+type EmbeddingCache interface {
+	Get(key string) ([]float64, bool)
+	Set(key string, embedding []float64) error
+	// Clear 清空缓存
+	Clear() error
+}
+
+// EmbeddingCacheStats 缓存命中统计, 按文本计数
+type EmbeddingCacheStats struct {
+	Hits   int64 `json:"Hits"`
+	Misses int64 `json:"Misses"`
+}
+
+// HitRate 命中率, 没有请求时返回0
+func (s EmbeddingCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s EmbeddingCacheStats) String() string {
+	return tea.Prettify(s)
+}
+
+func (s EmbeddingCacheStats) GoString() string {
+	return s.String()
+}
+
+// EmbeddingCacheKey 由模型标识、AgentKey、文本类型和文本内容生成缓存键, 任一项变化都不会命中旧缓存
+func EmbeddingCacheKey(model, agentKey, textType, text string) string {
+	hash := sha256.New()
+	for _, part := range []string{model, agentKey, textType, text} {
+		_, _ = fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type embeddingCacheEntry struct {
+	key       string
+	embedding []float64
+}
+
+// MemoryEmbeddingCache 进程内LRU缓存, 并发安全
+type MemoryEmbeddingCache struct {
+	capacity int
+	mutex    sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+// NewMemoryEmbeddingCache capacity为缓存的向量数, 小于等于0时使用DefaultEmbeddingCacheCapacity
+func NewMemoryEmbeddingCache(capacity int) *MemoryEmbeddingCache {
+	if capacity <= 0 {
+		capacity = DefaultEmbeddingCacheCapacity
+	}
+	return &MemoryEmbeddingCache{capacity: capacity, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *MemoryEmbeddingCache) Get(key string) ([]float64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return append([]float64(nil), element.Value.(*embeddingCacheEntry).embedding...), true
+}
+
+func (c *MemoryEmbeddingCache) Set(key string, embedding []float64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	embedding = append([]float64(nil), embedding...)
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*embeddingCacheEntry).embedding = embedding
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&embeddingCacheEntry{key: key, embedding: embedding})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*embeddingCacheEntry).key)
+	}
+	return nil
+}
+
+func (c *MemoryEmbeddingCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	return nil
+}
+
+// Len 当前缓存的向量数
+func (c *MemoryEmbeddingCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
+
+// FileEmbeddingCache 每个向量保存为一个JSON文件, 按键的前两位分目录, 可在多次索引重建之间复用
+type FileEmbeddingCache struct {
+	Dir string
+}
+
+func NewFileEmbeddingCache(dir string) (*FileEmbeddingCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileEmbeddingCache{Dir: dir}, nil
+}
+
+func (c *FileEmbeddingCache) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(c.Dir, key+".json")
+	}
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+// Get 文件不存在或无法解析时视为未命中
+func (c *FileEmbeddingCache) Get(key string) ([]float64, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var embedding []float64
+	if err = json.Unmarshal(data, &embedding); err != nil || len(embedding) == 0 {
+		return nil, false
+	}
+	return embedding, true
+}
+
+func (c *FileEmbeddingCache) Set(key string, embedding []float64) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(embedding)
+	})
+}
+
+// Clear 删除缓存目录下的所有文件, 保留目录本身
+func (c *FileEmbeddingCache) Clear() error {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err = os.RemoveAll(filepath.Join(c.Dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
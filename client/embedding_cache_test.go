@@ -0,0 +1,76 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for embedding cache
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"testing"
+)
+
+func TestMemoryEmbeddingCache(t *testing.T) {
+	cache := client.NewMemoryEmbeddingCache(2)
+	_ = cache.Set("a", []float64{1})
+	_ = cache.Set("b", []float64{2})
+	cache.Get("a")
+	_ = cache.Set("c", []float64{3})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("expected least recently used entry evicted")
+	}
+
+	if embedding, ok := cache.Get("a"); !ok || embedding[0] != 1 || cache.Len() != 2 {
+		t.Errorf("unexpected cache entry: %v, len: %d", embedding, cache.Len())
+	}
+}
+
+func TestEmbeddingClientCache(t *testing.T) {
+	cache, err := client.NewFileEmbeddingCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	api := &fakeEmbeddingAPI{}
+	embeddingClient := &client.EmbeddingClient{AgentKey: "agent", API: api, Cache: cache, Model: "text-embedding-v1"}
+	if _, err = embeddingClient.Embed([]string{"退货", "运费"}); err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+
+	//已缓存的文本不再请求, 重复文本只请求一次
+	result, err := embeddingClient.Embed([]string{"发票", "退货", "发票"})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+
+	if len(api.requests) != 2 || len(api.requests[1].Input) != 1 || result.Usage.Texts != 1 {
+		t.Fatalf("unexpected requests: %d, usage: %s", len(api.requests), result.Usage)
+	}
+
+	if result.Embeddings[1][1] != 2 || result.Embeddings[0][1] != 2 || result.Embeddings[2] == nil {
+		t.Errorf("unexpected embeddings: %v", result.Embeddings)
+	}
+
+	if stats := embeddingClient.CacheStats(); stats.Hits != 2 || stats.Misses != 3 || stats.HitRate() != 0.4 {
+		t.Errorf("unexpected stats: %s", stats)
+	}
+
+	//更换模型后缓存失效
+	embeddingClient.Model = "text-embedding-v2"
+	if _, err = embeddingClient.Embed([]string{"退货"}); err != nil || len(api.requests) != 3 {
+		t.Errorf("expected cache miss after model change, requests: %d, err: %v", len(api.requests), err)
+	}
+
+	if err = cache.Clear(); err != nil {
+		t.Fatalf("failed to clear cache: %v", err)
+	}
+
+	if _, ok := cache.Get(client.EmbeddingCacheKey("text-embedding-v2", "agent", "", "退货")); ok {
+		t.Errorf("expected cache cleared")
+	}
+}
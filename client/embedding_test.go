@@ -12,14 +12,84 @@
 package broadscope_bailian_test
 
 import (
+	"errors"
 	"fmt"
 	apiClient "github.com/alibabacloud-go/bailian-20230601/client"
 	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"github.com/alibabacloud-go/tea/tea"
 	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
 	"os"
 	"testing"
+	"unicode/utf8"
 )
 
+// fakeEmbeddingAPI 以倒序返回向量, 向量为[文本序号, 文本长度]
+type fakeEmbeddingAPI struct {
+	requests []*apiClient.CreateTextEmbeddingsRequest
+	fail     *apiClient.CreateTextEmbeddingsResponseBody
+}
+
+func (f *fakeEmbeddingAPI) CreateTextEmbeddings(request *apiClient.CreateTextEmbeddingsRequest) (*apiClient.CreateTextEmbeddingsResponse, error) {
+	f.requests = append(f.requests, request)
+	if f.fail != nil {
+		return &apiClient.CreateTextEmbeddingsResponse{Body: f.fail}, nil
+	}
+
+	data := &apiClient.CreateTextEmbeddingsResponseBodyData{}
+	for i := len(request.Input) - 1; i >= 0; i-- {
+		length := float64(utf8.RuneCountInString(*request.Input[i]))
+		data.Embeddings = append(data.Embeddings, &apiClient.CreateTextEmbeddingsResponseBodyDataEmbeddings{
+			Embedding: []*float64{tea.Float64(float64(i)), tea.Float64(length)},
+			TextIndex: tea.Int32(int32(i)),
+		})
+	}
+
+	body := &apiClient.CreateTextEmbeddingsResponseBody{Success: tea.Bool(true), RequestId: tea.String("req"), Data: data}
+	return &apiClient.CreateTextEmbeddingsResponse{Body: body}, nil
+}
+
+func TestEmbeddingClient(t *testing.T) {
+	api := &fakeEmbeddingAPI{}
+	embeddingClient := client.NewEmbeddingClient(&client.AccessTokenClient{AgentKey: "agent"})
+	embeddingClient.API = api
+	embeddingClient.TextType = client.TextTypeQuery
+
+	result, err := embeddingClient.Embed([]string{"今天天气", "hello", "好"})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+
+	if len(result.Embeddings) != 3 || result.Embeddings[1][0] != 1 || result.Embeddings[1][1] != 5 || result.Embeddings[2][1] != 1 {
+		t.Errorf("unexpected embeddings: %v", result.Embeddings)
+	}
+
+	if result.Usage.Texts != 3 || result.Usage.Characters != 10 || result.RequestId != "req" || result.Float32()[0][1] != 4 {
+		t.Errorf("unexpected result: %s", result)
+	}
+
+	if request := api.requests[0]; tea.StringValue(request.AgentKey) != "agent" || tea.StringValue(request.TextType) != "query" {
+		t.Errorf("unexpected request: %s", request)
+	}
+
+	api.fail = &apiClient.CreateTextEmbeddingsResponseBody{
+		Success:        tea.Bool(false),
+		Code:           tea.String("Throttling"),
+		Message:        tea.String("too many requests"),
+		HttpStatusCode: tea.String("429"),
+		RequestId:      tea.String("req"),
+	}
+	_, err = embeddingClient.Embed([]string{"hello"})
+
+	var embeddingErr *client.EmbeddingError
+	if !errors.As(err, &embeddingErr) || embeddingErr.StatusCode != 429 || !embeddingErr.Temporary() {
+		t.Errorf("expected embedding error, got: %v", err)
+	}
+
+	if _, err = embeddingClient.Embed(nil); !errors.Is(err, client.ErrEmptyInput) {
+		t.Errorf("expected empty input error, got: %v", err)
+	}
+}
+
 func TestCreateTextEmbeddings(t *testing.T) {
 	accessKeyId := os.Getenv("ACCESS_KEY_ID")
 	accessKeySecret := os.Getenv("ACCESS_KEY_SECRET")
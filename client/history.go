@@ -0,0 +1,226 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief history window strategies applied before completion
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+// MessageOverheadTokens 每条消息除内容外的格式token数, 如<|im_start|>role\n ... <|im_end|>\n
+const MessageOverheadTokens = 4
+
+var ErrHistoryBudgetExceeded = errors.New("Request exceeds token budget after trimming history")
+
+// TokenEstimator 估算文本的token数
+type TokenEstimator interface {
+	EstimateTokens(text string) int
+}
+
+// RequestTokenEstimator 可按模型规则估算完整请求的token数, 实现该接口的TokenEstimator优先使用此方法
+type RequestTokenEstimator interface {
+	EstimateRequestTokens(request *CompletionRequest) int
+}
+
+// SimpleTokenEstimator 不依赖词表的粗略估算, 中日韩字符按1个token计算, 其他字符按4个字符1个token计算, 结果通常偏大
+type SimpleTokenEstimator struct{}
+
+func (SimpleTokenEstimator) EstimateTokens(text string) int {
+	cjk, others := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			others++
+		}
+	}
+	return cjk + (others+3)/4
+}
+
+// EstimateRequestTokens 估算请求中Prompt、Messages和History的token数
+func EstimateRequestTokens(estimator TokenEstimator, request *CompletionRequest) int {
+	if estimator == nil {
+		estimator = SimpleTokenEstimator{}
+	}
+
+	if requestEstimator, ok := estimator.(RequestTokenEstimator); ok {
+		return requestEstimator.EstimateRequestTokens(request)
+	}
+
+	total := 0
+	if request.Prompt != "" {
+		total += estimator.EstimateTokens(request.Prompt) + MessageOverheadTokens
+	}
+
+	for _, message := range request.Messages {
+		total += estimator.EstimateTokens(message.Content) + MessageOverheadTokens
+	}
+
+	for _, message := range request.History {
+		total += estimator.EstimateTokens(message.User) + estimator.EstimateTokens(message.Bot) + 2*MessageOverheadTokens
+	}
+
+	return total
+}
+
+// HistoryStrategy 在发送请求前裁剪Messages或History, 可设置在CompletionClient.HistoryStrategy上自动生效
+type HistoryStrategy interface {
+	Trim(request *CompletionRequest) error
+}
+
+// HistoryStrategies 按顺序依次应用多个策略
+type HistoryStrategies []HistoryStrategy
+
+func (s HistoryStrategies) Trim(request *CompletionRequest) error {
+	for _, strategy := range s {
+		if err := strategy.Trim(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastTurnsStrategy 只保留最近Turns轮历史对话, Turns小于等于0时不保留历史; system消息和当前用户消息始终保留
+type LastTurnsStrategy struct {
+	Turns int
+}
+
+func (s LastTurnsStrategy) Trim(request *CompletionRequest) error {
+	turns := s.Turns
+	if turns < 0 {
+		turns = 0
+	}
+
+	if len(request.History) > turns {
+		request.History = append([]ChatQaMessage(nil), request.History[len(request.History)-turns:]...)
+	}
+
+	window := newMessageWindow(request.Messages)
+	for len(window.turns) > turns {
+		window.dropTurn(0)
+	}
+	request.Messages = window.messages()
+	return nil
+}
+
+// TokenBudgetStrategy 保证请求的估算token数加上预留的输出token数不超过MaxContextTokens.
+// 超出时优先丢弃最早的工具观察结果, 再按轮次丢弃最早的历史对话; system消息和当前用户消息始终保留
+type TokenBudgetStrategy struct {
+	MaxContextTokens int
+	// ReservedOutputTokens 为输出预留的token数, 为0时使用请求参数中的MaxTokens
+	ReservedOutputTokens int
+	// Estimator 为nil时使用SimpleTokenEstimator
+	Estimator TokenEstimator
+}
+
+func (s TokenBudgetStrategy) Trim(request *CompletionRequest) error {
+	reserved := s.ReservedOutputTokens
+	if reserved == 0 && request.Parameters != nil {
+		reserved = int(request.Parameters.MaxTokens)
+	}
+
+	budget := s.MaxContextTokens - reserved
+	estimate := func() int {
+		return EstimateRequestTokens(s.Estimator, request)
+	}
+
+	window := newMessageWindow(request.Messages)
+	for estimate() > budget {
+		if window.dropOldestTool() || window.dropTurn(0) {
+			request.Messages = window.messages()
+			continue
+		}
+
+		if len(request.History) > 0 {
+			request.History = append([]ChatQaMessage(nil), request.History[1:]...)
+			continue
+		}
+
+		return fmt.Errorf("%w, estimated: %d, budget: %d", ErrHistoryBudgetExceeded, estimate(), budget)
+	}
+
+	return nil
+}
+
+// messageWindow 将Messages拆分为开头的system消息、可裁剪的历史轮次和从最后一条用户消息开始的当前轮次
+type messageWindow struct {
+	system  []ChatCompletionMessage
+	turns   [][]ChatCompletionMessage
+	current []ChatCompletionMessage
+}
+
+func newMessageWindow(messages []ChatCompletionMessage) *messageWindow {
+	window := &messageWindow{}
+
+	start := 0
+	for start < len(messages) && messages[start].Role == RoleSystem {
+		start++
+	}
+	window.system = messages[:start]
+
+	last := len(messages)
+	for i := len(messages) - 1; i >= start; i-- {
+		if messages[i].Role == RoleUser {
+			last = i
+			break
+		}
+	}
+	window.current = messages[last:]
+
+	for _, message := range messages[start:last] {
+		if message.Role == RoleUser || len(window.turns) == 0 {
+			window.turns = append(window.turns, nil)
+		}
+
+		index := len(window.turns) - 1
+		window.turns[index] = append(window.turns[index], message)
+	}
+
+	return window
+}
+
+func (w *messageWindow) dropTurn(index int) bool {
+	if index >= len(w.turns) {
+		return false
+	}
+
+	w.turns = append(w.turns[:index:index], w.turns[index+1:]...)
+	return true
+}
+
+func (w *messageWindow) dropOldestTool() bool {
+	for i, turn := range w.turns {
+		for j, message := range turn {
+			if message.Role != RoleTool {
+				continue
+			}
+
+			w.turns[i] = append(turn[:j:j], turn[j+1:]...)
+			if len(w.turns[i]) == 0 {
+				w.dropTurn(i)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (w *messageWindow) messages() []ChatCompletionMessage {
+	if len(w.system) == 0 && len(w.turns) == 0 && len(w.current) == 0 {
+		return nil
+	}
+
+	messages := append([]ChatCompletionMessage(nil), w.system...)
+	for _, turn := range w.turns {
+		messages = append(messages, turn...)
+	}
+	return append(messages, w.current...)
+}
@@ -0,0 +1,160 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for history window strategies
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"errors"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"testing"
+)
+
+func historyMessages() []client.ChatCompletionMessage {
+	return []client.ChatCompletionMessage{
+		{Role: client.RoleSystem, Content: "system"},
+		{Role: client.RoleUser, Content: "q1"},
+		{Role: client.RoleAssistant, Content: "a1"},
+		{Role: client.RoleUser, Content: "q2"},
+		{Role: client.RoleTool, Content: strings.Repeat("observation ", 50)},
+		{Role: client.RoleAssistant, Content: "a2"},
+		{Role: client.RoleUser, Content: "q3"},
+		{Role: client.RoleAssistant, Content: "a3"},
+		{Role: client.RoleUser, Content: "q4"},
+	}
+}
+
+func contents(messages []client.ChatCompletionMessage) string {
+	var result []string
+	for _, message := range messages {
+		if message.Role == client.RoleTool {
+			result = append(result, "tool")
+		} else {
+			result = append(result, message.Content)
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+func TestSimpleTokenEstimator(t *testing.T) {
+	estimator := client.SimpleTokenEstimator{}
+	if tokens := estimator.EstimateTokens("春秋战国"); tokens != 4 {
+		t.Errorf("unexpected tokens: %d", tokens)
+	}
+
+	if tokens := estimator.EstimateTokens("hello world!"); tokens != 3 {
+		t.Errorf("unexpected tokens: %d", tokens)
+	}
+
+	request := &client.CompletionRequest{
+		Prompt:  "abcd",
+		History: []client.ChatQaMessage{{User: "abcd", Bot: "abcd"}},
+	}
+	expected := 1 + client.MessageOverheadTokens + 2 + 2*client.MessageOverheadTokens
+	if tokens := client.EstimateRequestTokens(nil, request); tokens != expected {
+		t.Errorf("unexpected request tokens: %d, expected: %d", tokens, expected)
+	}
+}
+
+func TestLastTurnsStrategy(t *testing.T) {
+	original := historyMessages()
+	request := &client.CompletionRequest{
+		Messages: original,
+		History:  []client.ChatQaMessage{{User: "1"}, {User: "2"}, {User: "3"}},
+	}
+
+	if err := (client.LastTurnsStrategy{Turns: 1}).Trim(request); err != nil {
+		t.Fatalf("failed to trim: %v", err)
+	}
+
+	if got := contents(request.Messages); got != "system,q3,a3,q4" {
+		t.Errorf("unexpected messages: %s", got)
+	}
+
+	if len(request.History) != 1 || request.History[0].User != "3" {
+		t.Errorf("unexpected history: %v", request.History)
+	}
+
+	if contents(original) != contents(historyMessages()) {
+		t.Errorf("caller messages should not be modified")
+	}
+
+	//Turns小于等于0时不保留历史
+	for _, turns := range []int{0, -1} {
+		request = &client.CompletionRequest{Messages: historyMessages(), History: []client.ChatQaMessage{{User: "1"}}}
+		if err := (client.LastTurnsStrategy{Turns: turns}).Trim(request); err != nil {
+			t.Fatalf("failed to trim: %v", err)
+		}
+
+		if got := contents(request.Messages); got != "system,q4" || len(request.History) != 0 {
+			t.Errorf("unexpected messages for %d turns: %s, history: %v", turns, got, request.History)
+		}
+	}
+}
+
+func TestTokenBudgetStrategy(t *testing.T) {
+	request := &client.CompletionRequest{
+		Messages:   historyMessages(),
+		Parameters: &client.CompletionRequestModelParameter{MaxTokens: 20},
+	}
+
+	//工具观察结果约150个token, 预算不足时应首先被丢弃
+	strategy := client.TokenBudgetStrategy{MaxContextTokens: 80}
+	if err := strategy.Trim(request); err != nil {
+		t.Fatalf("failed to trim: %v", err)
+	}
+
+	if got := contents(request.Messages); got != "system,q1,a1,q2,a2,q3,a3,q4" {
+		t.Errorf("unexpected messages: %s", got)
+	}
+
+	strategy.MaxContextTokens = 45
+	if err := strategy.Trim(request); err != nil {
+		t.Fatalf("failed to trim: %v", err)
+	}
+
+	if got := contents(request.Messages); got != "system,q3,a3,q4" {
+		t.Errorf("unexpected messages: %s", got)
+	}
+
+	strategy.MaxContextTokens = 30
+	err := strategy.Trim(request)
+	if !errors.Is(err, client.ErrHistoryBudgetExceeded) {
+		t.Errorf("expected budget exceeded, got: %v", err)
+	}
+
+	if got := contents(request.Messages); got != "system,q4" {
+		t.Errorf("system and current message should be kept, got: %s", got)
+	}
+}
+
+func TestCompletionClientHistoryStrategy(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{messageResponse("ok", client.FinishReasonStop)}
+	})
+
+	cc := server.client()
+	cc.HistoryStrategy = client.HistoryStrategies{
+		client.LastTurnsStrategy{Turns: 2},
+		client.TokenBudgetStrategy{MaxContextTokens: 1000},
+	}
+
+	conversation := &client.Conversation{Client: cc, AppId: "app", Turns: historyMessages()[1:]}
+	if _, err := conversation.Ask("q5"); err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	if got := contents(server.lastRequest().Messages); got != "q3,a3,q4,q5" {
+		t.Errorf("unexpected messages: %s", got)
+	}
+
+	if len(conversation.Turns) != 10 {
+		t.Errorf("conversation should keep full history, got: %d turns", len(conversation.Turns))
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief mock completion server for offline test cases
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"encoding/json"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// mockCompletionServer 记录收到的请求, 并按handler返回结果; 流式请求时每个结果作为一个SSE事件返回
+type mockCompletionServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*client.CompletionRequest
+	handler  func(request *client.CompletionRequest) []*client.CompletionResponse
+}
+
+func newMockCompletionServer(t *testing.T, handler func(request *client.CompletionRequest) []*client.CompletionResponse) *mockCompletionServer {
+	server := &mockCompletionServer{handler: handler}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &client.CompletionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		server.mutex.Lock()
+		server.requests = append(server.requests, request)
+		server.mutex.Unlock()
+
+		responses := server.handler(request)
+		if !request.Stream {
+			_ = json.NewEncoder(w).Encode(responses[0])
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, response := range responses {
+			data, _ := json.Marshal(response)
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		_, _ = fmt.Fprintf(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *mockCompletionServer) client() *client.CompletionClient {
+	return &client.CompletionClient{Token: "token", Endpoint: s.URL}
+}
+
+func (s *mockCompletionServer) lastRequest() *client.CompletionRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func (s *mockCompletionServer) requestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.requests)
+}
+
+func textResponse(text string) *client.CompletionResponse {
+	return &client.CompletionResponse{
+		Success:   true,
+		RequestId: "mock",
+		Data:      &client.CompletionResponseData{ResponseId: "mock", Text: text},
+	}
+}
+
+func messageResponse(content string, finishReason client.FinishReason) *client.CompletionResponse {
+	return &client.CompletionResponse{
+		Success:   true,
+		RequestId: "mock",
+		Data: &client.CompletionResponseData{
+			ResponseId: "mock",
+			Choices: []client.CompletionResponseChoice{{
+				FinishReason: finishReason,
+				Message:      &client.CompletionResponseMessage{Role: client.RoleAssistant, Content: content},
+			}},
+		},
+	}
+}
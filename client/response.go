@@ -0,0 +1,124 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief nil-safe accessors for completion response
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ResponseError 服务端返回Success为false时的错误信息
+type ResponseError struct {
+	Code      string `json:"Code,omitempty"`
+	Message   string `json:"Message,omitempty"`
+	RequestId string `json:"RequestId,omitempty"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("Failed to complete request, code: %s, message: %s, requestId: %s",
+		e.Code, e.Message, e.RequestId)
+}
+
+// Temporary 是否为限流或服务端错误, 可以重试
+func (e *ResponseError) Temporary() bool {
+	return strings.Contains(e.Code, "Throttling") || e.Code == "ServiceUnavailable" || e.Code == "InternalError"
+}
+
+// StatusError 服务端返回非200状态码
+type StatusError struct {
+	StatusCode int    `json:"StatusCode"`
+	Body       string `json:"Body,omitempty"`
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Failed to complete request, code: %d, message: %s", e.StatusCode, e.Body)
+}
+
+// Temporary 是否为限流或服务端错误, 可以重试
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// FirstChoice 返回第一个choice, ResultFormat为text或Choices为空时返回nil
+func (cr *CompletionResponse) FirstChoice() *CompletionResponseChoice {
+	if cr == nil || cr.Data == nil || len(cr.Data.Choices) == 0 {
+		return nil
+	}
+	return &cr.Data.Choices[0]
+}
+
+// OutputText 返回生成的文本, 兼容text和message两种返回结构; 流式增量输出时为当前片段
+func (cr *CompletionResponse) OutputText() string {
+	choice := cr.FirstChoice()
+	if choice != nil && choice.Message != nil {
+		return choice.Message.Content
+	}
+
+	if cr == nil || cr.Data == nil {
+		return ""
+	}
+	return cr.Data.Text
+}
+
+// FinishReason 返回第一个choice的结束原因, 没有choice时返回空值
+func (cr *CompletionResponse) FinishReason() FinishReason {
+	choice := cr.FirstChoice()
+	if choice == nil {
+		return ""
+	}
+	return choice.FinishReason
+}
+
+// TotalUsage 汇总所有模型的token用量, 多个模型时ModelId以逗号分隔
+func (cr *CompletionResponse) TotalUsage() CompletionResponseDataUsage {
+	total := CompletionResponseDataUsage{}
+	if cr == nil || cr.Data == nil {
+		return total
+	}
+
+	var modelIds []string
+	for _, usage := range cr.Data.Usage {
+		total.InputTokens += usage.InputTokens
+		total.OutputTokens += usage.OutputTokens
+
+		if usage.ModelId == "" {
+			continue
+		}
+
+		duplicated := false
+		for _, modelId := range modelIds {
+			if modelId == usage.ModelId {
+				duplicated = true
+				break
+			}
+		}
+
+		if !duplicated {
+			modelIds = append(modelIds, usage.ModelId)
+		}
+	}
+
+	total.ModelId = strings.Join(modelIds, ",")
+	return total
+}
+
+// Err 请求失败时返回*ResponseError, 成功时返回nil
+func (cr *CompletionResponse) Err() error {
+	if cr == nil {
+		return &ResponseError{Message: "empty response"}
+	}
+
+	if cr.Success {
+		return nil
+	}
+
+	return &ResponseError{Code: cr.Code, Message: cr.Message, RequestId: cr.RequestId}
+}
@@ -0,0 +1,88 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for completion response accessors
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"errors"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"testing"
+)
+
+func TestResponseAccessorsNilSafe(t *testing.T) {
+	var nilResponse *client.CompletionResponse
+	responses := []*client.CompletionResponse{
+		nilResponse,
+		{Success: true},
+		{Success: true, Data: &client.CompletionResponseData{}},
+		{Success: true, Data: &client.CompletionResponseData{Choices: []client.CompletionResponseChoice{{}}}},
+	}
+
+	for _, response := range responses {
+		if response.OutputText() != "" || response.FinishReason() != "" {
+			t.Errorf("expected empty output, got: %q", response.OutputText())
+		}
+
+		usage := response.TotalUsage()
+		if usage.InputTokens != 0 || usage.OutputTokens != 0 {
+			t.Errorf("expected empty usage, got: %s", usage)
+		}
+	}
+
+	if nilResponse.FirstChoice() != nil || nilResponse.Err() == nil {
+		t.Errorf("nil response should have no choice and an error")
+	}
+}
+
+func TestResponseAccessors(t *testing.T) {
+	textResponse := &client.CompletionResponse{
+		Success: true,
+		Data: &client.CompletionResponseData{
+			Text: "text answer",
+			Usage: []client.CompletionResponseDataUsage{
+				{InputTokens: 10, OutputTokens: 5, ModelId: "qwen-plus"},
+				{InputTokens: 3, OutputTokens: 2, ModelId: "qwen-max"},
+				{InputTokens: 1, OutputTokens: 1, ModelId: "qwen-plus"},
+			},
+		},
+	}
+
+	if textResponse.OutputText() != "text answer" || textResponse.FirstChoice() != nil {
+		t.Errorf("unexpected text output: %q", textResponse.OutputText())
+	}
+
+	usage := textResponse.TotalUsage()
+	if usage.InputTokens != 14 || usage.OutputTokens != 8 || usage.ModelId != "qwen-plus,qwen-max" {
+		t.Errorf("unexpected total usage: %s", usage)
+	}
+
+	messageResponse := &client.CompletionResponse{
+		Success: true,
+		Data: &client.CompletionResponseData{
+			Choices: []client.CompletionResponseChoice{{
+				FinishReason: client.FinishReasonStop,
+				Message:      &client.CompletionResponseMessage{Role: client.RoleAssistant, Content: "message answer"},
+			}},
+		},
+	}
+
+	if messageResponse.OutputText() != "message answer" || messageResponse.FinishReason() != client.FinishReasonStop {
+		t.Errorf("unexpected message output: %q", messageResponse.OutputText())
+	}
+
+	if messageResponse.Err() != nil {
+		t.Errorf("successful response should not have error")
+	}
+
+	failedResponse := &client.CompletionResponse{Code: "InvalidParameter", Message: "bad app", RequestId: "r1"}
+	var responseError *client.ResponseError
+	if !errors.As(failedResponse.Err(), &responseError) || responseError.Code != "InvalidParameter" || responseError.RequestId != "r1" {
+		t.Errorf("unexpected error: %v", failedResponse.Err())
+	}
+}
@@ -0,0 +1,345 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief json schema derived from go types
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSONSchema JSON Schema的子集, 字段名遵循JSON Schema规范
+type JSONSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	// Nullable 值可以为null, 对应OpenAPI的nullable
+	Nullable bool `json:"nullable,omitempty"`
+}
+
+func (s JSONSchema) String() string {
+	return tea.Prettify(s)
+}
+
+func (s JSONSchema) GoString() string {
+	return s.String()
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// SchemaOf 根据Go类型生成JSON Schema, v可以是值、指针或reflect.Type.
+// 字段名与encoding/json一致, 没有omitempty且不能为nil的字段为必填, 指针、切片和map允许为null;
+// 可通过desc标签添加字段说明, 通过enum标签以逗号分隔列出可选值
+func SchemaOf(v interface{}) (*JSONSchema, error) {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+
+	if t == nil {
+		return nil, fmt.Errorf("Failed to derive schema from nil")
+	}
+
+	//顶层的指针仅用于传参, 不允许null
+	return schemaOfType(t, make(map[reflect.Type]bool))
+}
+
+func schemaOf(t reflect.Type, visiting map[reflect.Type]bool) (*JSONSchema, error) {
+	schema, err := schemaOfType(t, visiting)
+	if err != nil {
+		return nil, err
+	}
+
+	//nil值序列化为null, 没有类型约束的schema本身允许null
+	if schema.Type != "" && isNilable(t) {
+		schema.Nullable = true
+	}
+	return schema, nil
+}
+
+func isNilable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+func schemaOfType(t reflect.Type, visiting map[reflect.Type]bool) (*JSONSchema, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &JSONSchema{Type: "string", Format: "date-time"}, nil
+	}
+
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		//自定义序列化的类型无法推导结构, 不做约束
+		return &JSONSchema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}, nil
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}, nil
+	case reflect.Interface:
+		return &JSONSchema{}, nil
+	case reflect.Slice, reflect.Array:
+		//[]byte与encoding/json一致编码为base64字符串
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", ContentEncoding: "base64"}, nil
+		}
+
+		items, err := schemaOf(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("Unsupported map key type %s", t.Key())
+		}
+
+		values, err := schemaOf(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			return nil, fmt.Errorf("Recursive type %s is not supported", t)
+		}
+
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		schema := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		if err := addFields(schema, t, visiting); err != nil {
+			return nil, err
+		}
+		return schema, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported type %s", t)
+}
+
+func addFields(schema *JSONSchema, t reflect.Type, visiting map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options := tag, ""
+		if index := strings.Index(tag, ","); index >= 0 {
+			name, options = tag[:index], tag[index+1:]
+		}
+
+		//匿名结构体字段展开到当前对象, 与encoding/json一致
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				if err := addFields(schema, embedded, visiting); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := schemaOf(field.Type, visiting)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.Name(), field.Name, err)
+		}
+
+		if strings.Contains(options, "string") {
+			property = &JSONSchema{Type: "string", Nullable: property.Nullable}
+		}
+
+		property.Description = field.Tag.Get("desc")
+		if enum := field.Tag.Get("enum"); enum != "" {
+			for _, value := range strings.Split(enum, ",") {
+				property.Enum = append(property.Enum, enumValue(property.Type, strings.TrimSpace(value)))
+			}
+		}
+
+		schema.Properties[name] = property
+		if !strings.Contains(options, "omitempty") && !isNilable(field.Type) {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return nil
+}
+
+func enumValue(schemaType string, value string) interface{} {
+	if schemaType == "integer" || schemaType == "number" {
+		var number float64
+		if _, err := fmt.Sscan(value, &number); err == nil {
+			return number
+		}
+	}
+	return value
+}
+
+// Validate 校验解析后的JSON值, 即json.Unmarshal到interface{}的结果, 返回所有错误, 每条错误以字段路径开头
+func (s *JSONSchema) Validate(value interface{}) []string {
+	var errs []string
+	s.validate("$", value, &errs)
+	return errs
+}
+
+func (s *JSONSchema) validate(path string, value interface{}, errs *[]string) {
+	if s == nil {
+		return
+	}
+
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if value == nil && s.Nullable {
+		return
+	}
+
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		fail("value %v is not one of %v", jsonText(value), jsonText(s.Enum))
+		return
+	}
+
+	switch s.Type {
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			fail("expected string, got %s", jsonType(value))
+		} else if s.ContentEncoding == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(text); err != nil {
+				fail("invalid base64 string: %v", err)
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("expected boolean, got %s", jsonType(value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			fail("expected number, got %s", jsonType(value))
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			fail("expected integer, got %s", jsonText(value))
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("expected array, got %s", jsonType(value))
+			return
+		}
+
+		for i, item := range items {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("expected object, got %s", jsonType(value))
+			return
+		}
+
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				fail("missing required field %q", name)
+			}
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if property, ok := s.Properties[key]; ok {
+				property.validate(path+"."+key, object[key], errs)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(path+"."+key, object[key], errs)
+			}
+		}
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func jsonText(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
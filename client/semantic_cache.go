@@ -0,0 +1,387 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief semantic completion cache matching paraphrased questions
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/vectorindex"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSemanticCacheThreshold = 0.95
+	// DefaultSemanticCacheMaxEntries 默认最多缓存的条目数
+	DefaultSemanticCacheMaxEntries = 10000
+	// maxPendingEmbeddings Lookup未命中后等待Store的问题向量数上限
+	maxPendingEmbeddings = 1000
+)
+
+// SemanticCache 语义缓存, 对问题向量化后在相同AppId和参数的缓存中查找相似度不低于Threshold的问题并返回其响应.
+// 只缓存单轮请求: Prompt且没有History, 或Messages中除system消息外只有一条用户消息
+type SemanticCache struct {
+	Embedder Embedder
+	// Threshold 余弦相似度阈值, 为0时使用DefaultSemanticCacheThreshold
+	Threshold float64
+	// TTL 缓存有效期, 为0时不过期
+	TTL time.Duration
+	// MaxEntries 最多缓存的条目数, 超出时淘汰最早写入的条目, 为0时使用DefaultSemanticCacheMaxEntries
+	MaxEntries int
+	// Now 当前时间, 为nil时使用time.Now, 测试时可替换
+	Now func() time.Time
+
+	mutex sync.Mutex
+	//appId -> 作用域键 -> 作用域
+	scopes map[string]map[string]*semanticScope
+	//Lookup未命中的问题向量, Store时复用
+	pending map[string][]float64
+	//全部条目按写入顺序排列, 用于淘汰
+	order *list.List
+	seq   int64
+}
+
+type semanticScope struct {
+	index   *vectorindex.Index
+	entries map[string]*semanticEntry
+}
+
+type semanticEntry struct {
+	id        string
+	appId     string
+	scopeKey  string
+	question  string
+	response  *CompletionResponse
+	expiresAt time.Time
+	element   *list.Element
+}
+
+func NewSemanticCache(embedder Embedder, threshold float64) *SemanticCache {
+	return &SemanticCache{Embedder: embedder, Threshold: threshold}
+}
+
+func (c *SemanticCache) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	scopes := 0
+	for _, appScopes := range c.scopes {
+		scopes += len(appScopes)
+	}
+	return fmt.Sprintf("SemanticCache{Threshold: %v, TTL: %v, Scopes: %d}", c.threshold(), c.TTL, scopes)
+}
+
+func (c *SemanticCache) GoString() string {
+	return c.String()
+}
+
+func (c *SemanticCache) threshold() float64 {
+	if c.Threshold <= 0 {
+		return DefaultSemanticCacheThreshold
+	}
+	return c.Threshold
+}
+
+func (c *SemanticCache) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// Lookup 返回最相似且未过期的缓存响应
+func (c *SemanticCache) Lookup(request *CompletionRequest) (*CompletionResponse, error) {
+	question, ok := semanticQuestion(request)
+	if !ok {
+		return nil, nil
+	}
+
+	embedding, err := c.embed(question)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	scope := c.scopes[request.AppId][semanticScopeKey(request)]
+	if scope == nil {
+		c.addPending(question, embedding)
+		return nil, nil
+	}
+
+	now := c.now()
+	//过期的条目在查询时删除, 删除后重新检索
+	for {
+		results, err := scope.index.SearchEmbedding(embedding, 1, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(results) == 0 || results[0].Score < c.threshold() {
+			c.addPending(question, embedding)
+			return nil, nil
+		}
+
+		entry := scope.entries[results[0].Id]
+		if !entry.expired(now) {
+			return copyResponse(entry.response), nil
+		}
+
+		c.remove(entry)
+		if len(scope.entries) == 0 {
+			c.addPending(question, embedding)
+			return nil, nil
+		}
+	}
+}
+
+// Store 写入单轮请求的响应
+func (c *SemanticCache) Store(request *CompletionRequest, response *CompletionResponse) error {
+	question, ok := semanticQuestion(request)
+	if !ok {
+		return nil
+	}
+
+	c.mutex.Lock()
+	embedding, ok := c.pending[question]
+	delete(c.pending, question)
+	c.mutex.Unlock()
+
+	if !ok {
+		var err error
+		if embedding, err = c.embed(question); err != nil {
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.scopes == nil {
+		c.scopes = make(map[string]map[string]*semanticScope)
+	}
+
+	if c.order == nil {
+		c.order = list.New()
+	}
+	c.evict()
+
+	appScopes := c.scopes[request.AppId]
+	if appScopes == nil {
+		appScopes = make(map[string]*semanticScope)
+		c.scopes[request.AppId] = appScopes
+	}
+
+	key := semanticScopeKey(request)
+	scope := appScopes[key]
+	if scope == nil {
+		scope = &semanticScope{index: vectorindex.New(vectorindex.Cosine), entries: make(map[string]*semanticEntry)}
+		appScopes[key] = scope
+	}
+
+	c.seq++
+	id := fmt.Sprintf("%d", c.seq)
+	if err := scope.index.AddEmbeddings([]string{id}, [][]float64{embedding}, nil); err != nil {
+		return err
+	}
+
+	entry := &semanticEntry{id: id, appId: request.AppId, scopeKey: key, question: question, response: copyResponse(response)}
+	if c.TTL > 0 {
+		entry.expiresAt = c.now().Add(c.TTL)
+	}
+	entry.element = c.order.PushBack(entry)
+	scope.entries[id] = entry
+	return nil
+}
+
+func (e *semanticEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// evict 删除所有过期条目, 条目数仍达到MaxEntries时淘汰最早写入的条目, 为新条目留出空间
+func (c *SemanticCache) evict() {
+	now := c.now()
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(*semanticEntry); entry.expired(now) {
+			c.remove(entry)
+		}
+		element = next
+	}
+
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultSemanticCacheMaxEntries
+	}
+
+	for c.order.Len() >= maxEntries {
+		c.remove(c.order.Front().Value.(*semanticEntry))
+	}
+}
+
+// remove 删除条目, 作用域和应用没有条目时一并删除
+func (c *SemanticCache) remove(entry *semanticEntry) {
+	appScopes := c.scopes[entry.appId]
+	scope := appScopes[entry.scopeKey]
+	if scope != nil {
+		scope.index.Delete(entry.id)
+		delete(scope.entries, entry.id)
+		if len(scope.entries) == 0 {
+			delete(appScopes, entry.scopeKey)
+		}
+	}
+
+	if len(appScopes) == 0 {
+		delete(c.scopes, entry.appId)
+	}
+	c.order.Remove(entry.element)
+}
+
+// Invalidate 删除与请求问题相似度不低于阈值的缓存, 返回删除的条目数
+func (c *SemanticCache) Invalidate(request *CompletionRequest) (int, error) {
+	question, ok := semanticQuestion(request)
+	if !ok {
+		return 0, nil
+	}
+
+	embedding, err := c.embed(question)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	scope := c.scopes[request.AppId][semanticScopeKey(request)]
+	if scope == nil {
+		return 0, nil
+	}
+
+	results, err := scope.index.SearchEmbedding(embedding, len(scope.entries), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, result := range results {
+		if result.Score < c.threshold() {
+			break
+		}
+
+		c.remove(scope.entries[result.Id])
+		removed++
+	}
+	return removed, nil
+}
+
+// InvalidateApp 删除应用的全部缓存, 应用的提示词或知识库更新后调用
+func (c *SemanticCache) InvalidateApp(appId string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, scope := range c.scopes[appId] {
+		for _, entry := range scope.entries {
+			c.order.Remove(entry.element)
+		}
+	}
+	delete(c.scopes, appId)
+}
+
+// Clear 清空缓存
+func (c *SemanticCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.scopes = nil
+	c.pending = nil
+	c.order = nil
+}
+
+// Len 缓存的条目数, 包含尚未删除的过期条目
+func (c *SemanticCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count := 0
+	for _, appScopes := range c.scopes {
+		for _, scope := range appScopes {
+			count += len(scope.entries)
+		}
+	}
+	return count
+}
+
+func (c *SemanticCache) embed(question string) ([]float64, error) {
+	result, err := c.Embedder.Embed([]string{question})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Embeddings) != 1 {
+		return nil, fmt.Errorf("Failed to embed question, got %d embeddings", len(result.Embeddings))
+	}
+	return result.Embeddings[0], nil
+}
+
+func (c *SemanticCache) addPending(question string, embedding []float64) {
+	if c.pending == nil || len(c.pending) >= maxPendingEmbeddings {
+		c.pending = make(map[string][]float64)
+	}
+	c.pending[question] = embedding
+}
+
+// semanticQuestion 返回单轮请求的问题, 多轮请求返回false
+func semanticQuestion(request *CompletionRequest) (string, bool) {
+	if len(request.Messages) == 0 {
+		question := strings.TrimSpace(request.Prompt)
+		return question, len(request.History) == 0 && question != ""
+	}
+
+	question := ""
+	for _, message := range request.Messages {
+		if message.Role == RoleSystem {
+			continue
+		}
+
+		if message.Role != RoleUser || question != "" {
+			return "", false
+		}
+		question = strings.TrimSpace(message.Content)
+	}
+	return question, question != ""
+}
+
+// semanticScopeKey 由影响回答的参数生成作用域键: system消息、模型参数、业务参数、文档标签和引用格式
+func semanticScopeKey(request *CompletionRequest) string {
+	var system []string
+	for _, message := range request.Messages {
+		if message.Role == RoleSystem {
+			system = append(system, message.Content)
+		}
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"System":           system,
+		"TopP":             request.TopP,
+		"HasThoughts":      request.HasThoughts,
+		"BizParams":        request.BizParams,
+		"DocReferenceType": request.DocReferenceType,
+		"Parameters":       request.Parameters,
+		"DocTagIds":        request.DocTagIds,
+		"DocTagCodes":      request.DocTagCodes,
+	})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,143 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for semantic completion cache
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"testing"
+	"time"
+)
+
+// mapEmbedder 按预设的问题返回向量, 未预设的问题返回正交的向量
+type mapEmbedder struct {
+	vectors map[string][]float64
+	calls   int
+}
+
+func (e *mapEmbedder) Embed(texts []string) (*client.EmbeddingResult, error) {
+	e.calls++
+	result := &client.EmbeddingResult{}
+	for _, text := range texts {
+		vector, ok := e.vectors[text]
+		if !ok {
+			vector = []float64{0, 0, 1}
+		}
+		result.Embeddings = append(result.Embeddings, vector)
+	}
+	return result, nil
+}
+
+func TestSemanticCache(t *testing.T) {
+	var server *mockCompletionServer
+	server = newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{textResponse(string(rune('0' + server.requestCount())))}
+	})
+
+	embedder := &mapEmbedder{vectors: map[string][]float64{
+		"怎么退货":  {1, 0.02, 0},
+		"如何退货?": {1, 0.05, 0},
+		"运费谁承担": {0, 1, 0},
+	}}
+	now := time.Unix(1700000000, 0)
+	cache := client.NewSemanticCache(embedder, 0.99)
+	cache.TTL = time.Minute
+	cache.Now = func() time.Time { return now }
+
+	completionClient := server.client()
+	completionClient.Cache = cache
+
+	first, err := completionClient.CreateCompletion(&client.CompletionRequest{AppId: "app", Prompt: "怎么退货"})
+	if err != nil || first.Cached || first.Data.Text != "1" {
+		t.Fatalf("unexpected response: %v, err: %v", first, err)
+	}
+
+	//近似的问题命中缓存, 修改返回的响应不影响缓存
+	second, err := completionClient.CreateCompletion(&client.CompletionRequest{
+		AppId:    "app",
+		Messages: []client.ChatCompletionMessage{{Role: client.RoleUser, Content: "如何退货?"}},
+		Metadata: map[string]string{"trace": "t1"},
+	})
+	if err != nil || !second.Cached || second.Data.Text != "1" || second.Metadata["trace"] != "t1" || server.requestCount() != 1 {
+		t.Fatalf("expected cached response, got: %v, err: %v", second, err)
+	}
+	second.Data.Text = "changed"
+
+	//不同的问题、参数或应用不命中
+	requests := []*client.CompletionRequest{
+		{AppId: "app", Prompt: "运费谁承担"},
+		{AppId: "app", Prompt: "怎么退货", Parameters: &client.CompletionRequestModelParameter{Seed: 7}},
+		{AppId: "other", Prompt: "怎么退货"},
+	}
+	for _, request := range requests {
+		if response, _ := completionClient.CreateCompletion(request); response.Cached {
+			t.Errorf("unexpected cache hit: %s", request)
+		}
+	}
+
+	//多轮对话不缓存
+	multiTurn := &client.CompletionRequest{AppId: "app", Prompt: "怎么退货", History: []client.ChatQaMessage{{User: "你好", Bot: "你好"}}}
+	if response, _ := completionClient.CreateCompletion(multiTurn); response.Cached {
+		t.Errorf("multi-turn request should not be cached")
+	}
+
+	if response, _ := completionClient.CreateCompletion(&client.CompletionRequest{AppId: "app", Prompt: "怎么退货"}); response.Data.Text != "1" {
+		t.Errorf("cached response modified: %s", response)
+	}
+
+	if removed, err := cache.Invalidate(&client.CompletionRequest{AppId: "app", Prompt: "如何退货?"}); removed != 1 || err != nil {
+		t.Errorf("unexpected invalidate result: %d, err: %v", removed, err)
+	}
+
+	//过期后重新请求
+	before := server.requestCount()
+	_, _ = completionClient.CreateCompletion(&client.CompletionRequest{AppId: "other", Prompt: "怎么退货"})
+	now = now.Add(2 * time.Minute)
+	if response, _ := completionClient.CreateCompletion(&client.CompletionRequest{AppId: "other", Prompt: "怎么退货"}); response.Cached || server.requestCount() != before+1 {
+		t.Errorf("expected expired entry, got: %s, requests: %d", response, server.requestCount())
+	}
+
+	//写入时已删除app下过期的条目
+	if cache.Len() != 1 {
+		t.Errorf("expired entries should be swept on store, size: %d", cache.Len())
+	}
+
+	cache.InvalidateApp("other")
+	if cache.Len() != 0 || cache.String() != "SemanticCache{Threshold: 0.99, TTL: 1m0s, Scopes: 0}" {
+		t.Errorf("unexpected cache: %s, size: %d", cache, cache.Len())
+	}
+}
+
+func TestSemanticCacheMaxEntries(t *testing.T) {
+	embedder := &mapEmbedder{vectors: map[string][]float64{
+		"q1": {1, 0, 0},
+		"q2": {0, 1, 0},
+		"q3": {0, 0, 1},
+	}}
+	cache := client.NewSemanticCache(embedder, 0.99)
+	cache.MaxEntries = 2
+
+	for _, question := range []string{"q1", "q2", "q3"} {
+		if err := cache.Store(&client.CompletionRequest{AppId: question, Prompt: question}, textResponse(question)); err != nil {
+			t.Fatalf("failed to store: %v", err)
+		}
+	}
+
+	if cache.Len() != 2 {
+		t.Errorf("unexpected cache size: %d", cache.Len())
+	}
+
+	//最早写入的条目被淘汰
+	for question, cached := range map[string]bool{"q1": false, "q2": true, "q3": true} {
+		response, err := cache.Lookup(&client.CompletionRequest{AppId: question, Prompt: question})
+		if err != nil || (response != nil) != cached {
+			t.Errorf("unexpected lookup of %s: %v, err: %v", question, response, err)
+		}
+	}
+}
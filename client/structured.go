@@ -0,0 +1,319 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief structured json output decoded into go values
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultJSONInstruction 注入到请求中的格式说明, %s为JSON Schema
+	DefaultJSONInstruction = "请只输出一个符合以下JSON Schema的JSON值, 不要输出解释或其他内容:\n%s"
+	// DefaultJSONCorrection 校验失败时重新提问的内容, %s为错误列表
+	DefaultJSONCorrection = "上面的输出不符合要求:\n%s\n请修正后只输出JSON。"
+	DefaultJSONMaxRetries = 2
+)
+
+var ErrNoJSON = errors.New("No json found in text")
+
+// JSONOutputError 重试后仍未得到符合Schema的输出
+type JSONOutputError struct {
+	Text   string
+	Errors []string
+}
+
+func (e *JSONOutputError) Error() string {
+	return fmt.Sprintf("Failed to get valid json output: %s", strings.Join(e.Errors, "; "))
+}
+
+// JSONOptions 结构化输出配置, 为nil时使用默认值
+type JSONOptions struct {
+	// MaxRetries 校验失败后的最大重试次数, 0时使用DefaultJSONMaxRetries, 小于0时不重试
+	MaxRetries int
+	// Schema 为nil时根据输出类型生成
+	Schema *JSONSchema
+	// Instruction 格式说明模板, 为空时使用DefaultJSONInstruction
+	Instruction string
+	// Correction 重新提问模板, 为空时使用DefaultJSONCorrection
+	Correction string
+}
+
+// CompleteJSON 要求模型输出JSON并解析到v, v需为指针. 请求中注入由v的类型生成的JSON Schema,
+// 从输出中提取并修复JSON, 校验失败时将错误反馈给模型重新生成; 不修改传入的request.
+// 返回最后一次调用的响应
+func (cc *CompletionClient) CompleteJSON(request *CompletionRequest, v interface{}, options *JSONOptions) (*CompletionResponse, error) {
+	if options == nil {
+		options = &JSONOptions{}
+	}
+
+	schema := options.Schema
+	if schema == nil {
+		var err error
+		if schema, err = SchemaOf(v); err != nil {
+			return nil, err
+		}
+	}
+
+	schemaText, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	instruction := options.Instruction
+	if instruction == "" {
+		instruction = DefaultJSONInstruction
+	}
+
+	correction := options.Correction
+	if correction == "" {
+		correction = DefaultJSONCorrection
+	}
+
+	retries := options.MaxRetries
+	if retries == 0 {
+		retries = DefaultJSONMaxRetries
+	}
+
+	current := withInstruction(request, fmt.Sprintf(instruction, schemaText))
+	for attempt := 0; ; attempt++ {
+		attemptRequest := copyRequest(current)
+		response, err := cc.CreateCompletion(attemptRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = response.Err(); err != nil {
+			return response, err
+		}
+
+		text := response.OutputText()
+		errs := decodeJSON(text, schema, v)
+		if len(errs) == 0 {
+			return response, nil
+		}
+
+		if attempt >= retries {
+			return response, &JSONOutputError{Text: text, Errors: errs}
+		}
+
+		current = withFeedback(current, text, fmt.Sprintf(correction, "- "+strings.Join(errs, "\n- ")))
+	}
+}
+
+// ParseJSON 从模型输出中提取并修复JSON, 校验通过后解析到v; schema为nil时不校验
+func ParseJSON(text string, schema *JSONSchema, v interface{}) error {
+	errs := decodeJSON(text, schema, v)
+	if len(errs) > 0 {
+		return &JSONOutputError{Text: text, Errors: errs}
+	}
+	return nil
+}
+
+func decodeJSON(text string, schema *JSONSchema, v interface{}) []string {
+	extracted, err := ExtractJSON(text)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	repaired := RepairJSON(extracted)
+	var value interface{}
+	if err = json.Unmarshal([]byte(repaired), &value); err != nil {
+		return []string{fmt.Sprintf("invalid json: %v", err)}
+	}
+
+	if errs := schema.Validate(value); len(errs) > 0 {
+		return errs
+	}
+
+	if err = json.Unmarshal([]byte(repaired), v); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// ExtractJSON 从文本中提取JSON: 优先取```json代码块, 否则取第一个{或[开始到与之匹配的括号结束的内容,
+// 括号未闭合时返回到文本结尾
+func ExtractJSON(text string) (string, error) {
+	if start := strings.Index(text, "```"); start >= 0 {
+		body := text[start+3:]
+		if newline := strings.Index(body, "\n"); newline >= 0 {
+			//跳过语言标记, 如```json
+			if lang := strings.TrimSpace(body[:newline]); !strings.ContainsAny(lang, "{[") {
+				body = body[newline+1:]
+			}
+		}
+
+		if end := strings.Index(body, "```"); end >= 0 {
+			body = body[:end]
+		}
+
+		if trimmed := strings.TrimSpace(body); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			text = trimmed
+		}
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return "", ErrNoJSON
+	}
+
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return text[start : i+1], nil
+			}
+		}
+	}
+
+	return strings.TrimSpace(text[start:]), nil
+}
+
+// RepairJSON 修复模型输出中常见的JSON错误: 删除注释和多余的逗号, 将中文引号替换为英文引号,
+// 补全因截断未闭合的字符串和括号. 无法修复的内容原样返回
+func RepairJSON(text string) string {
+	var result strings.Builder
+	var stack []byte
+	inString, escaped, smartQuoted := false, false, false
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if inString {
+			//以中文引号开始的字符串以中文引号结束
+			if smartQuoted && r == '”' {
+				r = '"'
+			}
+
+			result.WriteRune(r)
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == '"':
+				inString = false
+			}
+			continue
+		}
+
+		smartQuoted = r == '“'
+		if smartQuoted {
+			r = '"'
+		}
+
+		switch {
+		case r == '"':
+			inString = true
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+			continue
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i < len(runes) && !(runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/') {
+				i++
+			}
+			i++
+			continue
+		case r == '{':
+			stack = append(stack, '}')
+		case r == '[':
+			stack = append(stack, ']')
+		case r == '}' || r == ']':
+			trimTrailingComma(&result)
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+		result.WriteRune(r)
+	}
+
+	if escaped {
+		result.WriteRune('\\')
+	}
+
+	if inString {
+		result.WriteRune('"')
+	}
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		trimTrailingComma(&result)
+		result.WriteByte(stack[i])
+	}
+	return result.String()
+}
+
+func trimTrailingComma(builder *strings.Builder) {
+	text := strings.TrimRight(builder.String(), " \t\r\n")
+	if strings.HasSuffix(text, ",") {
+		builder.Reset()
+		builder.WriteString(strings.TrimSuffix(text, ","))
+	}
+}
+
+// withInstruction 复制请求并注入格式说明: Messages格式追加到system消息, 否则追加到Prompt之后
+func withInstruction(request *CompletionRequest, instruction string) *CompletionRequest {
+	result := copyRequest(request)
+	if len(result.Messages) == 0 {
+		result.Prompt = joinContent(result.Prompt, instruction)
+		return result
+	}
+
+	if result.Messages[0].Role == RoleSystem {
+		result.Messages[0].Content = joinContent(result.Messages[0].Content, instruction)
+	} else {
+		result.Messages = append([]ChatCompletionMessage{{Role: RoleSystem, Content: instruction}}, result.Messages...)
+	}
+	return result
+}
+
+// withFeedback 将上一次的输出和错误说明作为新一轮对话
+func withFeedback(request *CompletionRequest, output string, feedback string) *CompletionRequest {
+	result := copyRequest(request)
+	if len(result.Messages) == 0 {
+		result.History = append(result.History, ChatQaMessage{User: result.Prompt, Bot: output})
+		result.Prompt = feedback
+		return result
+	}
+
+	result.Messages = append(result.Messages,
+		ChatCompletionMessage{Role: RoleAssistant, Content: output},
+		ChatCompletionMessage{Role: RoleUser, Content: feedback})
+	return result
+}
+
+// copyRequest 复制请求及其中会被修改的切片, 避免发送请求时的格式转换和裁剪影响调用方
+func copyRequest(request *CompletionRequest) *CompletionRequest {
+	result := *request
+	result.Messages = append([]ChatCompletionMessage(nil), request.Messages...)
+	result.History = append([]ChatQaMessage(nil), request.History...)
+	return &result
+}
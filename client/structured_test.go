@@ -0,0 +1,179 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for structured json output
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"encoding/json"
+	"errors"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"testing"
+)
+
+type ticket struct {
+	Title    string   `json:"title" desc:"工单标题"`
+	Priority string   `json:"priority" enum:"low,medium,high"`
+	Score    int      `json:"score"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	schema, err := client.SchemaOf(&ticket{})
+	if err != nil {
+		t.Fatalf("failed to derive schema: %v", err)
+	}
+
+	data, _ := json.Marshal(schema)
+	expected := `{"type":"object","properties":{"priority":{"type":"string","enum":["low","medium","high"]},` +
+		`"score":{"type":"integer"},"tags":{"type":"array","items":{"type":"string"},"nullable":true},` +
+		`"title":{"type":"string","description":"工单标题"}},"required":["title","priority","score"]}`
+	if string(data) != expected {
+		t.Errorf("unexpected schema: %s", data)
+	}
+
+	var value interface{}
+	_ = json.Unmarshal([]byte(`{"title":1,"priority":"urgent","score":1.5,"tags":["a",2]}`), &value)
+	errs := strings.Join(schema.Validate(value), "\n")
+	expected = "$.priority: value \"urgent\" is not one of [\"low\",\"medium\",\"high\"]\n" +
+		"$.score: expected integer, got 1.5\n" +
+		"$.tags[1]: expected string, got number\n" +
+		"$.title: expected string, got number"
+	if errs != expected {
+		t.Errorf("unexpected errors:\n%s", errs)
+	}
+
+	if _, err = client.SchemaOf(make(chan int)); err == nil {
+		t.Errorf("expected unsupported type error")
+	}
+}
+
+func TestSchemaOfNullable(t *testing.T) {
+	type attachment struct {
+		Name     string            `json:"name"`
+		Data     []byte            `json:"data"`
+		Assignee *string           `json:"assignee"`
+		Labels   map[string]string `json:"labels"`
+	}
+
+	schema, err := client.SchemaOf(attachment{})
+	if err != nil {
+		t.Fatalf("failed to derive schema: %v", err)
+	}
+
+	data, _ := json.Marshal(schema)
+	expected := `{"type":"object","properties":{"assignee":{"type":"string","nullable":true},` +
+		`"data":{"type":"string","contentEncoding":"base64","nullable":true},` +
+		`"labels":{"type":"object","additionalProperties":{"type":"string"},"nullable":true},` +
+		`"name":{"type":"string"}},"required":["name"]}`
+	if string(data) != expected {
+		t.Errorf("unexpected schema: %s", data)
+	}
+
+	//nil字段序列化为null, 应通过校验
+	var value interface{}
+	data, _ = json.Marshal(attachment{Name: "a"})
+	_ = json.Unmarshal(data, &value)
+	if errs := schema.Validate(value); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	_ = json.Unmarshal([]byte(`{"name":null,"data":"not base64!"}`), &value)
+	errs := strings.Join(schema.Validate(value), "\n")
+	if !strings.Contains(errs, "$.data: invalid base64 string") || !strings.Contains(errs, "$.name: expected string, got null") {
+		t.Errorf("unexpected errors:\n%s", errs)
+	}
+}
+
+func TestExtractAndRepairJSON(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"好的, 结果如下:\n```json\n{\"a\": [1, 2,],}\n```\n希望有帮助", `{"a": [1, 2]}`},
+		{"结果是 {\"a\": \"x}y\"} 以上", `{"a": "x}y"}`},
+		{"[1, 2, // 注释\n 3]", "[1, 2, \n 3]"},
+		{"{\"a\": {\"b\": \"trunc", `{"a": {"b": "trunc"}}`},
+		{"{“a”: 1}", `{"a": 1}`},
+	}
+
+	for _, c := range cases {
+		extracted, err := client.ExtractJSON(c.text)
+		if err != nil {
+			t.Fatalf("failed to extract %q: %v", c.text, err)
+		}
+
+		if repaired := client.RepairJSON(extracted); repaired != c.expected {
+			t.Errorf("unexpected json of %q: %s", c.text, repaired)
+		}
+	}
+
+	if _, err := client.ExtractJSON("没有结果"); !errors.Is(err, client.ErrNoJSON) {
+		t.Errorf("expected no json error, got: %v", err)
+	}
+}
+
+func TestCompleteJSON(t *testing.T) {
+	outputs := []string{
+		"工单如下:\n```json\n{\"title\": \"无法登录\", \"priority\": \"urgent\", \"score\": 3}\n```",
+		"{\"title\": \"无法登录\", \"priority\": \"high\", \"score\": 3, \"tags\": [\"账号\"],}",
+	}
+	var server *mockCompletionServer
+	server = newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		output := outputs[server.requestCount()-1]
+		return []*client.CompletionResponse{messageResponse(output, client.FinishReasonStop)}
+	})
+
+	request := &client.CompletionRequest{
+		AppId:    "app",
+		Messages: []client.ChatCompletionMessage{{Role: client.RoleUser, Content: "用户反馈无法登录"}},
+	}
+
+	result := &ticket{}
+	response, err := server.client().CompleteJSON(request, result, nil)
+	if err != nil {
+		t.Fatalf("failed to complete json: %v", err)
+	}
+
+	if result.Priority != "high" || result.Title != "无法登录" || len(result.Tags) != 1 || response.OutputText() != outputs[1] {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if len(request.Messages) != 1 {
+		t.Errorf("request should not be modified: %s", request)
+	}
+
+	last := server.lastRequest()
+	if len(last.Messages) != 4 || last.Messages[0].Role != client.RoleSystem || !strings.Contains(last.Messages[0].Content, `"enum"`) {
+		t.Fatalf("unexpected retry request: %s", last)
+	}
+
+	if feedback := last.Messages[3].Content; !strings.Contains(feedback, `$.priority: value "urgent"`) {
+		t.Errorf("unexpected feedback: %s", feedback)
+	}
+}
+
+func TestCompleteJSONRetriesExhausted(t *testing.T) {
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		return []*client.CompletionResponse{textResponse("抱歉, 我无法完成")}
+	})
+
+	request := &client.CompletionRequest{AppId: "app", Prompt: "生成工单"}
+	_, err := server.client().CompleteJSON(request, &ticket{}, &client.JSONOptions{MaxRetries: 1})
+
+	var outputErr *client.JSONOutputError
+	if !errors.As(err, &outputErr) || outputErr.Text != "抱歉, 我无法完成" {
+		t.Fatalf("expected json output error, got: %v", err)
+	}
+
+	last := server.lastRequest()
+	if server.requestCount() != 2 || len(last.History) != 1 || !strings.HasPrefix(last.History[0].User, "生成工单\n\n") {
+		t.Errorf("unexpected retry request: %s", last)
+	}
+}
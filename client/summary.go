@@ -0,0 +1,322 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief summarize older conversation turns when history grows
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/alibabacloud-go/tea/tea"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSummaryCacheSize 作为HistoryStrategy使用时默认缓存的摘要数
+	DefaultSummaryCacheSize = 1000
+	// SummaryPlaceholder 摘要提示词中待摘要对话的占位符
+	SummaryPlaceholder = "%s"
+
+	DefaultSummaryPrompt = "请将以下对话总结为一段简洁的摘要, 保留用户的身份、需求、关键事实和已经达成的结论, 不要添加对话中没有的信息。\n\n%s"
+	// SummaryQuestion History格式下摘要以问答对的形式传入, 该问题作为User
+	SummaryQuestion = "请总结我们之前的对话"
+	summaryPrefix   = "以下是之前对话的摘要:\n"
+)
+
+// ConversationSummary 对话摘要, 替换Turns[:ReplacedTurns]
+type ConversationSummary struct {
+	Content       string `json:"Content"`
+	Role          Role   `json:"Role,omitempty"`
+	ReplacedTurns int    `json:"ReplacedTurns"`
+	CreatedAt     int64  `json:"CreatedAt"`
+}
+
+func (s ConversationSummary) String() string {
+	return tea.Prettify(s)
+}
+
+func (s ConversationSummary) GoString() string {
+	return s.String()
+}
+
+// Message 以Messages格式表示的摘要
+func (s *ConversationSummary) Message() ChatCompletionMessage {
+	role := s.Role
+	if role == "" {
+		role = RoleSystem
+	}
+	return ChatCompletionMessage{Role: role, Content: summaryPrefix + s.Content}
+}
+
+// QaMessage 以History格式表示的摘要
+func (s *ConversationSummary) QaMessage() ChatQaMessage {
+	return ChatQaMessage{User: SummaryQuestion, Bot: s.Content}
+}
+
+// Summarizer 历史消息超过阈值时调用模型将较早的对话压缩为一条摘要.
+// 可作为HistoryStrategy使用, 此时摘要缓存在内存中; 设置在Conversation.Summarizer上时摘要随对话保存
+type Summarizer struct {
+	// Client 生成摘要使用的客户端, 不应再设置Summarizer作为HistoryStrategy
+	Client *CompletionClient
+	AppId  string
+	// Format 摘要应用接收上下文的格式, Messages格式时以用户消息发送, History格式时以Prompt发送
+	Format MessageFormat
+	// Prompt 摘要提示词, 第一个%s替换为待摘要的对话, 不包含%s时对话追加在提示词之后
+	Prompt string
+	// Role 摘要消息的角色, 默认为system
+	Role Role
+	// MaxMessages 历史消息数超过该值时触发摘要, 为0时不按消息数触发
+	MaxMessages int
+	// MaxTokens 历史消息估算token数超过该值时触发摘要, 为0时不按token数触发
+	MaxTokens int
+	Estimator TokenEstimator
+	// KeepRecent 摘要时至少保留的最近消息数
+	KeepRecent int
+	// CacheSize 作为HistoryStrategy使用时缓存的摘要数, 超出时淘汰最久未使用的摘要, 为0时使用DefaultSummaryCacheSize
+	CacheSize int
+
+	mutex sync.Mutex
+	cache map[string]*list.Element
+	lru   *list.List
+}
+
+type summaryCacheEntry struct {
+	key     string
+	summary *ConversationSummary
+}
+
+// Summarize 将之前的摘要和新的对话合并为一段摘要
+func (s *Summarizer) Summarize(previous string, messages []ChatCompletionMessage) (string, error) {
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString(summaryPrefix)
+		transcript.WriteString(previous)
+		transcript.WriteString("\n\n")
+	}
+
+	for _, message := range messages {
+		transcript.WriteString(roleName(message.Role))
+		transcript.WriteString(": ")
+		transcript.WriteString(message.Content)
+		transcript.WriteString("\n")
+	}
+
+	prompt := s.Prompt
+	if prompt == "" {
+		prompt = DefaultSummaryPrompt
+	}
+	if strings.Contains(prompt, SummaryPlaceholder) {
+		prompt = strings.Replace(prompt, SummaryPlaceholder, transcript.String(), 1)
+	} else {
+		prompt = prompt + "\n\n" + transcript.String()
+	}
+
+	request := &CompletionRequest{AppId: s.AppId}
+	if s.Format == MessageFormatHistory {
+		request.Prompt = prompt
+	} else {
+		request.Messages = []ChatCompletionMessage{{Role: RoleUser, Content: prompt}}
+	}
+
+	response, err := s.Client.CreateCompletion(request)
+	if err != nil {
+		return "", err
+	}
+
+	if err = response.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(response.OutputText()), nil
+}
+
+// shouldSummarize 判断未被摘要的历史消息是否超过阈值
+func (s *Summarizer) shouldSummarize(messages []ChatCompletionMessage) bool {
+	if s.MaxMessages > 0 && len(messages) > s.MaxMessages {
+		return true
+	}
+
+	if s.MaxTokens > 0 {
+		request := &CompletionRequest{Messages: messages}
+		return EstimateRequestTokens(s.Estimator, request) > s.MaxTokens
+	}
+
+	return false
+}
+
+// splitIndex 返回可以被摘要的消息数, 保留至少KeepRecent条消息, 且保留部分从用户消息开始
+func (s *Summarizer) splitIndex(messages []ChatCompletionMessage) int {
+	index := len(messages) - s.KeepRecent
+	for index > 0 && index < len(messages) && messages[index].Role != RoleUser {
+		index--
+	}
+
+	if index < 0 {
+		return 0
+	}
+	return index
+}
+
+// Apply 在需要时对turns生成新的摘要, 不需要摘要时返回nil
+func (s *Summarizer) Apply(summary *ConversationSummary, turns []ChatCompletionMessage) (*ConversationSummary, error) {
+	replaced, previous := 0, ""
+	if summary != nil && summary.ReplacedTurns <= len(turns) {
+		replaced, previous = summary.ReplacedTurns, summary.Content
+	}
+
+	remaining := turns[replaced:]
+	if !s.shouldSummarize(remaining) {
+		return nil, nil
+	}
+
+	index := s.splitIndex(remaining)
+	if index == 0 {
+		return nil, nil
+	}
+
+	content, err := s.Summarize(previous, remaining[:index])
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConversationSummary{
+		Content:       content,
+		Role:          s.Role,
+		ReplacedTurns: replaced + index,
+		CreatedAt:     time.Now().Unix(),
+	}, nil
+}
+
+// Trim 实现HistoryStrategy, 将较早的历史替换为摘要, 相同的历史只生成一次摘要
+func (s *Summarizer) Trim(request *CompletionRequest) error {
+	if len(request.History) > 0 {
+		turns := make([]ChatCompletionMessage, 0, 2*len(request.History))
+		for _, message := range request.History {
+			turns = append(turns, ChatCompletionMessage{Role: RoleUser, Content: message.User},
+				ChatCompletionMessage{Role: RoleAssistant, Content: message.Bot})
+		}
+
+		summary, err := s.cachedApply(turns)
+		if err != nil || summary == nil {
+			return err
+		}
+
+		history := []ChatQaMessage{summary.QaMessage()}
+		request.History = append(history, request.History[summary.ReplacedTurns/2:]...)
+		return nil
+	}
+
+	window := newMessageWindow(request.Messages)
+	var turns []ChatCompletionMessage
+	for _, turn := range window.turns {
+		turns = append(turns, turn...)
+	}
+
+	summary, err := s.cachedApply(turns)
+	if err != nil || summary == nil {
+		return err
+	}
+
+	messages := append([]ChatCompletionMessage(nil), window.system...)
+	messages = append(messages, summary.Message())
+	messages = append(messages, turns[summary.ReplacedTurns:]...)
+	request.Messages = append(messages, window.current...)
+	return nil
+}
+
+// cachedApply 从缓存中查找最长的已摘要前缀, 其后的消息未超过阈值时直接复用, 否则在其基础上生成新的摘要.
+// 历史逐轮增长时只在新增的消息再次超过阈值时才调用模型
+func (s *Summarizer) cachedApply(turns []ChatCompletionMessage) (*ConversationSummary, error) {
+	//keys[i]为turns[:i+1]的链式哈希
+	keys := make([]string, len(turns))
+	previous := []byte(nil)
+	for i, message := range turns {
+		hash := sha256.New()
+		hash.Write(previous)
+		hash.Write([]byte(message.Role))
+		hash.Write([]byte{0})
+		hash.Write([]byte(message.Content))
+		previous = hash.Sum(nil)
+		keys[i] = hex.EncodeToString(previous)
+	}
+
+	var cached *ConversationSummary
+	for i := len(turns); i > 0 && cached == nil; i-- {
+		cached = s.cacheGet(keys[i-1])
+	}
+
+	summary, err := s.Apply(cached, turns)
+	if err != nil {
+		return nil, err
+	}
+
+	if summary == nil {
+		return cached, nil
+	}
+
+	s.cachePut(keys[summary.ReplacedTurns-1], summary)
+	return summary, nil
+}
+
+func (s *Summarizer) cacheGet(key string) *ConversationSummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.cache[key]
+	if !ok {
+		return nil
+	}
+
+	s.lru.MoveToFront(element)
+	return element.Value.(*summaryCacheEntry).summary
+}
+
+func (s *Summarizer) cachePut(key string, summary *ConversationSummary) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cache == nil {
+		s.cache, s.lru = make(map[string]*list.Element), list.New()
+	}
+
+	if element, ok := s.cache[key]; ok {
+		element.Value.(*summaryCacheEntry).summary = summary
+		s.lru.MoveToFront(element)
+		return
+	}
+
+	s.cache[key] = s.lru.PushFront(&summaryCacheEntry{key: key, summary: summary})
+	size := s.CacheSize
+	if size <= 0 {
+		size = DefaultSummaryCacheSize
+	}
+
+	for s.lru.Len() > size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.cache, oldest.Value.(*summaryCacheEntry).key)
+	}
+}
+
+func roleName(role Role) string {
+	switch role {
+	case RoleUser:
+		return "用户"
+	case RoleAssistant:
+		return "助手"
+	case RoleSystem:
+		return "系统"
+	case RoleTool:
+		return "工具"
+	default:
+		return string(role)
+	}
+}
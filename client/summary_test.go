@@ -0,0 +1,175 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for conversation summarization
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newSummaryServer(t *testing.T, summaries *int32) *mockCompletionServer {
+	return newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		last := request.Messages[len(request.Messages)-1]
+		if strings.Contains(last.Content, "总结为一段简洁的摘要") {
+			count := atomic.AddInt32(summaries, 1)
+			return []*client.CompletionResponse{messageResponse(fmt.Sprintf("summary %d", count), client.FinishReasonStop)}
+		}
+		return []*client.CompletionResponse{messageResponse("reply to "+last.Content, client.FinishReasonStop)}
+	})
+}
+
+func TestSummarizerTrim(t *testing.T) {
+	var summaries int32
+	server := newSummaryServer(t, &summaries)
+
+	summarizer := &client.Summarizer{Client: server.client(), AppId: "summary-app", MaxMessages: 4, KeepRecent: 2}
+	for i := 0; i < 2; i++ {
+		request := &client.CompletionRequest{Messages: historyMessages()}
+		if err := summarizer.Trim(request); err != nil {
+			t.Fatalf("failed to trim: %v", err)
+		}
+
+		if got := contents(request.Messages); got != "system,以下是之前对话的摘要:\nsummary 1,q3,a3,q4" {
+			t.Errorf("unexpected messages: %q", got)
+		}
+	}
+
+	if summaries != 1 {
+		t.Errorf("summary should be cached, summaries: %d", summaries)
+	}
+
+	summaryRequest := server.lastRequest()
+	if summaryRequest.AppId != "summary-app" || !strings.Contains(summaryRequest.Messages[0].Content, "用户: q1\n助手: a1") {
+		t.Errorf("unexpected summary request: %s", summaryRequest)
+	}
+
+	request := &client.CompletionRequest{
+		Prompt:  "q4",
+		History: []client.ChatQaMessage{{User: "q1", Bot: "a1"}, {User: "q2", Bot: "a2"}, {User: "q3", Bot: "a3"}},
+	}
+	if err := summarizer.Trim(request); err != nil {
+		t.Fatalf("failed to trim: %v", err)
+	}
+
+	if len(request.History) != 2 || request.History[0].User != client.SummaryQuestion || request.History[1].User != "q3" {
+		t.Errorf("unexpected history: %v", request.History)
+	}
+}
+
+func TestConversationSummary(t *testing.T) {
+	var summaries int32
+	server := newSummaryServer(t, &summaries)
+	store := client.NewMemoryConversationStore()
+
+	conversation := &client.Conversation{
+		Client:     server.client(),
+		Store:      store,
+		Id:         "c1",
+		AppId:      "app",
+		Summarizer: &client.Summarizer{Client: server.client(), MaxMessages: 4, KeepRecent: 2},
+	}
+
+	for i := 1; i <= 4; i++ {
+		if _, err := conversation.Ask(fmt.Sprintf("q%d", i)); err != nil {
+			t.Fatalf("failed to ask: %v", err)
+		}
+	}
+
+	//第4轮发送前已保存6条消息, 超过阈值, 前4条被摘要
+	if got := contents(server.lastRequest().Messages); got != "以下是之前对话的摘要:\nsummary 1,q3,reply to q3,q4" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+
+	record, err := store.Load("c1")
+	if err != nil || record.Summary == nil || record.Summary.ReplacedTurns != 4 || len(record.Messages) != 8 {
+		t.Fatalf("summary should be saved with conversation, record: %v, err: %v", record, err)
+	}
+
+	//新的对话实例从存储加载摘要
+	other := &client.Conversation{Client: server.client(), Store: store, Id: "c1", AppId: "app"}
+	if _, err = other.Ask("q5"); err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	if got := contents(server.lastRequest().Messages); !strings.HasPrefix(got, "以下是之前对话的摘要:\nsummary 1,q3") {
+		t.Errorf("unexpected messages: %q", got)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err = other.Undo(); err != nil {
+			t.Fatalf("failed to undo: %v", err)
+		}
+	}
+
+	record, _ = store.Load("c1")
+	if record.Summary != nil || len(record.Messages) != 2 {
+		t.Errorf("summary should be removed when replaced turns are truncated, record: %v", record)
+	}
+}
+
+// growingRequest 返回包含n轮历史和当前问题的请求
+func growingRequest(conversation string, n int) *client.CompletionRequest {
+	request := &client.CompletionRequest{}
+	for i := 1; i <= n; i++ {
+		request.Messages = append(request.Messages,
+			client.ChatCompletionMessage{Role: client.RoleUser, Content: fmt.Sprintf("%s q%d", conversation, i)},
+			client.ChatCompletionMessage{Role: client.RoleAssistant, Content: fmt.Sprintf("%s a%d", conversation, i)})
+	}
+	request.Messages = append(request.Messages, client.ChatCompletionMessage{Role: client.RoleUser, Content: "current"})
+	return request
+}
+
+func TestSummarizerTrimGrowingHistory(t *testing.T) {
+	var summaries int32
+	server := newSummaryServer(t, &summaries)
+	summarizer := &client.Summarizer{Client: server.client(), MaxMessages: 4, KeepRecent: 2}
+
+	//历史逐轮增长时复用之前的摘要, 新增的消息再次超过阈值时才重新摘要
+	for n := 3; n <= 5; n++ {
+		if err := summarizer.Trim(growingRequest("c1", n)); err != nil {
+			t.Fatalf("failed to trim: %v", err)
+		}
+	}
+
+	request := growingRequest("c1", 5)
+	_ = summarizer.Trim(request)
+	if summaries != 2 || contents(request.Messages) != "以下是之前对话的摘要:\nsummary 2,c1 q5,c1 a5,current" {
+		t.Errorf("unexpected summaries: %d, messages: %q", summaries, contents(request.Messages))
+	}
+
+	if !strings.Contains(server.lastRequest().Messages[0].Content, "以下是之前对话的摘要:\nsummary 1\n\n用户: c1 q3") {
+		t.Errorf("summary should build on previous summary: %s", server.lastRequest())
+	}
+
+	//超出CacheSize时淘汰最久未使用的摘要
+	summarizer.CacheSize = 1
+	_ = summarizer.Trim(growingRequest("c2", 3))
+	_ = summarizer.Trim(growingRequest("c1", 3))
+	if summaries != 4 {
+		t.Errorf("expected evicted summary regenerated, summaries: %d", summaries)
+	}
+}
+
+func TestSummarizerPromptWithoutPlaceholder(t *testing.T) {
+	var summaries int32
+	server := newSummaryServer(t, &summaries)
+	summarizer := &client.Summarizer{Client: server.client(), Prompt: "请将对话总结为一段简洁的摘要", MaxMessages: 4, KeepRecent: 2}
+
+	if err := summarizer.Trim(growingRequest("c1", 3)); err != nil {
+		t.Fatalf("failed to trim: %v", err)
+	}
+
+	if content := server.lastRequest().Messages[0].Content; content != "请将对话总结为一段简洁的摘要\n\n用户: c1 q1\n助手: c1 a1\n用户: c1 q2\n助手: c1 a2\n" {
+		t.Errorf("unexpected summary prompt: %q", content)
+	}
+}
@@ -0,0 +1,352 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief parsed thought trace, stream merging and renderers
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThoughtStep 解析后的一条思考过程, ActionInput和Observation为JSON字符串时解码为对应的值
+type ThoughtStep struct {
+	Index      int        `json:"Index"`
+	ActionType ActionType `json:"ActionType,omitempty"`
+	Thought    string     `json:"Thought,omitempty"`
+	// ActionName 调用的插件或工具名称
+	ActionName string `json:"ActionName,omitempty"`
+	Action     string `json:"Action,omitempty"`
+	// Input ActionInput解码后的值, 不是JSON时为原始字符串
+	Input interface{} `json:"Input,omitempty"`
+	// Output Observation解码后的值, 不是JSON时为原始字符串
+	Output   interface{} `json:"Output,omitempty"`
+	Response string      `json:"Response,omitempty"`
+	// StartedAt 和Duration 仅在合并流式响应时记录, Duration为本步骤开始到下一步骤开始或最后一次更新的时间
+	StartedAt *time.Time    `json:"StartedAt,omitempty"`
+	Duration  time.Duration `json:"Duration,omitempty"`
+
+	raw CompletionResponseDataThought
+}
+
+func (s ThoughtStep) String() string {
+	return tea.Prettify(s)
+}
+
+func (s ThoughtStep) GoString() string {
+	return s.String()
+}
+
+// Raw 返回原始的思考过程
+func (s *ThoughtStep) Raw() CompletionResponseDataThought {
+	return s.raw
+}
+
+// IsToolCall 是否调用了插件或工具
+func (s *ThoughtStep) IsToolCall() bool {
+	return s.ActionType == ActionTypeApi || s.ActionName != ""
+}
+
+// ThoughtTrace 完整的思考过程
+type ThoughtTrace struct {
+	Steps []ThoughtStep `json:"Steps"`
+}
+
+func (t ThoughtTrace) String() string {
+	return tea.Prettify(t)
+}
+
+func (t ThoughtTrace) GoString() string {
+	return t.String()
+}
+
+// ParseThoughts 解析响应中的思考过程
+func ParseThoughts(thoughts []CompletionResponseDataThought) *ThoughtTrace {
+	trace := &ThoughtTrace{Steps: make([]ThoughtStep, 0, len(thoughts))}
+	for i, thought := range thoughts {
+		trace.Steps = append(trace.Steps, newThoughtStep(i, thought))
+	}
+	return trace
+}
+
+// Thoughts 返回响应中解析后的思考过程, 没有思考过程时Steps为空
+func (cr *CompletionResponse) Thoughts() *ThoughtTrace {
+	if cr == nil || cr.Data == nil {
+		return &ThoughtTrace{Steps: []ThoughtStep{}}
+	}
+	return ParseThoughts(cr.Data.Thoughts)
+}
+
+func newThoughtStep(index int, thought CompletionResponseDataThought) ThoughtStep {
+	input := thought.ActionInput
+	if input == "" {
+		input = thought.ActionInputStream
+	}
+
+	return ThoughtStep{
+		Index:      index,
+		ActionType: thought.ActionType,
+		Thought:    thought.Thought,
+		ActionName: thought.ActionName,
+		Action:     thought.Action,
+		Input:      decodeJSONValue(input),
+		Output:     decodeJSONValue(thought.Observation),
+		Response:   thought.Response,
+		raw:        thought,
+	}
+}
+
+// decodeJSONValue 解码JSON字符串, 插件返回的结果可能被编码了两次; 空字符串返回nil, 不是JSON时返回原字符串
+func decodeJSONValue(text string) interface{} {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(trimmed), &value); err != nil {
+		return text
+	}
+
+	if s, ok := value.(string); ok {
+		if inner := strings.TrimSpace(s); strings.HasPrefix(inner, "{") || strings.HasPrefix(inner, "[") {
+			return decodeJSONValue(inner)
+		}
+	}
+	return value
+}
+
+// ToolCalls 返回调用了插件或工具的步骤
+func (t *ThoughtTrace) ToolCalls() []ThoughtStep {
+	var steps []ThoughtStep
+	for _, step := range t.Steps {
+		if step.IsToolCall() {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// Plugins 返回调用过的插件或工具名称, 按首次调用顺序去重
+func (t *ThoughtTrace) Plugins() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, step := range t.ToolCalls() {
+		if step.ActionName != "" && !seen[step.ActionName] {
+			seen[step.ActionName] = true
+			names = append(names, step.ActionName)
+		}
+	}
+	return names
+}
+
+// Text 渲染为纯文本, 每个步骤一段
+func (t *ThoughtTrace) Text() string {
+	var builder strings.Builder
+	for i, step := range t.Steps {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+
+		builder.WriteString(fmt.Sprintf("[%d] %s", step.Index+1, step.title()))
+		if step.Duration > 0 {
+			builder.WriteString(fmt.Sprintf(" (%s)", formatDuration(step.Duration)))
+		}
+		builder.WriteString("\n")
+
+		writeField := func(name string, value string) {
+			if value != "" {
+				builder.WriteString(fmt.Sprintf("  %s: %s\n", name, strings.Replace(value, "\n", "\n    ", -1)))
+			}
+		}
+		writeField("思考", step.Thought)
+		writeField("输入", compactJSON(step.Input))
+		writeField("输出", compactJSON(step.Output))
+		writeField("回复", step.Response)
+	}
+	return builder.String()
+}
+
+// Markdown 渲染为Markdown, 输入输出为JSON时使用代码块
+func (t *ThoughtTrace) Markdown() string {
+	var builder strings.Builder
+	for i, step := range t.Steps {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+
+		builder.WriteString(fmt.Sprintf("#### 步骤%d: %s", step.Index+1, step.title()))
+		if step.Duration > 0 {
+			builder.WriteString(fmt.Sprintf(" _(%s)_", formatDuration(step.Duration)))
+		}
+		builder.WriteString("\n\n")
+
+		if step.Thought != "" {
+			builder.WriteString(fmt.Sprintf("**思考:** %s\n\n", step.Thought))
+		}
+		writeMarkdownValue(&builder, "输入", step.Input)
+		writeMarkdownValue(&builder, "输出", step.Output)
+		if step.Response != "" {
+			builder.WriteString(fmt.Sprintf("**回复:** %s\n\n", step.Response))
+		}
+	}
+	return strings.TrimRight(builder.String(), "\n") + "\n"
+}
+
+// JSON 渲染为JSON, 用于审计记录或前端展示
+func (t *ThoughtTrace) JSON() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (s *ThoughtStep) title() string {
+	switch {
+	case s.ActionName != "":
+		return "调用 " + s.ActionName
+	case s.ActionType == ActionTypeResponse:
+		return "回复"
+	case s.ActionType != "":
+		return string(s.ActionType)
+	}
+	return "思考"
+}
+
+func writeMarkdownValue(builder *strings.Builder, name string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		builder.WriteString(fmt.Sprintf("**%s:** %s\n\n", name, v))
+	default:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			data = []byte(fmt.Sprint(v))
+		}
+		builder.WriteString(fmt.Sprintf("**%s:**\n\n```json\n%s\n```\n\n", name, data))
+	}
+}
+
+func compactJSON(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// ThoughtMerger 合并流式响应中的思考过程片段, 并记录每个步骤的开始时间和耗时, 并发安全.
+// 同一位置的Thought按序号合并; ActionInputStream既支持累积输出, 也支持增量输出
+type ThoughtMerger struct {
+	// Now 返回当前时间, 为nil时使用time.Now, 用于测试
+	Now func() time.Time
+
+	mutex    sync.Mutex
+	thoughts []CompletionResponseDataThought
+	started  []time.Time
+	updated  time.Time
+}
+
+func NewThoughtMerger() *ThoughtMerger {
+	return &ThoughtMerger{}
+}
+
+// Add 合并一个流式响应中的思考过程
+func (m *ThoughtMerger) Add(response *CompletionResponse) {
+	if response == nil || response.Data == nil || len(response.Data.Thoughts) == 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now
+	if m.Now != nil {
+		now = m.Now
+	}
+	m.updated = now()
+
+	for i, thought := range response.Data.Thoughts {
+		if i >= len(m.thoughts) {
+			m.thoughts = append(m.thoughts, thought)
+			m.started = append(m.started, m.updated)
+			continue
+		}
+		mergeThought(&m.thoughts[i], thought)
+	}
+}
+
+// Trace 返回当前合并结果, 流结束前调用时最后一个步骤可能不完整
+func (m *ThoughtMerger) Trace() *ThoughtTrace {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	trace := ParseThoughts(m.thoughts)
+	for i := range trace.Steps {
+		end := m.updated
+		if i+1 < len(m.started) {
+			end = m.started[i+1]
+		}
+
+		started := m.started[i]
+		trace.Steps[i].StartedAt = &started
+		trace.Steps[i].Duration = end.Sub(m.started[i])
+	}
+	return trace
+}
+
+// MergeThoughtStream 读取流式响应并合并思考过程, 每个响应原样转发到返回的通道; 流结束后可从merger读取完整结果
+func MergeThoughtStream(ch <-chan *CompletionResponse, merger *ThoughtMerger) chan *CompletionResponse {
+	result := make(chan *CompletionResponse)
+	go func() {
+		defer close(result)
+		for response := range ch {
+			merger.Add(response)
+			result <- response
+		}
+	}()
+	return result
+}
+
+func mergeThought(target *CompletionResponseDataThought, fragment CompletionResponseDataThought) {
+	mergeField := func(target *string, value string) {
+		if value != "" {
+			*target = value
+		}
+	}
+
+	mergeField(&target.Thought, fragment.Thought)
+	mergeField((*string)(&target.ActionType), string(fragment.ActionType))
+	mergeField(&target.ActionName, fragment.ActionName)
+	mergeField(&target.Action, fragment.Action)
+	mergeField(&target.ActionInput, fragment.ActionInput)
+	mergeField(&target.Response, fragment.Response)
+	mergeField(&target.Observation, fragment.Observation)
+
+	//累积输出时新片段包含之前的全部内容, 否则为增量
+	if strings.HasPrefix(fragment.ActionInputStream, target.ActionInputStream) {
+		target.ActionInputStream = fragment.ActionInputStream
+	} else {
+		target.ActionInputStream += fragment.ActionInputStream
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for thought trace
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"encoding/json"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pluginThoughts() []client.CompletionResponseDataThought {
+	return []client.CompletionResponseDataThought{
+		{Thought: "需要查询天气", ActionType: client.ActionTypeApi, ActionName: "weather",
+			ActionInput: `{"city":"杭州"}`, Observation: `"{\"temp\":25,\"desc\":\"晴\"}"`},
+		{ActionType: client.ActionTypeResponse, Response: "杭州今天晴, 25度"},
+	}
+}
+
+func TestParseThoughts(t *testing.T) {
+	response := textResponse("杭州今天晴, 25度")
+	response.Data.Thoughts = pluginThoughts()
+
+	trace := response.Thoughts()
+	if len(trace.Steps) != 2 {
+		t.Fatalf("unexpected trace: %s", trace)
+	}
+
+	step := trace.Steps[0]
+	input, _ := step.Input.(map[string]interface{})
+	output, _ := step.Output.(map[string]interface{})
+	if input["city"] != "杭州" || output["temp"] != 25.0 || !step.IsToolCall() || step.Raw().Observation == "" {
+		t.Errorf("unexpected step: %s", step)
+	}
+
+	if plugins := trace.Plugins(); len(plugins) != 1 || plugins[0] != "weather" {
+		t.Errorf("unexpected plugins: %v", plugins)
+	}
+
+	expected := "[1] 调用 weather\n" +
+		"  思考: 需要查询天气\n" +
+		"  输入: {\"city\":\"杭州\"}\n" +
+		"  输出: {\"desc\":\"晴\",\"temp\":25}\n" +
+		"\n[2] 回复\n" +
+		"  回复: 杭州今天晴, 25度\n"
+	if text := trace.Text(); text != expected {
+		t.Errorf("unexpected text:\n%s", text)
+	}
+
+	markdown := trace.Markdown()
+	if !strings.HasPrefix(markdown, "#### 步骤1: 调用 weather\n\n**思考:** 需要查询天气\n\n**输入:**\n\n```json\n{\n  \"city\": \"杭州\"\n}\n```") ||
+		!strings.HasSuffix(markdown, "#### 步骤2: 回复\n\n**回复:** 杭州今天晴, 25度\n") {
+		t.Errorf("unexpected markdown:\n%s", markdown)
+	}
+
+	data, err := trace.JSON()
+	if err != nil || !strings.Contains(string(data), `"Output":{"desc":"晴","temp":25}`) || strings.Contains(string(data), "StartedAt") {
+		t.Errorf("unexpected json: %s, err: %v", data, err)
+	}
+
+	if steps := (*client.CompletionResponse)(nil).Thoughts().Steps; len(steps) != 0 {
+		t.Errorf("unexpected steps of nil response: %v", steps)
+	}
+}
+
+func TestThoughtMerger(t *testing.T) {
+	fragments := [][]client.CompletionResponseDataThought{
+		{{Thought: "需要查询天气", ActionType: client.ActionTypeApi, ActionName: "weather", ActionInputStream: `{"ci`}},
+		{{ActionInputStream: `ty":"杭州"}`}},
+		{{Observation: `{"temp":25}`}, {ActionType: client.ActionTypeResponse, Response: "杭州"}},
+		{{}, {Response: "杭州今天晴"}},
+	}
+
+	server := newMockCompletionServer(t, func(request *client.CompletionRequest) []*client.CompletionResponse {
+		var responses []*client.CompletionResponse
+		for _, thoughts := range fragments {
+			response := textResponse("")
+			response.Data.Thoughts = thoughts
+			responses = append(responses, response)
+		}
+		return responses
+	})
+
+	ch, err := server.client().CreateStreamCompletion(&client.CompletionRequest{AppId: "app", Prompt: "杭州天气", HasThoughts: true})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	start := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	tick := 0
+	merger := client.NewThoughtMerger()
+	merger.Now = func() time.Time {
+		tick++
+		return start.Add(time.Duration(tick) * time.Second)
+	}
+
+	count := 0
+	for range client.MergeThoughtStream(ch, merger) {
+		count++
+	}
+
+	trace := merger.Trace()
+	if count != 4 || len(trace.Steps) != 2 {
+		t.Fatalf("unexpected trace: %s", trace)
+	}
+
+	first, second := trace.Steps[0], trace.Steps[1]
+	if data, _ := json.Marshal(first.Input); string(data) != `{"city":"杭州"}` || first.Raw().ActionInputStream != `{"city":"杭州"}` {
+		t.Errorf("unexpected input: %s", data)
+	}
+
+	if first.Duration != 2*time.Second || second.Duration != time.Second || !second.StartedAt.Equal(start.Add(3*time.Second)) {
+		t.Errorf("unexpected durations: %v, %v", first.Duration, second.Duration)
+	}
+
+	if second.Response != "杭州今天晴" {
+		t.Errorf("unexpected response: %s", second.Response)
+	}
+
+	if text := trace.Text(); !strings.HasPrefix(text, "[1] 调用 weather (2s)\n") {
+		t.Errorf("unexpected text:\n%s", text)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief local tool registry for agent runner
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultToolTimeout = 30 * time.Second
+
+var ErrToolNotFound = errors.New("Tool not found")
+
+// ToolHandler 工具的实现, input为模型给出的JSON参数, 返回的文本作为Observation反馈给模型
+type ToolHandler func(ctx context.Context, input json.RawMessage) (string, error)
+
+// Tool 本地工具定义
+type Tool struct {
+	Name        string      `json:"Name"`
+	Description string      `json:"Description"`
+	Parameters  *JSONSchema `json:"Parameters,omitempty"`
+	Handler     ToolHandler `json:"-"`
+	// Timeout 单次调用超时时间, 为0时使用DefaultToolTimeout
+	Timeout time.Duration `json:"-"`
+}
+
+func (t Tool) String() string {
+	return tea.Prettify(t)
+}
+
+func (t Tool) GoString() string {
+	return t.String()
+}
+
+// ToolCall 模型发起的一次工具调用
+type ToolCall struct {
+	Name  string `json:"Name"`
+	Input string `json:"Input,omitempty"`
+}
+
+func (c ToolCall) String() string {
+	return tea.Prettify(c)
+}
+
+func (c ToolCall) GoString() string {
+	return c.String()
+}
+
+// ToolRegistry 工具注册表, 并发安全
+type ToolRegistry struct {
+	mutex sync.RWMutex
+	tools map[string]*Tool
+}
+
+func NewToolRegistry(tools ...*Tool) (*ToolRegistry, error) {
+	registry := &ToolRegistry{tools: make(map[string]*Tool)}
+	for _, tool := range tools {
+		if err := registry.Register(tool); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// Register 注册工具, 名称不能为空、包含空白或与已有工具重复
+func (r *ToolRegistry) Register(tool *Tool) error {
+	if tool.Name == "" || strings.ContainsAny(tool.Name, " \t\r\n") {
+		return fmt.Errorf("Invalid tool name %q", tool.Name)
+	}
+
+	if tool.Handler == nil {
+		return fmt.Errorf("Tool %s has no handler", tool.Name)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.tools[tool.Name]; ok {
+		return fmt.Errorf("Duplicated tool %s", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	return nil
+}
+
+func (r *ToolRegistry) Get(name string) (*Tool, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Tools 返回所有工具, 按名称排序
+func (r *ToolRegistry) Tools() []*Tool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	tools := make([]*Tool, 0, len(r.tools))
+	for _, tool := range r.tools {
+		tools = append(tools, tool)
+	}
+
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+	return tools
+}
+
+// Describe 返回用于提示词的工具说明, 每个工具一行: 名称、说明和参数的JSON Schema
+func (r *ToolRegistry) Describe() string {
+	var lines []string
+	for _, tool := range r.Tools() {
+		line := fmt.Sprintf("%s: %s", tool.Name, tool.Description)
+		if tool.Parameters != nil {
+			data, _ := json.Marshal(tool.Parameters)
+			line += " 参数: " + string(data)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Call 校验参数并调用工具, 超时、panic和参数错误都以error返回
+func (r *ToolRegistry) Call(ctx context.Context, call ToolCall) (_result string, _err error) {
+	tool, ok := r.Get(call.Name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrToolNotFound, call.Name)
+	}
+
+	input := strings.TrimSpace(call.Input)
+	if input == "" {
+		input = "{}"
+	}
+
+	if tool.Parameters != nil {
+		var value interface{}
+		if err := json.Unmarshal([]byte(input), &value); err != nil {
+			return "", fmt.Errorf("Invalid input of tool %s: %v", tool.Name, err)
+		}
+
+		if errs := tool.Parameters.Validate(value); len(errs) > 0 {
+			return "", fmt.Errorf("Invalid input of tool %s: %s", tool.Name, strings.Join(errs, "; "))
+		}
+	}
+
+	timeout := tool.Timeout
+	if timeout <= 0 {
+		timeout = DefaultToolTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		output string
+		err    error
+	}
+
+	//handler未响应ctx取消时也按超时返回, 结果通道带缓冲避免goroutine泄漏
+	ch := make(chan result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				ch <- result{err: fmt.Errorf("Tool %s panic: %v", tool.Name, p)}
+			}
+		}()
+
+		output, err := tool.Handler(ctx, json.RawMessage(input))
+		ch <- result{output: output, err: err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.output, res.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("Tool %s failed: %v", tool.Name, ctx.Err())
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief typed constants for completion request and response
+ * @version 1.0.0
+ */
+
+package broadscope_bailian
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role 对话消息的角色
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	// RoleTool 工具调用的观察结果
+	RoleTool Role = "tool"
+)
+
+// ResultFormat 模型返回内容的结构, text返回在Data.Text中, message返回在Data.Choices中
+type ResultFormat string
+
+const (
+	ResultFormatText    ResultFormat = "text"
+	ResultFormatMessage ResultFormat = "message"
+)
+
+// FinishReason 生成结束原因, 流式输出过程中为null
+type FinishReason string
+
+const (
+	FinishReasonNull   FinishReason = "null"
+	FinishReasonStop   FinishReason = "stop"
+	FinishReasonLength FinishReason = "length"
+)
+
+// ActionType 插件和流程编排应用返回的思考过程类型
+type ActionType string
+
+const (
+	ActionTypeApi       ActionType = "api"
+	ActionTypeReasoning ActionType = "reasoning"
+	ActionTypeResponse  ActionType = "response"
+)
+
+var (
+	knownRoles         = []Role{RoleSystem, RoleUser, RoleAssistant, RoleTool}
+	knownResultFormats = []ResultFormat{ResultFormatText, ResultFormatMessage}
+	knownFinishReasons = []FinishReason{FinishReasonNull, FinishReasonStop, FinishReasonLength}
+	knownActionTypes   = []ActionType{ActionTypeApi, ActionTypeReasoning, ActionTypeResponse}
+)
+
+func (r Role) String() string {
+	return string(r)
+}
+
+// IsKnown 是否为SDK已定义的角色, 服务端返回的未知角色仍会原样保留
+func (r Role) IsKnown() bool {
+	for _, known := range knownRoles {
+		if r == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRole 解析角色, 忽略大小写和首尾空白; 未知角色原样返回并附带错误
+func ParseRole(s string) (Role, error) {
+	normalized := Role(strings.ToLower(strings.TrimSpace(s)))
+	if normalized.IsKnown() {
+		return normalized, nil
+	}
+	return Role(s), fmt.Errorf("Unknown role: %q", s)
+}
+
+func (f ResultFormat) String() string {
+	return string(f)
+}
+
+func (f ResultFormat) IsKnown() bool {
+	for _, known := range knownResultFormats {
+		if f == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseResultFormat 解析返回结构, 空字符串按服务端默认值text处理
+func ParseResultFormat(s string) (ResultFormat, error) {
+	normalized := ResultFormat(strings.ToLower(strings.TrimSpace(s)))
+	if normalized == "" {
+		return ResultFormatText, nil
+	}
+	if normalized.IsKnown() {
+		return normalized, nil
+	}
+	return ResultFormat(s), fmt.Errorf("Unknown result format: %q", s)
+}
+
+func (f FinishReason) String() string {
+	return string(f)
+}
+
+func (f FinishReason) IsKnown() bool {
+	for _, known := range knownFinishReasons {
+		if f == known {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinished 是否已生成结束, 空值与null均表示尚未结束
+func (f FinishReason) IsFinished() bool {
+	return f != "" && f != FinishReasonNull
+}
+
+func ParseFinishReason(s string) (FinishReason, error) {
+	normalized := FinishReason(strings.ToLower(strings.TrimSpace(s)))
+	if normalized.IsKnown() {
+		return normalized, nil
+	}
+	return FinishReason(s), fmt.Errorf("Unknown finish reason: %q", s)
+}
+
+func (a ActionType) String() string {
+	return string(a)
+}
+
+func (a ActionType) IsKnown() bool {
+	for _, known := range knownActionTypes {
+		if a == known {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseActionType(s string) (ActionType, error) {
+	normalized := ActionType(strings.ToLower(strings.TrimSpace(s)))
+	if normalized.IsKnown() {
+		return normalized, nil
+	}
+	return ActionType(s), fmt.Errorf("Unknown action type: %q", s)
+}
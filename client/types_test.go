@@ -0,0 +1,92 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for typed constants
+ * @version 1.0.0
+ */
+
+package broadscope_bailian_test
+
+import (
+	"encoding/json"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"testing"
+)
+
+func TestParseTypedConstants(t *testing.T) {
+	role, err := client.ParseRole(" System ")
+	if err != nil || role != client.RoleSystem {
+		t.Errorf("unexpected role: %q, err: %v", role, err)
+	}
+
+	role, err = client.ParseRole("function")
+	if err == nil || role != client.Role("function") || role.IsKnown() {
+		t.Errorf("unknown role should be kept with error, role: %q, err: %v", role, err)
+	}
+
+	format, err := client.ParseResultFormat("")
+	if err != nil || format != client.ResultFormatText {
+		t.Errorf("empty result format should default to text, got: %q, err: %v", format, err)
+	}
+
+	reason, err := client.ParseFinishReason("length")
+	if err != nil || !reason.IsFinished() {
+		t.Errorf("unexpected finish reason: %q, err: %v", reason, err)
+	}
+
+	if client.FinishReasonNull.IsFinished() || client.FinishReason("").IsFinished() {
+		t.Errorf("null finish reason should not be finished")
+	}
+
+	actionType, err := client.ParseActionType("API")
+	if err != nil || actionType != client.ActionTypeApi {
+		t.Errorf("unexpected action type: %q, err: %v", actionType, err)
+	}
+}
+
+func TestTypedConstantsRoundTrip(t *testing.T) {
+	body := `{"Success":true,"Data":{"ResponseId":"1",` +
+		`"Thoughts":[{"ActionType":"plugin_v2","ActionName":"weather"}],` +
+		`"Choices":[{"FinishReason":"content_filter","Message":{"Role":"function","Content":"ok"}}]}}`
+
+	response := &client.CompletionResponse{}
+	if err := json.Unmarshal([]byte(body), response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	choice := response.Data.Choices[0]
+	if choice.FinishReason != "content_filter" || choice.Message.Role != "function" {
+		t.Errorf("unknown values should be kept, got: %s", choice)
+	}
+
+	if response.Data.Thoughts[0].ActionType != "plugin_v2" {
+		t.Errorf("unknown action type should be kept, got: %q", response.Data.Thoughts[0].ActionType)
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+
+	again := &client.CompletionResponse{}
+	if err := json.Unmarshal(data, again); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	if again.Data.Choices[0].FinishReason != choice.FinishReason || again.Data.Choices[0].Message.Role != choice.Message.Role {
+		t.Errorf("round trip lost values, got: %s", again)
+	}
+
+	request := client.CompletionRequest{
+		Messages:   []client.ChatCompletionMessage{{Role: client.RoleUser, Content: "hi"}},
+		Parameters: &client.CompletionRequestModelParameter{ResultFormat: client.ResultFormatMessage},
+	}
+	data, _ = json.Marshal(request)
+	expected := `"Messages":[{"Role":"user","Content":"hi"}],"Parameters":{"ResultFormat":"message"}`
+	if !json.Valid(data) || !strings.Contains(string(data), expected) {
+		t.Errorf("unexpected request json: %s", data)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief batch command for bailian command-line tool
+ * @version 1.0.0
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/prompt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+var ErrNoInput = errors.New("Missing -input file")
+
+func runBatch(e *env, args []string) error {
+	fs := newFlagSet(e, "batch", "batch -input FILE [flags]")
+	opts := addCommonFlags(fs)
+	input := fs.String("input", "", "input JSONL file, one request or template vars per line")
+	out := fs.String("out", "", "output JSONL file, default is input with .out.jsonl suffix; rerun to resume")
+	concurrency := fs.Int("concurrency", client.DefaultBatchConcurrency, "concurrent requests")
+	rate := fs.Float64("rate", 0, "max requests per second, 0 means unlimited")
+	retries := fs.Int("retries", client.DefaultBatchMaxRetries, "max retries per request, -1 disables retry")
+	templates := fs.String("templates", "", "prompt template directory for rows with vars")
+	template := fs.String("template", "", "template name for rows with vars")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *input == "" {
+		fs.Usage()
+		return ErrNoInput
+	}
+
+	s, err := resolve(opts, e.getenv)
+	if err != nil {
+		return err
+	}
+
+	output := *out
+	if output == "" {
+		output = strings.TrimSuffix(*input, ".jsonl") + ".out.jsonl"
+	}
+
+	cc, err := s.completionClient()
+	if err != nil {
+		return err
+	}
+
+	maxRetries := *retries
+	if maxRetries == 0 {
+		maxRetries = -1
+	}
+
+	runner := &client.BatchFileRunner{
+		Executor: &client.BatchExecutor{Client: cc, Concurrency: *concurrency, RateLimit: *rate, MaxRetries: maxRetries},
+		AppId:    s.AppId,
+	}
+
+	if *template != "" {
+		engine := prompt.NewEngine()
+		if *templates != "" {
+			if err = engine.LoadDir(*templates); err != nil {
+				return err
+			}
+		}
+
+		runner.Render = func(vars map[string]interface{}) (*client.CompletionRequest, error) {
+			request := &client.CompletionRequest{AppId: s.AppId}
+			if err := engine.Apply(request, *template, vars); err != nil {
+				return nil, err
+			}
+			return request, nil
+		}
+	}
+
+	if s.Output == OutputText {
+		runner.Executor.OnProgress = func(progress client.BatchProgress) {
+			fmt.Fprintf(e.stderr, "\rdone: %d, failed: %d, retries: %d", progress.Done, progress.Failed, progress.Retries)
+		}
+	}
+
+	//中断时已完成的结果已写入输出文件, 重新运行即可继续
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary, err := runner.Run(ctx, *input, output)
+	if s.Output == OutputText {
+		fmt.Fprintln(e.stderr)
+	}
+
+	if summary != nil {
+		if s.Output == OutputJSON {
+			if writeErr := writeJSON(e.stdout, summary, true); writeErr != nil && err == nil {
+				err = writeErr
+			}
+		} else {
+			fmt.Fprintf(e.stdout, "total: %d, skipped: %d, succeeded: %d, failed: %d, input tokens: %d, output tokens: %d\n",
+				summary.Total, summary.Skipped, summary.Succeeded, summary.Failed, summary.Usage.InputTokens, summary.Usage.OutputTokens)
+			fmt.Fprintf(e.stdout, "results written to %s\n", output)
+		}
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief complete command for bailian command-line tool
+ * @version 1.0.0
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"io/ioutil"
+	"strings"
+)
+
+var ErrEmptyPrompt = errors.New("Prompt is empty")
+
+func runComplete(e *env, args []string) error {
+	fs := newFlagSet(e, "complete", "complete [flags] [prompt]")
+	opts := addCommonFlags(fs)
+	system := fs.String("system", "", "system message, sends prompt as messages when set")
+	stream := fs.Bool("stream", true, "stream output to stdout")
+	sessionId := fs.String("session-id", "", "session id for server-side history")
+	resultFormat := fs.String("result-format", "", "result format, text or message")
+	temperature := fs.Float64("temperature", 0, "sampling temperature, 0 means server default")
+	topP := fs.Float64("top-p", 0, "top p, 0 means server default")
+	topK := fs.Int("top-k", 0, "top k, 0 means server default")
+	seed := fs.Int("seed", 0, "random seed, 0 means server default")
+	maxTokens := fs.Int("max-tokens", 0, "max output tokens, 0 means server default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := resolve(opts, e.getenv)
+	if err != nil {
+		return err
+	}
+
+	//未指定prompt或为"-"时从标准输入读取
+	prompt := strings.Join(fs.Args(), " ")
+	if prompt == "" || prompt == "-" {
+		data, err := ioutil.ReadAll(e.stdin)
+		if err != nil {
+			return err
+		}
+		prompt = string(data)
+	}
+
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return ErrEmptyPrompt
+	}
+
+	if err = s.requireAppId(); err != nil {
+		return err
+	}
+
+	cc, err := s.completionClient()
+	if err != nil {
+		return err
+	}
+
+	request := &client.CompletionRequest{AppId: s.AppId, SessionId: *sessionId, TopP: float32(*topP)}
+	if *system != "" {
+		request.Messages = []client.ChatCompletionMessage{
+			{Role: client.RoleSystem, Content: *system},
+			{Role: client.RoleUser, Content: prompt},
+		}
+	} else {
+		request.Prompt = prompt
+	}
+
+	parameters := &client.CompletionRequestModelParameter{Temperature: float32(*temperature), TopK: int32(*topK),
+		Seed: int32(*seed), MaxTokens: int32(*maxTokens), IncrementalOutput: *stream}
+	if *resultFormat != "" {
+		if parameters.ResultFormat, err = client.ParseResultFormat(*resultFormat); err != nil {
+			return err
+		}
+	}
+	request.Parameters = parameters
+
+	if !*stream {
+		response, err := cc.CreateCompletion(request)
+		if err != nil {
+			return err
+		}
+
+		if s.Output == OutputJSON {
+			if err = writeJSON(e.stdout, response, true); err != nil {
+				return err
+			}
+			return response.Err()
+		}
+
+		if err = response.Err(); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(e.stdout, response.OutputText())
+		return err
+	}
+
+	ch, err := cc.CreateStreamCompletion(request)
+	if err != nil {
+		return err
+	}
+
+	//增量输出, 文本模式下直接拼接每个片段
+	var streamErr error
+	for response := range ch {
+		if streamErr != nil {
+			continue
+		}
+
+		if s.Output == OutputJSON {
+			if err = writeJSON(e.stdout, response, false); err != nil {
+				streamErr = err
+				continue
+			}
+		} else if response.Err() == nil {
+			if _, err = fmt.Fprint(e.stdout, response.OutputText()); err != nil {
+				streamErr = err
+				continue
+			}
+		}
+		streamErr = response.Err()
+	}
+
+	if s.Output == OutputText {
+		fmt.Fprintln(e.stdout)
+	}
+	return streamErr
+}
@@ -0,0 +1,228 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief credentials and profile resolution for bailian command-line tool
+ * @version 1.0.0
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultProfileName = "default"
+
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// 环境变量, 优先级高于配置文件, 低于命令行参数
+const (
+	EnvAccessKeyId     = "ALIBABA_CLOUD_ACCESS_KEY_ID"
+	EnvAccessKeySecret = "ALIBABA_CLOUD_ACCESS_KEY_SECRET"
+	EnvAgentKey        = "BAILIAN_AGENT_KEY"
+	EnvAppId           = "BAILIAN_APP_ID"
+	EnvEndpoint        = "BAILIAN_ENDPOINT"
+	EnvPopEndpoint     = "BAILIAN_POP_ENDPOINT"
+	EnvToken           = "BAILIAN_TOKEN"
+	EnvProfile         = "BAILIAN_PROFILE"
+	EnvConfig          = "BAILIAN_CONFIG"
+)
+
+var ErrProfileNotFound = errors.New("Profile not found")
+
+// Profile 配置文件中的一组凭证和应用配置
+type Profile struct {
+	AccessKeyId     string `json:"AccessKeyId,omitempty"`
+	AccessKeySecret string `json:"AccessKeySecret,omitempty"`
+	AgentKey        string `json:"AgentKey,omitempty"`
+	AppId           string `json:"AppId,omitempty"`
+	// Endpoint 文本生成接口地址, 为空时使用SDK默认值
+	Endpoint string `json:"Endpoint,omitempty"`
+	// PopEndpoint 创建Token和文本向量的OpenAPI地址, 为空时使用SDK默认值
+	PopEndpoint string `json:"PopEndpoint,omitempty"`
+}
+
+// Config 配置文件, 默认为~/.bailian/config.json
+type Config struct {
+	// DefaultProfile 未指定-profile和BAILIAN_PROFILE时使用的配置, 为空时使用default
+	DefaultProfile string             `json:"DefaultProfile,omitempty"`
+	Profiles       map[string]Profile `json:"Profiles"`
+}
+
+// options 所有子命令共用的参数
+type options struct {
+	profile         string
+	config          string
+	accessKeyId     string
+	accessKeySecret string
+	agentKey        string
+	appId           string
+	endpoint        string
+	popEndpoint     string
+	token           string
+	output          string
+	timeout         time.Duration
+}
+
+func addCommonFlags(fs *flag.FlagSet) *options {
+	opts := &options{}
+	fs.StringVar(&opts.profile, "profile", "", "profile name in config file, env "+EnvProfile)
+	fs.StringVar(&opts.config, "config", "", "config file, env "+EnvConfig+", default ~/.bailian/config.json")
+	fs.StringVar(&opts.accessKeyId, "access-key-id", "", "access key id, env "+EnvAccessKeyId)
+	fs.StringVar(&opts.accessKeySecret, "access-key-secret", "", "access key secret, env "+EnvAccessKeySecret)
+	fs.StringVar(&opts.agentKey, "agent-key", "", "agent key, env "+EnvAgentKey)
+	fs.StringVar(&opts.appId, "app-id", "", "app id, env "+EnvAppId)
+	fs.StringVar(&opts.endpoint, "endpoint", "", "completion endpoint, env "+EnvEndpoint)
+	fs.StringVar(&opts.popEndpoint, "pop-endpoint", "", "openapi endpoint for token and embedding, env "+EnvPopEndpoint)
+	fs.StringVar(&opts.token, "token", "", "existing token, skips token creation and is never refreshed, use access key for long runs, env "+EnvToken)
+	fs.StringVar(&opts.output, "output", OutputText, "output format, text or json")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "request timeout, 0 means no timeout")
+	return opts
+}
+
+// settings 合并命令行参数、环境变量和配置文件后的配置
+type settings struct {
+	Profile
+	ProfileName string
+	Token       string
+	Output      string
+	Timeout     time.Duration
+}
+
+// resolve 按命令行参数 > 环境变量 > 配置文件的优先级合并配置
+func resolve(opts *options, getenv func(string) string) (*settings, error) {
+	if opts.output != OutputText && opts.output != OutputJSON {
+		return nil, fmt.Errorf("Invalid output format: %s, expected text or json", opts.output)
+	}
+
+	path, explicit := first(opts.config, getenv(EnvConfig)), true
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			path = filepath.Join(home, ".bailian", "config.json")
+		}
+		explicit = false
+	}
+
+	config := &Config{}
+	if path != "" {
+		loaded, err := loadConfig(path)
+		if err != nil && (explicit || !os.IsNotExist(err)) {
+			return nil, err
+		}
+
+		if loaded != nil {
+			config = loaded
+		}
+	}
+
+	//显式指定的配置不存在时报错, 默认配置不存在时只使用环境变量和命令行参数
+	name := first(opts.profile, getenv(EnvProfile))
+	profileName := first(name, config.DefaultProfile, DefaultProfileName)
+	profile, ok := config.Profiles[profileName]
+	if !ok && name != "" {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+
+	s := &settings{ProfileName: profileName, Output: opts.output, Timeout: opts.timeout}
+	s.AccessKeyId = first(opts.accessKeyId, getenv(EnvAccessKeyId), profile.AccessKeyId)
+	s.AccessKeySecret = first(opts.accessKeySecret, getenv(EnvAccessKeySecret), profile.AccessKeySecret)
+	s.AgentKey = first(opts.agentKey, getenv(EnvAgentKey), profile.AgentKey)
+	s.AppId = first(opts.appId, getenv(EnvAppId), profile.AppId)
+	s.Endpoint = first(opts.endpoint, getenv(EnvEndpoint), profile.Endpoint)
+	s.PopEndpoint = first(opts.popEndpoint, getenv(EnvPopEndpoint), profile.PopEndpoint)
+	s.Token = first(opts.token, getenv(EnvToken))
+	return s, nil
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// first 返回第一个非空值
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// requireCredentials 创建Token和调用OpenAPI需要AccessKey和AgentKey
+func (s *settings) requireCredentials() error {
+	if s.AccessKeyId == "" || s.AccessKeySecret == "" {
+		return fmt.Errorf("Missing access key, set -access-key-id and -access-key-secret, %s and %s, or profile %s",
+			EnvAccessKeyId, EnvAccessKeySecret, s.ProfileName)
+	}
+
+	if s.AgentKey == "" {
+		return fmt.Errorf("Missing agent key, set -agent-key, %s, or profile %s", EnvAgentKey, s.ProfileName)
+	}
+	return nil
+}
+
+func (s *settings) requireAppId() error {
+	if s.AppId == "" {
+		return fmt.Errorf("Missing app id, set -app-id, %s, or profile %s", EnvAppId, s.ProfileName)
+	}
+	return nil
+}
+
+func (s *settings) tokenClient() (*client.AccessTokenClient, error) {
+	if err := s.requireCredentials(); err != nil {
+		return nil, err
+	}
+
+	return &client.AccessTokenClient{AccessKeyId: s.AccessKeyId, AccessKeySecret: s.AccessKeySecret,
+		AgentKey: s.AgentKey, Endpoint: s.PopEndpoint}, nil
+}
+
+// completionClient 设置了Token时直接使用且不会刷新, 否则使用AccessKey创建Token, 并在过期前重新创建
+func (s *settings) completionClient() (*client.CompletionClient, error) {
+	if s.Token != "" {
+		return &client.CompletionClient{Token: s.Token, Endpoint: s.Endpoint, Timeout: s.Timeout}, nil
+	}
+
+	tokenClient, err := s.tokenClient()
+	if err != nil {
+		return nil, err
+	}
+
+	//提前创建Token, 凭证错误时立即返回
+	if _, err = tokenClient.GetToken(); err != nil {
+		return nil, err
+	}
+
+	//批量请求并发获取Token, AccessTokenClient缓存的Token不是并发安全的
+	var mutex sync.Mutex
+	tokenProvider := func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return tokenClient.GetToken()
+	}
+	return &client.CompletionClient{Endpoint: s.Endpoint, Timeout: s.Timeout, TokenProvider: tokenProvider}, nil
+}
@@ -0,0 +1,101 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief embed command for bailian command-line tool
+ * @version 1.0.0
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+)
+
+// embedPreview 文本模式下每个向量显示的维度数
+const embedPreview = 4
+
+var ErrNoTexts = errors.New("No texts to embed")
+
+func runEmbed(e *env, args []string) error {
+	fs := newFlagSet(e, "embed", "embed [flags] [text...]")
+	opts := addCommonFlags(fs)
+	textType := fs.String("text-type", "", "text type, query or document")
+	batchSize := fs.Int("batch-size", client.DefaultEmbeddingBatchSize, "texts per request")
+	concurrency := fs.Int("concurrency", client.DefaultEmbeddingConcurrency, "concurrent requests")
+	checkpoint := fs.String("checkpoint", "", "checkpoint file for resuming large inputs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := resolve(opts, e.getenv)
+	if err != nil {
+		return err
+	}
+
+	//未指定文本时从标准输入读取, 每行一个文本, 忽略空行
+	texts := fs.Args()
+	if len(texts) == 0 {
+		scanner := bufio.NewScanner(e.stdin)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				texts = append(texts, line)
+			}
+		}
+
+		if err = scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(texts) == 0 {
+		return ErrNoTexts
+	}
+
+	if err = s.requireCredentials(); err != nil {
+		return err
+	}
+
+	embeddingClient := &client.EmbeddingClient{AccessKeyId: s.AccessKeyId, AccessKeySecret: s.AccessKeySecret,
+		AgentKey: s.AgentKey, Endpoint: s.PopEndpoint, TextType: *textType}
+	embedder := &client.BulkEmbedder{Embedder: embeddingClient, BatchSize: *batchSize, Concurrency: *concurrency,
+		CheckpointFile: *checkpoint}
+
+	result, err := embedder.Embed(texts)
+	if err != nil {
+		return err
+	}
+
+	if s.Output == OutputJSON {
+		return writeJSON(e.stdout, result, false)
+	}
+
+	for i, embedding := range result.Embeddings {
+		values := make([]string, 0, embedPreview+1)
+		for j := 0; j < len(embedding) && j < embedPreview; j++ {
+			values = append(values, fmt.Sprintf("%.6f", embedding[j]))
+		}
+
+		if len(embedding) > embedPreview {
+			values = append(values, "...")
+		}
+		fmt.Fprintf(e.stdout, "[%d] dim=%d [%s] %s\n", i, len(embedding), strings.Join(values, ", "), preview(texts[i]))
+	}
+	fmt.Fprintf(e.stderr, "texts: %d, characters: %d\n", result.Usage.Texts, result.Usage.Characters)
+	return nil
+}
+
+// preview 截断过长的文本用于显示
+func preview(text string) string {
+	runes := []rune(text)
+	if len(runes) <= 32 {
+		return text
+	}
+	return string(runes[:32]) + "..."
+}
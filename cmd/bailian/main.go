@@ -0,0 +1,112 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief bailian command-line tool
+ * @version 1.0.0
+ */
+
+// bailian 百炼命令行工具, 用于调试应用和离线批量调用.
+//
+//	bailian complete [flags] [prompt]    单次文本生成, 未指定prompt时从标准输入读取
+//	bailian token create|inspect [flags] 创建Token或查看Token信息
+//	bailian embed [flags] [text...]      生成文本向量, 未指定文本时从标准输入按行读取
+//	bailian batch [flags]                执行JSONL批量文件, 支持断点续跑
+//
+// 凭证和AppId按命令行参数 > 环境变量 > 配置文件(~/.bailian/config.json)的优先级读取
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const usage = `Usage: bailian <command> [flags]
+
+Commands:
+  complete   create a completion, prompt from arguments or stdin
+  token      create or inspect an access token
+  embed      create text embeddings, texts from arguments or stdin lines
+  batch      run a JSONL batch file with resume
+
+Run "bailian <command> -h" for command flags.
+`
+
+// env 命令执行环境, 测试时替换标准输入输出和环境变量
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+}
+
+type command func(e *env, args []string) error
+
+var commands = map[string]command{
+	"complete": runComplete,
+	"token":    runToken,
+	"embed":    runEmbed,
+	"batch":    runBatch,
+}
+
+func main() {
+	e := &env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, getenv: os.Getenv}
+	os.Exit(run(e, os.Args[1:]))
+}
+
+func run(e *env, args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Fprint(e.stderr, usage)
+		return 2
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(e.stderr, "unknown command %q, expected one of: %s\n", args[0], strings.Join(names, ", "))
+		return 2
+	}
+
+	err := cmd(e, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(e.stderr, "bailian %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// newFlagSet 参数错误时由调用方输出错误, 不直接退出进程
+func newFlagSet(e *env, name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: bailian %s\n\nFlags:\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// writeJSON 流式输出时每行一个对象, 其他情况缩进输出
+func writeJSON(w io.Writer, v interface{}, indent bool) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if indent {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(v)
+}
@@ -0,0 +1,194 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for bailian command-line tool
+ * @version 1.0.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestEnv(stdin string, vars map[string]string) (*env, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	e := &env{stdin: strings.NewReader(stdin), stdout: stdout, stderr: stderr, getenv: func(key string) string {
+		return vars[key]
+	}}
+	return e, stdout, stderr
+}
+
+func writeConfig(t *testing.T, config *Config) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data, _ := json.Marshal(config)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestResolve(t *testing.T) {
+	path := writeConfig(t, &Config{
+		DefaultProfile: "dev",
+		Profiles: map[string]Profile{
+			"dev":  {AccessKeyId: "dev-id", AccessKeySecret: "dev-secret", AgentKey: "dev-agent", AppId: "dev-app"},
+			"prod": {AccessKeyId: "prod-id", AppId: "prod-app", Endpoint: "https://prod"},
+		},
+	})
+
+	//命令行参数 > 环境变量 > 配置文件
+	vars := map[string]string{EnvConfig: path, EnvAppId: "env-app", EnvAgentKey: "env-agent"}
+	s, err := resolve(&options{agentKey: "flag-agent", output: OutputText}, func(key string) string { return vars[key] })
+	if err != nil {
+		t.Fatalf("failed to resolve: %v", err)
+	}
+
+	if s.ProfileName != "dev" || s.AccessKeyId != "dev-id" || s.AppId != "env-app" || s.AgentKey != "flag-agent" {
+		t.Errorf("unexpected settings: %+v", s)
+	}
+
+	vars[EnvProfile] = "prod"
+	s, err = resolve(&options{output: OutputJSON}, func(key string) string { return vars[key] })
+	if err != nil || s.AccessKeyId != "prod-id" || s.Endpoint != "https://prod" || s.AccessKeySecret != "" {
+		t.Errorf("unexpected settings: %+v, err: %v", s, err)
+	}
+
+	if _, err = resolve(&options{profile: "missing", output: OutputText}, func(key string) string { return vars[key] }); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("expected profile not found, got: %v", err)
+	}
+
+	if _, err = resolve(&options{config: filepath.Join(t.TempDir(), "none.json"), output: OutputText}, func(string) string { return "" }); err == nil {
+		t.Errorf("expected error for missing explicit config")
+	}
+
+	if _, err = resolve(&options{output: "yaml"}, func(string) string { return "" }); err == nil {
+		t.Errorf("expected error for invalid output")
+	}
+}
+
+// newCompletionServer 返回"echo: 提示词", 收到的请求按序号保存到requests
+func newCompletionServer(t *testing.T, requests *[]*client.CompletionRequest) *httptest.Server {
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &client.CompletionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+
+		mutex.Lock()
+		*requests = append(*requests, request)
+		mutex.Unlock()
+
+		text := "echo: " + request.Prompt
+		if !request.Stream {
+			json.NewEncoder(w).Encode(&client.CompletionResponse{Success: true, RequestId: request.RequestId,
+				Data: &client.CompletionResponseData{Text: text}})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{text[:4], text[4:]} {
+			data, _ := json.Marshal(&client.CompletionResponse{Success: true, Data: &client.CompletionResponseData{Text: chunk}})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestComplete(t *testing.T) {
+	var requests []*client.CompletionRequest
+	server := newCompletionServer(t, &requests)
+	vars := map[string]string{EnvToken: "token", EnvEndpoint: server.URL, EnvConfig: writeConfig(t, &Config{})}
+
+	//流式输出, prompt从标准输入读取
+	e, stdout, stderr := newTestEnv("你好\n", vars)
+	if code := run(e, []string{"complete", "-app-id", "app"}); code != 0 {
+		t.Fatalf("unexpected exit code: %d, stderr: %s", code, stderr)
+	}
+
+	if stdout.String() != "echo: 你好\n" || !requests[0].Stream || requests[0].AppId != "app" || !requests[0].Parameters.IncrementalOutput {
+		t.Errorf("unexpected output: %q, request: %s", stdout, requests[0])
+	}
+
+	e, stdout, stderr = newTestEnv("", vars)
+	if code := run(e, []string{"complete", "-app-id", "app", "-stream=false", "-output", "json", "-system", "简洁回答", "你好"}); code != 0 {
+		t.Fatalf("unexpected exit code: %d, stderr: %s", code, stderr)
+	}
+
+	response := &client.CompletionResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil || !response.Success || len(requests[1].Messages) != 2 {
+		t.Errorf("unexpected json output: %s, err: %v", stdout, err)
+	}
+
+	//缺少AppId
+	e, _, stderr = newTestEnv("你好", vars)
+	if code := run(e, []string{"complete"}); code != 1 || !strings.Contains(stderr.String(), EnvAppId) {
+		t.Errorf("expected missing app id, got: %d, %s", code, stderr)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var requests []*client.CompletionRequest
+	server := newCompletionServer(t, &requests)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.jsonl")
+	rows := `{"custom_id": "a", "request": {"Prompt": "p1"}}` + "\n" + `{"custom_id": "b", "Prompt": "p2"}` + "\n"
+	if err := ioutil.WriteFile(input, []byte(rows), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	vars := map[string]string{EnvToken: "token", EnvEndpoint: server.URL, EnvAppId: "app", EnvConfig: writeConfig(t, &Config{})}
+	e, stdout, stderr := newTestEnv("", vars)
+	if code := run(e, []string{"batch", "-input", input, "-output", "json"}); code != 0 {
+		t.Fatalf("unexpected exit code: %d, stderr: %s", code, stderr)
+	}
+
+	summary := &client.BatchFileSummary{}
+	if err := json.Unmarshal(stdout.Bytes(), summary); err != nil || summary.Succeeded != 2 || requests[0].AppId != "app" {
+		t.Errorf("unexpected summary: %s, err: %v", stdout, err)
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "input.out.jsonl"))
+	if !strings.Contains(string(data), "echo: p2") {
+		t.Errorf("unexpected output file: %s", data)
+	}
+}
+
+func TestTokenInspect(t *testing.T) {
+	payload := `{"exp":1700000000,"sub":"agent"}`
+	token := "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	vars := map[string]string{EnvAccessKeyId: "LTAI1234567890", EnvConfig: writeConfig(t, &Config{})}
+
+	e, stdout, _ := newTestEnv("", vars)
+	if code := run(e, []string{"token", "inspect", "-output", "json", token}); code != 0 {
+		t.Fatalf("unexpected exit code: %d", code)
+	}
+
+	inspection := &Inspection{}
+	if err := json.Unmarshal(stdout.Bytes(), inspection); err != nil || inspection.AccessKeyId != "LTAI******7890" ||
+		inspection.Claims["sub"] != "agent" || inspection.ExpiresAt == "" {
+		t.Errorf("unexpected inspection: %s, err: %v", stdout, err)
+	}
+
+	e, _, _ = newTestEnv("", vars)
+	if code := run(e, []string{"token"}); code != 1 {
+		t.Errorf("expected usage error, got: %d", code)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief token command for bailian command-line tool
+ * @version 1.0.0
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TokenInfo token create输出的Token信息
+type TokenInfo struct {
+	Token       string `json:"Token"`
+	ExpiredTime int64  `json:"ExpiredTime"`
+	ExpiresAt   string `json:"ExpiresAt"`
+}
+
+func (t TokenInfo) String() string {
+	return tea.Prettify(t)
+}
+
+func (t TokenInfo) GoString() string {
+	return t.String()
+}
+
+// Inspection token inspect输出的配置信息, 密钥脱敏显示
+type Inspection struct {
+	ProfileName     string                 `json:"ProfileName"`
+	AccessKeyId     string                 `json:"AccessKeyId"`
+	AccessKeySecret string                 `json:"AccessKeySecret"`
+	AgentKey        string                 `json:"AgentKey"`
+	AppId           string                 `json:"AppId"`
+	Endpoint        string                 `json:"Endpoint,omitempty"`
+	PopEndpoint     string                 `json:"PopEndpoint,omitempty"`
+	Token           string                 `json:"Token,omitempty"`
+	Claims          map[string]interface{} `json:"Claims,omitempty"`
+	ExpiresAt       string                 `json:"ExpiresAt,omitempty"`
+}
+
+func (i Inspection) String() string {
+	return tea.Prettify(i)
+}
+
+func (i Inspection) GoString() string {
+	return i.String()
+}
+
+func runToken(e *env, args []string) error {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "inspect") {
+		fmt.Fprint(e.stderr, "Usage: bailian token create|inspect [flags]\n")
+		return fmt.Errorf("expected subcommand create or inspect")
+	}
+
+	if args[0] == "create" {
+		return runTokenCreate(e, args[1:])
+	}
+	return runTokenInspect(e, args[1:])
+}
+
+func runTokenCreate(e *env, args []string) error {
+	fs := newFlagSet(e, "token create", "token create [flags]")
+	opts := addCommonFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := resolve(opts, e.getenv)
+	if err != nil {
+		return err
+	}
+
+	tokenClient, err := s.tokenClient()
+	if err != nil {
+		return err
+	}
+
+	data, err := tokenClient.CreateToken()
+	if err != nil {
+		return err
+	}
+
+	info := TokenInfo{Token: tea.StringValue(data.Token), ExpiredTime: tea.Int64Value(data.ExpiredTime)}
+	info.ExpiresAt = time.Unix(info.ExpiredTime, 0).Format(time.RFC3339)
+	if s.Output == OutputJSON {
+		return writeJSON(e.stdout, info, true)
+	}
+
+	//标准输出只输出Token, 便于export BAILIAN_TOKEN=$(bailian token create)
+	fmt.Fprintf(e.stderr, "expires at %s\n", info.ExpiresAt)
+	_, err = fmt.Fprintln(e.stdout, info.Token)
+	return err
+}
+
+// runTokenInspect 显示合并后的配置, Token为JWT格式时解析其中的声明
+func runTokenInspect(e *env, args []string) error {
+	fs := newFlagSet(e, "token inspect", "token inspect [flags] [token]")
+	opts := addCommonFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := resolve(opts, e.getenv)
+	if err != nil {
+		return err
+	}
+
+	inspection := Inspection{ProfileName: s.ProfileName, AccessKeyId: mask(s.AccessKeyId), AccessKeySecret: mask(s.AccessKeySecret),
+		AgentKey: s.AgentKey, AppId: s.AppId, Endpoint: s.Endpoint, PopEndpoint: s.PopEndpoint}
+
+	token := first(fs.Arg(0), s.Token)
+	if token != "" {
+		inspection.Token = mask(token)
+		inspection.Claims = parseClaims(token)
+		if exp, ok := inspection.Claims["exp"].(float64); ok {
+			inspection.ExpiresAt = time.Unix(int64(exp), 0).Format(time.RFC3339)
+		}
+	}
+
+	if s.Output == OutputJSON {
+		return writeJSON(e.stdout, inspection, true)
+	}
+
+	fields := [][2]string{
+		{"profile", inspection.ProfileName},
+		{"access key id", inspection.AccessKeyId},
+		{"access key secret", inspection.AccessKeySecret},
+		{"agent key", inspection.AgentKey},
+		{"app id", inspection.AppId},
+		{"endpoint", inspection.Endpoint},
+		{"pop endpoint", inspection.PopEndpoint},
+		{"token", inspection.Token},
+		{"expires at", inspection.ExpiresAt},
+	}
+	for _, field := range fields {
+		if field[1] != "" {
+			fmt.Fprintf(e.stdout, "%-18s %s\n", field[0]+":", field[1])
+		}
+	}
+
+	names := make([]string, 0, len(inspection.Claims))
+	for name := range inspection.Claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(e.stdout, "%-18s %v\n", "claim "+name+":", inspection.Claims[name])
+	}
+	return nil
+}
+
+// mask 只保留首尾各4个字符
+func mask(value string) string {
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:4] + strings.Repeat("*", len(value)-8) + value[len(value)-4:]
+}
+
+// parseClaims 解析JWT格式Token的payload, 其他格式返回nil
+func parseClaims(token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+
+	claims := make(map[string]interface{})
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
@@ -0,0 +1,45 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief shared file helpers
+ * @version 1.0.0
+ */
+
+package fileutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// WriteFileAtomic 先写同目录下的临时文件并同步到磁盘, 再重命名为path, 避免其他进程读取到写了一半的文件,
+// 也避免系统崩溃后留下空文件; 失败时删除临时文件
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, time.Now().UnixNano())
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for shared file helpers
+ * @version 1.0.0
+ */
+
+package fileutil_test
+
+import (
+	"errors"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/internal/fileutil"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	err := fileutil.WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "v1")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	//写入失败时保留原文件, 并删除临时文件
+	failed := errors.New("failed")
+	err = fileutil.WriteFileAtomic(path, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "partial")
+		return failed
+	})
+	if err != failed {
+		t.Errorf("expected write error, got: %v", err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	files, _ := ioutil.ReadDir(dir)
+	if string(data) != "v1" || len(files) != 1 {
+		t.Errorf("unexpected content: %s, files: %d", data, len(files))
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief flat key: value format for prompt definitions
+ * @version 1.0.0
+ */
+
+package prompt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseKeyValue 解析每行一个key: value的提示词文件. 语法借用了YAML的子集: #注释、单双引号字符串和|、|-、>、>-块字符串,
+// 但不是YAML, 嵌套映射、列表、流式集合和锚点均会报错
+func parseKeyValue(data string) (map[string]string, error) {
+	result := make(map[string]string)
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", i+1)
+		}
+
+		index := strings.Index(line, ":")
+		if index <= 0 {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+
+		key := strings.TrimSpace(line[:index])
+		value := strings.TrimSpace(line[index+1:])
+
+		if _, ok := result[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicated key %s", i+1, key)
+		}
+
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			block, next, err := parseBlockScalar(lines, i+1, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+
+			result[key] = block
+			i = next - 1
+			continue
+		}
+
+		scalar, err := parseScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		result[key] = scalar
+	}
+
+	return result, nil
+}
+
+func parseScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.Replace(value[1:end], "''", "'", -1), nil
+	case strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{"):
+		return "", fmt.Errorf("flow collections are not supported")
+	}
+
+	if index := strings.Index(value, " #"); index >= 0 {
+		value = strings.TrimSpace(value[:index])
+	}
+	return value, nil
+}
+
+// parseBlockScalar 解析从start行开始的块字符串, 返回内容和块之后的行号
+func parseBlockScalar(lines []string, start int, header string) (string, int, error) {
+	folded := header[0] == '>'
+	chomp := strings.TrimSpace(header[1:])
+	if index := strings.Index(chomp, "#"); index >= 0 {
+		chomp = strings.TrimSpace(chomp[:index])
+	}
+
+	if chomp != "" && chomp != "-" {
+		return "", 0, fmt.Errorf("unsupported block header %s", header)
+	}
+
+	indent := -1
+	var body []string
+	end := start
+	for ; end < len(lines); end++ {
+		line := lines[end]
+		if strings.TrimSpace(line) == "" {
+			body = append(body, "")
+			continue
+		}
+
+		current := len(line) - len(strings.TrimLeft(line, " "))
+		if indent < 0 {
+			if current == 0 {
+				break
+			}
+			indent = current
+		}
+
+		if current < indent {
+			break
+		}
+		body = append(body, line[indent:])
+	}
+
+	//块之后的空行不属于内容
+	for end > start && len(body) > 0 && body[len(body)-1] == "" {
+		body = body[:len(body)-1]
+		end--
+	}
+
+	var text string
+	if folded {
+		var paragraphs []string
+		var current []string
+		for _, line := range body {
+			if line == "" {
+				paragraphs = append(paragraphs, strings.Join(current, " "))
+				current = nil
+				continue
+			}
+			current = append(current, line)
+		}
+		paragraphs = append(paragraphs, strings.Join(current, " "))
+		text = strings.TrimRight(strings.Join(paragraphs, "\n"), "\n")
+	} else {
+		text = strings.TrimRight(strings.Join(body, "\n"), "\n")
+	}
+
+	if chomp == "" && text != "" {
+		text += "\n"
+	}
+
+	return text, end, nil
+}
@@ -0,0 +1,334 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief versioned prompt registry with weighted and sticky selection
+ * @version 1.0.0
+ */
+
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// MetadataPromptName 请求元数据中记录提示词名称的key
+	MetadataPromptName = "prompt_name"
+	// MetadataPromptVersion 请求元数据中记录提示词版本的key
+	MetadataPromptVersion = "prompt_version"
+
+	// PromptFileExt 提示词定义文件后缀. 文件每行一个key: value, 字段为name、version、weight、description和template,
+	// 支持#注释、引号字符串和|、>块字符串, 如:
+	//
+	//	name: greeting
+	//	version: v1
+	//	weight: 80
+	//	template: |
+	//	  你好, {{.name}}
+	PromptFileExt = ".prompt"
+)
+
+var (
+	ErrNoActiveVersion = errors.New("Prompt has no version with positive weight")
+	// ErrYAMLUnsupported 提示词文件不支持YAML格式, 需改为.prompt或.json文件
+	ErrYAMLUnsupported = errors.New("YAML prompt files are not supported, use .prompt or .json")
+)
+
+// PromptVersion 一个版本的提示词定义, 对应目录中的一个.prompt或.json文件
+type PromptVersion struct {
+	Name        string `json:"Name"`
+	Version     string `json:"Version"`
+	Weight      int    `json:"Weight"`
+	Description string `json:"Description,omitempty"`
+	// Template 模板文本, 格式同Engine.Parse
+	Template string `json:"Template"`
+
+	template *Template
+}
+
+func (v PromptVersion) String() string {
+	return tea.Prettify(v)
+}
+
+func (v PromptVersion) GoString() string {
+	return v.String()
+}
+
+// Apply 渲染到请求中, 并将提示词名称和版本写入请求元数据
+func (v *PromptVersion) Apply(request *client.CompletionRequest, vars map[string]interface{}) error {
+	if err := v.template.Apply(request, vars); err != nil {
+		return err
+	}
+
+	if request.Metadata == nil {
+		request.Metadata = make(map[string]string)
+	}
+	request.Metadata[MetadataPromptName] = v.Name
+	request.Metadata[MetadataPromptVersion] = v.Version
+	return nil
+}
+
+// Registry 管理多个版本的提示词, 按权重随机或按稳定key选择版本, 用于灰度发布和A/B测试, 并发安全
+type Registry struct {
+	// Logger 记录版本选择日志, 为nil时不记录
+	Logger *log.Logger
+
+	engine   *Engine
+	mutex    sync.RWMutex
+	versions map[string][]*PromptVersion
+}
+
+func NewRegistry() *Registry {
+	return &Registry{engine: NewEngine(), versions: make(map[string][]*PromptVersion)}
+}
+
+// Engine 返回注册表使用的模板引擎, 可用于添加公共片段
+func (r *Registry) Engine() *Engine {
+	return r.engine
+}
+
+// Register 注册一个版本, 同名同版本重复注册时返回错误
+func (r *Registry) Register(version *PromptVersion) error {
+	if version.Name == "" || version.Version == "" {
+		return fmt.Errorf("Prompt name and version are required")
+	}
+
+	if version.Weight < 0 {
+		return fmt.Errorf("Invalid weight %d of prompt %s@%s", version.Weight, version.Name, version.Version)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, existing := range r.versions[version.Name] {
+		if existing.Version == version.Version {
+			return fmt.Errorf("Duplicated prompt %s@%s", version.Name, version.Version)
+		}
+	}
+
+	tmpl, err := r.engine.Parse(version.Name+"@"+version.Version, version.Template)
+	if err != nil {
+		return err
+	}
+
+	version.template = tmpl
+	versions := append(r.versions[version.Name], version)
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i].Version, versions[j].Version) < 0
+	})
+	r.versions[version.Name] = versions
+	return nil
+}
+
+// LoadFS 加载匹配pattern的.prompt和.json提示词文件, 以下划线开头的.tmpl文件作为公共片段加载;
+// .prompt文件每行一个key: value, 格式见PromptFileExt. 不支持YAML, .yaml和.yml文件返回错误
+func (r *Registry) LoadFS(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return isPartial(files[i]) && !isPartial(files[j])
+	})
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		var version *PromptVersion
+		switch ext := strings.ToLower(path.Ext(file)); {
+		case ext == TemplateExt && isPartial(file):
+			name := strings.TrimPrefix(strings.TrimSuffix(path.Base(file), ext), "_")
+			err = r.engine.AddPartial(name, string(data))
+		case ext == ".json":
+			version = &PromptVersion{}
+			err = json.Unmarshal(data, version)
+		case ext == PromptFileExt:
+			version, err = parseVersionFile(string(data))
+		case ext == ".yaml" || ext == ".yml":
+			err = ErrYAMLUnsupported
+		default:
+			continue
+		}
+
+		if err == nil && version != nil {
+			err = r.Register(version)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to load prompt %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// LoadDir 加载目录下的所有提示词文件
+func (r *Registry) LoadDir(dir string) error {
+	return r.LoadFS(os.DirFS(dir), "*")
+}
+
+// Versions 返回提示词的所有版本, 按版本号排序, 数字部分按大小比较
+func (r *Registry) Versions(name string) []*PromptVersion {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]*PromptVersion(nil), r.versions[name]...)
+}
+
+// Get 返回指定版本
+func (r *Registry) Get(name string, version string) (*PromptVersion, error) {
+	for _, v := range r.Versions(name) {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s@%s", ErrTemplateNotFound, name, version)
+}
+
+// Select 按权重选择版本; stickyKey非空时相同key总是选择相同版本, 如用户ID, 否则随机选择
+func (r *Registry) Select(name string, stickyKey string) (*PromptVersion, error) {
+	versions := r.Versions(name)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	total := 0
+	for _, v := range versions {
+		total += v.Weight
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoActiveVersion, name)
+	}
+
+	var point int
+	if stickyKey != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(name + "\x00" + stickyKey))
+		point = int(hash.Sum32() % uint32(total))
+	} else {
+		point = rand.Intn(total)
+	}
+
+	for _, v := range versions {
+		if point < v.Weight {
+			return v, nil
+		}
+		point -= v.Weight
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Apply 选择版本并渲染到请求中, 返回选中的版本
+func (r *Registry) Apply(request *client.CompletionRequest, name string, stickyKey string, vars map[string]interface{}) (*PromptVersion, error) {
+	version, err := r.Select(name, stickyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = version.Apply(request, vars); err != nil {
+		return nil, err
+	}
+
+	if r.Logger != nil {
+		r.Logger.Printf("prompt selected, name: %s, version: %s, appId: %s, requestId: %s\n",
+			version.Name, version.Version, request.AppId, request.RequestId)
+	}
+	return version, nil
+}
+
+// compareVersions 按数字大小比较版本号中的数字部分, 如v9 < v10, 1.2.0 < 1.10.0, 其他部分按字符串比较
+func compareVersions(a string, b string) int {
+	for a != "" && b != "" {
+		partA, restA := nextVersionPart(a)
+		partB, restB := nextVersionPart(b)
+		a, b = restA, restB
+
+		numA, errA := strconv.ParseUint(partA, 10, 64)
+		numB, errB := strconv.ParseUint(partB, 10, 64)
+		if errA == nil && errB == nil {
+			if numA != numB {
+				if numA < numB {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+
+		if partA != partB {
+			return strings.Compare(partA, partB)
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// nextVersionPart 返回开头连续的数字或非数字部分
+func nextVersionPart(s string) (string, string) {
+	digit := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digit {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// VersionOf 返回生成该响应的提示词名称和版本, 未通过Registry渲染时返回空字符串
+func VersionOf(response *client.CompletionResponse) (string, string) {
+	if response == nil {
+		return "", ""
+	}
+	return response.Metadata[MetadataPromptName], response.Metadata[MetadataPromptVersion]
+}
+
+func parseVersionFile(data string) (*PromptVersion, error) {
+	values, err := parseKeyValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	version := &PromptVersion{}
+	for key, value := range values {
+		switch strings.ToLower(key) {
+		case "name":
+			version.Name = value
+		case "version":
+			version.Version = value
+		case "weight":
+			version.Weight, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight %q", value)
+			}
+		case "description":
+			version.Description = value
+		case "template":
+			version.Template = value
+		default:
+			return nil, fmt.Errorf("unknown field %s", key)
+		}
+	}
+	return version, nil
+}
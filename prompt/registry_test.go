@@ -0,0 +1,203 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for prompt registry
+ * @version 1.0.0
+ */
+
+package prompt_test
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/prompt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//go:embed testdata/registry/*
+var registryFiles embed.FS
+
+func loadRegistry(t *testing.T) *prompt.Registry {
+	registry := prompt.NewRegistry()
+	if err := registry.LoadFS(registryFiles, "testdata/registry/*"); err != nil {
+		t.Fatalf("failed to load registry: %v", err)
+	}
+	return registry
+}
+
+func TestRegistryLoad(t *testing.T) {
+	registry := loadRegistry(t)
+
+	versions := registry.Versions("greeting")
+	if len(versions) != 3 {
+		t.Fatalf("unexpected versions: %v", versions)
+	}
+
+	v0, v1, v2 := versions[0], versions[1], versions[2]
+	if v0.Version != "v0" || v0.Weight != 0 || v0.Template != "已下线的版本" {
+		t.Errorf("unexpected v0: %s", v0)
+	}
+
+	if v1.Version != "v1" || v1.Weight != 80 || v1.Description != "初始版本" || !strings.HasSuffix(v1.Template, "{{.question}}\n") {
+		t.Errorf("unexpected v1: %s", v1)
+	}
+
+	if v2.Version != "v2" || v2.Weight != 20 || v2.Description != "回答更简洁" {
+		t.Errorf("unexpected v2: %s", v2)
+	}
+
+	if _, err := registry.Get("greeting", "v3"); !errors.Is(err, prompt.ErrTemplateNotFound) {
+		t.Errorf("expected not found, got: %v", err)
+	}
+
+	err := registry.Register(&prompt.PromptVersion{Name: "greeting", Version: "v1", Weight: 1, Template: "hi"})
+	if err == nil {
+		t.Errorf("expected duplicated version error")
+	}
+
+	//数字部分按大小排序, v10在v9之后
+	for _, v := range []string{"v10", "v9", "v1.10", "v1.2"} {
+		if err = registry.Register(&prompt.PromptVersion{Name: "ordered", Version: v, Weight: 1, Template: "hi"}); err != nil {
+			t.Fatalf("failed to register %s: %v", v, err)
+		}
+	}
+
+	var order []string
+	for _, v := range registry.Versions("ordered") {
+		order = append(order, v.Version)
+	}
+	if strings.Join(order, ",") != "v1.2,v1.10,v9,v10" {
+		t.Errorf("unexpected version order: %v", order)
+	}
+}
+
+func TestRegistrySelect(t *testing.T) {
+	registry := loadRegistry(t)
+
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		version, err := registry.Select("greeting", key)
+		if err != nil {
+			t.Fatalf("failed to select version: %v", err)
+		}
+
+		again, _ := registry.Select("greeting", key)
+		if again != version {
+			t.Fatalf("sticky key %s selected %s and %s", key, version.Version, again.Version)
+		}
+		counts[version.Version]++
+	}
+
+	if counts["v0"] != 0 {
+		t.Errorf("inactive version selected %d times", counts["v0"])
+	}
+
+	if counts["v1"] < 1400 || counts["v1"] > 1800 || counts["v2"] < 200 || counts["v2"] > 600 {
+		t.Errorf("unexpected distribution: %v", counts)
+	}
+
+	counts = make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		version, _ := registry.Select("greeting", "")
+		counts[version.Version]++
+	}
+
+	if counts["v0"] != 0 || counts["v1"] < 1400 || counts["v2"] < 200 {
+		t.Errorf("unexpected random distribution: %v", counts)
+	}
+
+	_ = registry.Register(&prompt.PromptVersion{Name: "retired", Version: "v1", Template: "hi"})
+	if _, err := registry.Select("retired", "user"); !errors.Is(err, prompt.ErrNoActiveVersion) {
+		t.Errorf("expected no active version, got: %v", err)
+	}
+}
+
+func TestRegistryApply(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := &client.CompletionRequest{}
+		_ = json.NewDecoder(r.Body).Decode(request)
+
+		_ = json.NewEncoder(w).Encode(&client.CompletionResponse{
+			Success: true,
+			Data:    &client.CompletionResponseData{Text: request.Messages[0].Content},
+		})
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	registry := loadRegistry(t)
+	registry.Logger = log.New(&logs, "", 0)
+
+	request := &client.CompletionRequest{AppId: "app", RequestId: "r1"}
+	version, err := registry.Apply(request, "greeting", "user-1", map[string]interface{}{
+		"company":  "百炼",
+		"question": "你好",
+	})
+	if err != nil {
+		t.Fatalf("failed to apply prompt: %v", err)
+	}
+
+	if request.Metadata[prompt.MetadataPromptVersion] != version.Version || len(request.Messages) != 2 {
+		t.Fatalf("unexpected request: %s, metadata: %v", request, request.Metadata)
+	}
+
+	expected := "prompt selected, name: greeting, version: " + version.Version + ", appId: app, requestId: r1\n"
+	if logs.String() != expected {
+		t.Errorf("unexpected log: %q", logs.String())
+	}
+
+	cc := &client.CompletionClient{Token: "token", Endpoint: server.URL}
+	response, err := cc.CreateCompletion(request)
+	if err != nil {
+		t.Fatalf("failed to create completion: %v", err)
+	}
+
+	if !strings.HasPrefix(response.OutputText(), "你是百炼的客服助手") {
+		t.Errorf("unexpected output: %s", response.OutputText())
+	}
+
+	name, v := prompt.VersionOf(response)
+	if name != "greeting" || v != version.Version {
+		t.Errorf("unexpected version of response: %s@%s", name, v)
+	}
+}
+
+func TestRegistryInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	registry := prompt.NewRegistry()
+
+	content := "name: bad\nversion: v1\nparams:\n  temperature: 0.5\n"
+	if err := os.WriteFile(filepath.Join(dir, "bad.prompt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.LoadDir(dir); err == nil || !strings.Contains(err.Error(), "bad.prompt") {
+		t.Errorf("expected nested value error, got: %v", err)
+	}
+}
+
+func TestRegistryYAMLUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	registry := prompt.NewRegistry()
+
+	if err := os.WriteFile(filepath.Join(dir, "greeting.yaml"), []byte("name: greeting\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.LoadDir(dir); !errors.Is(err, prompt.ErrYAMLUnsupported) {
+		t.Errorf("expected yaml unsupported error, got: %v", err)
+	}
+}
@@ -0,0 +1,424 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief prompt templates rendered into completion requests
+ * @version 1.0.0
+ */
+
+package prompt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const (
+	// TemplateExt 模板文件后缀, 以下划线开头的模板文件作为公共片段加载
+	TemplateExt = ".tmpl"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("Prompt template not found")
+
+	// roleHeader 多消息模板中的角色分隔行, 如: --- system ---
+	roleHeader = regexp.MustCompile(`^-{3,}\s*(\w+)\s*-{3,}\s*$`)
+	// variableDecl 变量声明, 如: {{/* @var city string required */}}
+	variableDecl = regexp.MustCompile(`^\{\{/\*\s*@var\s+(\w+)\s+(\w+)((?:\s+\w+)*)\s*\*/\}\}\s*$`)
+
+	userInputReplacer = strings.NewReplacer("<|", "<\\|", "|>", "|\\>", "```", "'''")
+)
+
+// VariableType 模板变量类型
+type VariableType string
+
+const (
+	TypeString VariableType = "string"
+	TypeInt    VariableType = "int"
+	TypeFloat  VariableType = "float"
+	TypeBool   VariableType = "bool"
+	TypeList   VariableType = "list"
+	TypeAny    VariableType = "any"
+)
+
+// Variable 模板变量声明, 渲染前校验必填和类型; Escape为true时字符串值按用户输入转义
+type Variable struct {
+	Name     string       `json:"Name"`
+	Type     VariableType `json:"Type,omitempty"`
+	Required bool         `json:"Required,omitempty"`
+	Escape   bool         `json:"Escape,omitempty"`
+	Default  interface{}  `json:"Default,omitempty"`
+}
+
+// MessageTemplate 单条消息的模板
+type MessageTemplate struct {
+	Role client.Role `json:"Role"`
+	Text string      `json:"Text"`
+}
+
+// Template 编译后的提示词模板. 只有一条未指定角色的消息时为Prompt模板, 否则为多消息的对话模板
+type Template struct {
+	Name      string
+	Messages  []MessageTemplate
+	Variables []Variable
+
+	chat     bool
+	compiled []*template.Template
+}
+
+// EscapeUserInput 转义用户输入中的对话标记和代码块分隔符, 避免用户输入伪造消息边界
+func EscapeUserInput(s string) string {
+	return userInputReplacer.Replace(s)
+}
+
+// Funcs 模板中可用的函数
+var Funcs = template.FuncMap{
+	"escape": EscapeUserInput,
+	"join":   strings.Join,
+	"trim":   strings.TrimSpace,
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+}
+
+// Engine 管理模板与公共片段, 模板中可通过{{template "name" .}}引用公共片段, 并发安全
+type Engine struct {
+	mutex     sync.RWMutex
+	partials  *template.Template
+	templates map[string]*Template
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		partials:  template.New("").Funcs(Funcs).Option("missingkey=error"),
+		templates: make(map[string]*Template),
+	}
+}
+
+// AddPartial 添加公共片段, 需在引用它的模板之前添加
+func (e *Engine) AddPartial(name string, text string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, err := e.partials.New(name).Parse(text)
+	if err != nil {
+		return fmt.Errorf("Failed to parse partial %s: %v", name, err)
+	}
+	return nil
+}
+
+// Add 添加模板, messages只有一条且未指定角色时为Prompt模板
+func (e *Engine) Add(name string, messages []MessageTemplate, variables ...Variable) (*Template, error) {
+	chat := len(messages) != 1 || messages[0].Role != ""
+	return e.add(name, chat, messages, variables)
+}
+
+// Parse 解析模板文本. 以"--- role ---"分隔行划分多条消息, 没有分隔行时为Prompt模板;
+// 开头的{{/* @var name type [required] [escape] */}}行声明变量
+func (e *Engine) Parse(name string, text string) (*Template, error) {
+	var variables []Variable
+	var messages []MessageTemplate
+	var body []string
+	chat := false
+	role := client.Role("")
+
+	flush := func() {
+		if chat || len(body) > 0 {
+			messages = append(messages, MessageTemplate{Role: role, Text: strings.TrimSpace(strings.Join(body, "\n"))})
+		}
+		body = nil
+	}
+
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		if matches := variableDecl.FindStringSubmatch(line); matches != nil {
+			variable := Variable{Name: matches[1], Type: VariableType(matches[2])}
+			for _, flag := range strings.Fields(matches[3]) {
+				switch flag {
+				case "required":
+					variable.Required = true
+				case "escape":
+					variable.Escape = true
+				default:
+					return nil, fmt.Errorf("Unknown variable flag %q in %s line %d", flag, name, i+1)
+				}
+			}
+			variables = append(variables, variable)
+			continue
+		}
+
+		if matches := roleHeader.FindStringSubmatch(line); matches != nil {
+			flush()
+			chat = true
+			role = client.Role(strings.ToLower(matches[1]))
+			continue
+		}
+
+		body = append(body, line)
+	}
+	flush()
+
+	if !chat && len(messages) == 0 {
+		messages = []MessageTemplate{{}}
+	} else if chat && len(messages) > 0 && messages[0].Role == "" {
+		//第一个分隔行之前的内容只能是空白
+		if messages[0].Text != "" {
+			return nil, fmt.Errorf("Template %s has content before first role header", name)
+		}
+		messages = messages[1:]
+	}
+
+	return e.add(name, chat, messages, variables)
+}
+
+// LoadFS 从文件系统加载匹配pattern的模板文件, 如embed.FS; 模板名为去掉后缀的文件名
+func (e *Engine) LoadFS(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+
+	//先加载公共片段, 模板解析时需要引用
+	sort.SliceStable(files, func(i, j int) bool {
+		return isPartial(files[i]) && !isPartial(files[j])
+	})
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+		if isPartial(file) {
+			err = e.AddPartial(strings.TrimPrefix(name, "_"), string(data))
+		} else {
+			_, err = e.Parse(name, string(data))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadDir 加载目录下所有.tmpl模板文件
+func (e *Engine) LoadDir(dir string) error {
+	return e.LoadFS(os.DirFS(dir), "*"+TemplateExt)
+}
+
+func (e *Engine) Get(name string) (*Template, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	t, ok := e.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return t, nil
+}
+
+// Names 返回所有模板名, 按字母排序
+func (e *Engine) Names() []string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	names := make([]string, 0, len(e.templates))
+	for name := range e.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render 渲染指定模板为消息列表
+func (e *Engine) Render(name string, vars map[string]interface{}) ([]client.ChatCompletionMessage, error) {
+	t, err := e.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return t.Render(vars)
+}
+
+// Apply 渲染指定模板到请求中
+func (e *Engine) Apply(request *client.CompletionRequest, name string, vars map[string]interface{}) error {
+	t, err := e.Get(name)
+	if err != nil {
+		return err
+	}
+	return t.Apply(request, vars)
+}
+
+func (e *Engine) add(name string, chat bool, messages []MessageTemplate, variables []Variable) (*Template, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	t := &Template{
+		Name:      name,
+		Messages:  messages,
+		Variables: variables,
+		chat:      chat,
+	}
+
+	for _, variable := range variables {
+		switch variable.Type {
+		case "", TypeString, TypeInt, TypeFloat, TypeBool, TypeList, TypeAny:
+		default:
+			return nil, fmt.Errorf("Unknown type %q of variable %s in template %s", variable.Type, variable.Name, name)
+		}
+	}
+
+	for i, message := range messages {
+		if chat && !message.Role.IsKnown() {
+			return nil, fmt.Errorf("Unknown role %q in template %s", message.Role, name)
+		}
+
+		//每个模板克隆一份公共片段, 之后新增的片段不影响已编译的模板
+		set, err := e.partials.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		compiled, err := set.New(fmt.Sprintf("%s#%d", name, i)).Parse(message.Text)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse template %s: %v", name, err)
+		}
+		t.compiled = append(t.compiled, compiled)
+	}
+
+	e.templates[name] = t
+	return t, nil
+}
+
+func isPartial(file string) bool {
+	return strings.HasPrefix(path.Base(file), "_")
+}
+
+// IsChat 是否为多消息的对话模板
+func (t *Template) IsChat() bool {
+	return t.chat
+}
+
+// Render 校验变量并渲染为消息列表, Prompt模板渲染为一条用户消息; 引用未提供的变量时返回错误
+func (t *Template) Render(vars map[string]interface{}) ([]client.ChatCompletionMessage, error) {
+	data, err := t.prepare(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]client.ChatCompletionMessage, 0, len(t.compiled))
+	for i, compiled := range t.compiled {
+		var buffer bytes.Buffer
+		if err = compiled.Execute(&buffer, data); err != nil {
+			return nil, fmt.Errorf("Failed to render template %s: %v", t.Name, err)
+		}
+
+		role := t.Messages[i].Role
+		if role == "" {
+			role = client.RoleUser
+		}
+		messages = append(messages, client.ChatCompletionMessage{Role: role, Content: strings.TrimSpace(buffer.String())})
+	}
+	return messages, nil
+}
+
+// RenderText 渲染并以空行连接所有消息内容
+func (t *Template) RenderText(vars map[string]interface{}) (string, error) {
+	messages, err := t.Render(vars)
+	if err != nil {
+		return "", err
+	}
+
+	contents := make([]string, 0, len(messages))
+	for _, message := range messages {
+		contents = append(contents, message.Content)
+	}
+	return strings.Join(contents, "\n\n"), nil
+}
+
+// Apply 渲染到请求中: Prompt模板设置Prompt, 对话模板追加到Messages
+func (t *Template) Apply(request *client.CompletionRequest, vars map[string]interface{}) error {
+	messages, err := t.Render(vars)
+	if err != nil {
+		return err
+	}
+
+	if t.chat {
+		request.Messages = append(request.Messages, messages...)
+	} else {
+		request.Prompt = messages[0].Content
+	}
+	return nil
+}
+
+// prepare 补充默认值, 校验必填变量和类型, 并转义需要转义的字符串
+func (t *Template) prepare(vars map[string]interface{}) (map[string]interface{}, error) {
+	data := make(map[string]interface{}, len(vars)+len(t.Variables))
+	for key, value := range vars {
+		data[key] = value
+	}
+
+	for _, variable := range t.Variables {
+		value, ok := data[variable.Name]
+		if !ok || value == nil {
+			if variable.Default != nil {
+				data[variable.Name] = variable.Default
+				continue
+			}
+
+			if variable.Required {
+				return nil, fmt.Errorf("Missing required variable %s of template %s", variable.Name, t.Name)
+			}
+
+			//已声明的可选变量置为nil, 模板中可以用if判断, 不会触发缺失变量错误
+			data[variable.Name] = nil
+			continue
+		}
+
+		if err := checkType(variable, value); err != nil {
+			return nil, fmt.Errorf("Invalid variable of template %s: %v", t.Name, err)
+		}
+
+		if s, ok := value.(string); ok && variable.Escape {
+			data[variable.Name] = EscapeUserInput(s)
+		}
+	}
+	return data, nil
+}
+
+func checkType(variable Variable, value interface{}) error {
+	kind := reflect.TypeOf(value).Kind()
+	valid := true
+
+	switch variable.Type {
+	case TypeString, "":
+		valid = kind == reflect.String
+	case TypeInt:
+		//JSON解析得到的数字为float64, 整数值也视为合法
+		f, isFloat := value.(float64)
+		valid = (kind >= reflect.Int && kind <= reflect.Uint64) || (isFloat && f == float64(int64(f)))
+	case TypeFloat:
+		valid = kind == reflect.Float32 || kind == reflect.Float64 || (kind >= reflect.Int && kind <= reflect.Uint64)
+	case TypeBool:
+		valid = kind == reflect.Bool
+	case TypeList:
+		valid = kind == reflect.Slice || kind == reflect.Array
+	}
+
+	if !valid {
+		return fmt.Errorf("variable %s should be %s, got %T", variable.Name, variable.Type, value)
+	}
+	return nil
+}
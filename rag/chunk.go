@@ -0,0 +1,365 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief markdown-aware document chunking by tokens and sentences
+ * @version 1.0.0
+ */
+
+package rag
+
+import (
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultChunkTokens  = 500
+	DefaultChunkOverlap = 50
+	// headingSeparator 多级标题的连接符
+	headingSeparator = " > "
+)
+
+// Document 待索引的文档
+type Document struct {
+	Id    string `json:"Id"`
+	Title string `json:"Title,omitempty"`
+	Url   string `json:"Url,omitempty"`
+	Text  string `json:"Text"`
+	// Metadata 复制到每个分块, 可用于检索时过滤; 以_开头的键保留给分块字段
+	Metadata map[string]string `json:"Metadata,omitempty"`
+}
+
+func (d Document) String() string {
+	return tea.Prettify(d)
+}
+
+func (d Document) GoString() string {
+	return d.String()
+}
+
+// Chunk 文档分块
+type Chunk struct {
+	Id    string `json:"Id"`
+	DocId string `json:"DocId"`
+	Title string `json:"Title,omitempty"`
+	Url   string `json:"Url,omitempty"`
+	// Heading 分块所在的Markdown标题路径, 如"安装 > 配置"
+	Heading  string            `json:"Heading,omitempty"`
+	Text     string            `json:"Text"`
+	Index    int               `json:"Index"`
+	Metadata map[string]string `json:"Metadata,omitempty"`
+}
+
+func (c Chunk) String() string {
+	return tea.Prettify(c)
+}
+
+func (c Chunk) GoString() string {
+	return c.String()
+}
+
+// EmbeddingText 向量化使用的文本, 包含标题路径以便检索到上下文
+func (c *Chunk) EmbeddingText() string {
+	if c.Heading == "" {
+		return c.Text
+	}
+	return c.Heading + "\n" + c.Text
+}
+
+// Chunker 按句子切分文档并合并为不超过MaxTokens的分块, 相邻分块重叠约Overlap个token.
+// Markdown为true时按标题分节, 分块不跨节, 代码块不在内部按句子切分
+type Chunker struct {
+	// MaxTokens 每个分块的最大token数, 包含标题路径, 为0时使用DefaultChunkTokens
+	MaxTokens int
+	// Overlap 相邻分块重叠的token数, 为0时使用DefaultChunkOverlap, 小于0时不重叠
+	Overlap   int
+	Markdown  bool
+	Estimator client.TokenEstimator
+}
+
+// section Markdown的一节, heading为标题路径
+type section struct {
+	heading string
+	text    string
+}
+
+// Split 切分文档, 分块Id为"文档Id#序号"
+func (c *Chunker) Split(doc Document) []Chunk {
+	sections := []section{{text: doc.Text}}
+	if c.Markdown {
+		sections = splitSections(doc.Text)
+	}
+
+	var chunks []Chunk
+	for _, s := range sections {
+		budget := c.maxTokens()
+		if s.heading != "" {
+			budget -= c.estimate(s.heading + "\n")
+		}
+
+		if budget < 1 {
+			budget = 1
+		}
+
+		for _, text := range c.pack(splitUnits(s.text, c.Markdown), budget) {
+			chunk := Chunk{
+				Id:       fmt.Sprintf("%s#%d", doc.Id, len(chunks)),
+				DocId:    doc.Id,
+				Title:    doc.Title,
+				Url:      doc.Url,
+				Heading:  s.heading,
+				Text:     text,
+				Index:    len(chunks),
+				Metadata: doc.Metadata,
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+func (c *Chunker) maxTokens() int {
+	if c.MaxTokens <= 0 {
+		return DefaultChunkTokens
+	}
+	return c.MaxTokens
+}
+
+func (c *Chunker) overlap() int {
+	if c.Overlap == 0 {
+		return DefaultChunkOverlap
+	}
+	return c.Overlap
+}
+
+func (c *Chunker) estimate(text string) int {
+	if c.Estimator == nil {
+		return client.SimpleTokenEstimator{}.EstimateTokens(text)
+	}
+	return c.Estimator.EstimateTokens(text)
+}
+
+// pack 将句子合并为分块, 超长的句子按字符切开
+func (c *Chunker) pack(units []string, budget int) []string {
+	var pieces []string
+	for _, unit := range units {
+		pieces = append(pieces, c.splitLong(unit, budget)...)
+	}
+
+	tokens := make([]int, len(pieces))
+	for i, piece := range pieces {
+		tokens[i] = c.estimate(piece)
+	}
+
+	var chunks []string
+	for start := 0; start < len(pieces); {
+		end, total := start, 0
+		for end < len(pieces) && (end == start || total+tokens[end] <= budget) {
+			total += tokens[end]
+			end++
+		}
+
+		if text := strings.TrimSpace(strings.Join(pieces[start:end], "")); text != "" {
+			chunks = append(chunks, text)
+		}
+
+		if end >= len(pieces) {
+			break
+		}
+
+		//从当前分块末尾回退若干句作为下一分块的开头, 保证至少前进一句, 且下一分块能容纳新的句子
+		next, overlap := end, 0
+		for next-1 > start && overlap+tokens[next-1] <= c.overlap() && overlap+tokens[next-1]+tokens[end] <= budget {
+			next--
+			overlap += tokens[next]
+		}
+		start = next
+	}
+	return chunks
+}
+
+// splitLong 按字符将超过budget的文本切开. 先按整段的平均token密度估算每段的字符数,
+// 再从估算值开始倍增或减半确定区间后二分查找, 每次只测量一段的长度, 避免长文本重复测量整个前缀
+func (c *Chunker) splitLong(text string, budget int) []string {
+	total := c.estimate(text)
+	if total <= budget {
+		return []string{text}
+	}
+
+	var pieces []string
+	runes := []rune(text)
+	guess := len(runes) * budget / total
+	for len(runes) > 0 {
+		//low为能容纳的最大字符数, high为已知不能容纳的最小字符数
+		low, high := 0, len(runes)+1
+		for n := minInt(maxInt(guess, 1), len(runes)); low+1 < high; {
+			if c.estimate(string(runes[:n])) <= budget {
+				low = n
+			} else {
+				high = n
+			}
+
+			switch {
+			case high > len(runes):
+				n = minInt(2*low, len(runes))
+			case low == 0:
+				n = high / 2
+			default:
+				n = (low + high) / 2
+			}
+		}
+
+		//单个字符超过budget时仍然单独成段
+		if low == 0 {
+			low = 1
+		}
+		pieces = append(pieces, string(runes[:low]))
+		runes, guess = runes[low:], low
+	}
+	return pieces
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// splitSections 按Markdown标题分节, 代码块中的#不视为标题
+func splitSections(text string) []section {
+	var sections []section
+	var headings []string
+	var builder strings.Builder
+	heading, fenced := "", false
+
+	flush := func() {
+		if strings.TrimSpace(builder.String()) != "" {
+			sections = append(sections, section{heading: heading, text: builder.String()})
+		}
+		builder.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+
+		level := headingLevel(trimmed)
+		if fenced || level == 0 {
+			builder.WriteString(line)
+			continue
+		}
+
+		flush()
+		if level > len(headings) {
+			for len(headings) < level-1 {
+				headings = append(headings, "")
+			}
+			headings = append(headings, "")
+		}
+		headings = headings[:level]
+		headings[level-1] = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+
+		var path []string
+		for _, h := range headings {
+			if h != "" {
+				path = append(path, h)
+			}
+		}
+		heading = strings.Join(path, headingSeparator)
+	}
+	flush()
+	return sections
+}
+
+// headingLevel ATX标题的级别, 不是标题时返回0
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ' && line[level] != '\t') {
+		return 0
+	}
+	return level
+}
+
+// splitUnits 切分为句子, 保留原文中的空白; markdown为true时代码块作为一个整体
+func splitUnits(text string, markdown bool) []string {
+	var units []string
+	var builder strings.Builder
+	flush := func() {
+		if builder.Len() > 0 {
+			units = append(units, builder.String())
+			builder.Reset()
+		}
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		if markdown && isFence(lines[i]) {
+			flush()
+			builder.WriteString(lines[i])
+			for i++; i < len(lines); i++ {
+				builder.WriteString(lines[i])
+				if isFence(lines[i]) {
+					break
+				}
+			}
+			flush()
+			continue
+		}
+
+		runes := []rune(lines[i])
+		for j := 0; j < len(runes); j++ {
+			builder.WriteRune(runes[j])
+			if !isSentenceEnd(runes, j) {
+				continue
+			}
+
+			//句末的引号、括号和空白归入当前句子
+			for j+1 < len(runes) && (isClosing(runes[j+1]) || unicode.IsSpace(runes[j+1])) {
+				j++
+				builder.WriteRune(runes[j])
+			}
+			flush()
+		}
+	}
+	flush()
+	return units
+}
+
+func isFence(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
+
+func isSentenceEnd(runes []rune, i int) bool {
+	switch runes[i] {
+	case '。', '！', '？', '；', '!', '?', '\n':
+		return true
+	case '.', ';':
+		//英文句号后需为空白, 避免切开小数和缩写
+		return i+1 == len(runes) || unicode.IsSpace(runes[i+1])
+	}
+	return false
+}
+
+func isClosing(r rune) bool {
+	return strings.ContainsRune("”’」』)）]】\"'", r)
+}
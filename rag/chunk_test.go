@@ -0,0 +1,93 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for document chunking
+ * @version 1.0.0
+ */
+
+package rag_test
+
+import (
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/rag"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// runeEstimator 每个字符按1个token计算, 便于断言
+type runeEstimator struct{}
+
+func (runeEstimator) EstimateTokens(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+func TestChunkerSentences(t *testing.T) {
+	chunker := &rag.Chunker{MaxTokens: 12, Overlap: 5, Estimator: runeEstimator{}}
+	chunks := chunker.Split(rag.Document{Id: "d", Text: "一二三四。五六七八！九十。甲乙丙丁戊己庚辛壬癸子丑寅卯。"})
+
+	var texts []string
+	for _, chunk := range chunks {
+		texts = append(texts, chunk.Text)
+	}
+
+	//相邻分块重叠一句, 超长的句子按字符切开
+	expected := "一二三四。五六七八！|五六七八！九十。|甲乙丙丁戊己庚辛壬癸子丑|寅卯。"
+	if joined := strings.Join(texts, "|"); joined != expected {
+		t.Errorf("unexpected chunks: %s", joined)
+	}
+
+	if chunks[1].Id != "d#1" || chunks[1].Index != 1 || chunks[1].DocId != "d" {
+		t.Errorf("unexpected chunk: %s", chunks[1])
+	}
+}
+
+func TestChunkerMarkdown(t *testing.T) {
+	text := "# 安装\n执行以下命令。\n\n```bash\n# 不是标题\ngo get sdk\n```\n## 配置\n设置AccessKey。\n# 使用\n调用接口。\n"
+	chunker := &rag.Chunker{Markdown: true, Estimator: runeEstimator{}}
+	chunks := chunker.Split(rag.Document{Id: "readme", Title: "README", Text: text})
+
+	if len(chunks) != 3 {
+		t.Fatalf("unexpected chunks: %v", chunks)
+	}
+
+	if chunks[0].Heading != "安装" || !strings.Contains(chunks[0].Text, "# 不是标题\ngo get sdk\n```") {
+		t.Errorf("unexpected first chunk: %s", chunks[0])
+	}
+
+	if chunks[1].Heading != "安装 > 配置" || chunks[1].EmbeddingText() != "安装 > 配置\n设置AccessKey。" {
+		t.Errorf("unexpected second chunk: %s", chunks[1])
+	}
+
+	if chunks[2].Heading != "使用" || chunks[2].Text != "调用接口。" {
+		t.Errorf("unexpected third chunk: %s", chunks[2])
+	}
+}
+
+// countingEstimator 记录测量过的字符总数
+type countingEstimator struct {
+	measured int
+}
+
+func (e *countingEstimator) EstimateTokens(text string) int {
+	n := utf8.RuneCountInString(text)
+	e.measured += n
+	return n
+}
+
+func TestChunkerLongLine(t *testing.T) {
+	text := strings.Repeat("长", 100000)
+	estimator := &countingEstimator{}
+	chunker := &rag.Chunker{MaxTokens: 100, Overlap: -1, Estimator: estimator}
+	chunks := chunker.Split(rag.Document{Id: "d", Text: text})
+
+	if len(chunks) != 1000 || chunks[0].Text != strings.Repeat("长", 100) {
+		t.Fatalf("unexpected chunks: %d", len(chunks))
+	}
+
+	//每段只测量估算长度附近的前缀, 测量的字符数与文本长度成正比
+	if estimator.measured > 20*len([]rune(text)) {
+		t.Errorf("too many measured runes: %d", estimator.measured)
+	}
+}
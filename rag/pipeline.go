@@ -0,0 +1,345 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief client-side retrieval augmented generation pipeline
+ * @version 1.0.0
+ */
+
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alibabacloud-go/tea/tea"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/vectorindex"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultTopK = 4
+	// DefaultPrompt 参考资料说明, %s替换为编号后的分块, 编号与DocReferences的IndexId一致
+	DefaultPrompt = "请根据以下参考资料回答用户的问题, 参考资料中没有相关信息时请直接说明。" +
+		"引用参考资料时在句末使用<ref>[编号]</ref>标注。\n\n参考资料:\n%s"
+)
+
+// 分块字段保存在向量索引的元数据中, 索引持久化后可直接还原分块
+const (
+	metadataDocId   = "_doc_id"
+	metadataTitle   = "_title"
+	metadataUrl     = "_url"
+	metadataHeading = "_heading"
+	metadataText    = "_text"
+	metadataIndex   = "_index"
+)
+
+var ErrNoQuestion = errors.New("Request has no question")
+
+// Retrieval 检索到的分块
+type Retrieval struct {
+	Chunk Chunk   `json:"Chunk"`
+	Score float64 `json:"Score"`
+}
+
+func (r Retrieval) String() string {
+	return tea.Prettify(r)
+}
+
+func (r Retrieval) GoString() string {
+	return r.String()
+}
+
+// Answer 生成结果, References按编号与回答中的引用标注对应
+type Answer struct {
+	Response   *client.CompletionResponse                  `json:"Response"`
+	Chunks     []Retrieval                                 `json:"Chunks,omitempty"`
+	References []client.CompletionResponseDataDocReference `json:"References,omitempty"`
+}
+
+func (a Answer) String() string {
+	return tea.Prettify(a)
+}
+
+func (a Answer) GoString() string {
+	return a.String()
+}
+
+// Text 回答文本
+func (a *Answer) Text() string {
+	if a.Response == nil {
+		return ""
+	}
+	return a.Response.OutputText()
+}
+
+// Pipeline 在本地切分、向量化和检索文档, 将检索结果注入Messages后调用CompletionClient生成回答
+type Pipeline struct {
+	Client *client.CompletionClient
+	// Embedder 文档向量化, 大量文档时可使用BulkEmbedder
+	Embedder client.Embedder
+	// QueryEmbedder 问题向量化, 为nil时使用Embedder
+	QueryEmbedder client.Embedder
+	// Index 为nil时创建余弦相似度的精确检索索引
+	Index   *vectorindex.Index
+	Chunker *Chunker
+	// TopK 检索的分块数, 为0时使用DefaultTopK
+	TopK int
+	// MinScore 低于该相似度的分块被丢弃
+	MinScore float64
+	// Prompt 参考资料说明, %s替换为编号后的分块, 为空时使用DefaultPrompt
+	Prompt string
+	// Role 参考资料消息的角色, 默认为system, 与已有的system消息合并
+	Role client.Role
+
+	mutex sync.Mutex
+}
+
+func (p *Pipeline) index() *vectorindex.Index {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.Index == nil {
+		p.Index = vectorindex.New(vectorindex.Cosine)
+	}
+	return p.Index
+}
+
+// AddDocuments 切分文档并写入索引, 返回生成的分块; 文档已存在时替换其全部分块
+func (p *Pipeline) AddDocuments(docs ...Document) ([]Chunk, error) {
+	chunker := p.Chunker
+	if chunker == nil {
+		chunker = &Chunker{}
+	}
+
+	var chunks []Chunk
+	counts := make(map[string]int, len(docs))
+	for _, doc := range docs {
+		split := chunker.Split(doc)
+		counts[doc.Id] = len(split)
+		chunks = append(chunks, split...)
+	}
+
+	if len(chunks) == 0 {
+		deleteStaleChunks(p.index(), counts)
+		return nil, nil
+	}
+
+	texts := make([]string, len(chunks))
+	for i := range chunks {
+		texts[i] = chunks[i].EmbeddingText()
+	}
+
+	result, err := p.Embedder.Embed(texts)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(chunks))
+	metadata := make([]map[string]string, len(chunks))
+	for i := range chunks {
+		ids[i], metadata[i] = chunks[i].Id, chunkMetadata(&chunks[i])
+	}
+
+	index := p.index()
+	deleteStaleChunks(index, counts)
+	if err = index.AddEmbeddings(ids, result.Embeddings, metadata); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// deleteStaleChunks 文档重新切分后分块数变少时, 删除序号不小于新分块数的旧分块; 分块序号从0开始连续
+func deleteStaleChunks(index *vectorindex.Index, counts map[string]int) {
+	for docId, count := range counts {
+		for n := count; ; n++ {
+			id := fmt.Sprintf("%s#%d", docId, n)
+			if _, ok := index.Get(id); !ok {
+				break
+			}
+			index.Delete(id)
+		}
+	}
+}
+
+// Retrieve 检索与query最相关的分块, k为0时使用TopK
+func (p *Pipeline) Retrieve(query string, k int, filter vectorindex.Filter) ([]Retrieval, error) {
+	if k <= 0 {
+		k = p.TopK
+	}
+
+	if k <= 0 {
+		k = DefaultTopK
+	}
+
+	embedder := p.QueryEmbedder
+	if embedder == nil {
+		embedder = p.Embedder
+	}
+
+	result, err := embedder.Embed([]string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := p.index().SearchEmbedding(result.Embeddings[0], k, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var retrievals []Retrieval
+	for _, r := range results {
+		if r.Score < p.MinScore {
+			continue
+		}
+		retrievals = append(retrievals, Retrieval{Chunk: chunkFromMetadata(r.Id, r.Metadata), Score: r.Score})
+	}
+	return retrievals, nil
+}
+
+// Augment 返回注入了参考资料的请求副本, Prompt和History格式的请求转换为Messages格式
+func (p *Pipeline) Augment(request *client.CompletionRequest, retrievals []Retrieval) *client.CompletionRequest {
+	result := *request
+	if len(request.Messages) == 0 {
+		result.Messages = client.HistoryToMessages("", request.Prompt, request.History)
+		result.Prompt, result.History = "", nil
+	} else {
+		result.Messages = append([]client.ChatCompletionMessage(nil), request.Messages...)
+	}
+
+	if len(retrievals) == 0 {
+		return &result
+	}
+
+	var builder strings.Builder
+	for i, retrieval := range retrievals {
+		if i > 0 {
+			builder.WriteString("\n\n")
+		}
+
+		builder.WriteString(fmt.Sprintf("[%d]", i+1))
+		if title := chunkTitle(&retrieval.Chunk); title != "" {
+			builder.WriteString(" " + title)
+		}
+		builder.WriteString("\n" + retrieval.Chunk.Text)
+	}
+
+	prompt := p.Prompt
+	if prompt == "" {
+		prompt = DefaultPrompt
+	}
+	content := fmt.Sprintf(prompt, builder.String())
+
+	role := p.Role
+	if role == "" {
+		role = client.RoleSystem
+	}
+
+	if role == client.RoleSystem && len(result.Messages) > 0 && result.Messages[0].Role == client.RoleSystem {
+		result.Messages[0].Content = result.Messages[0].Content + "\n\n" + content
+	} else {
+		result.Messages = append([]client.ChatCompletionMessage{{Role: role, Content: content}}, result.Messages...)
+	}
+	return &result
+}
+
+// Ask 以最后一条用户消息或Prompt为问题检索并生成回答.
+// 服务端未返回DocReferences时, 在响应的副本中使用检索到的分块填充, 可直接用于Citations解析引用; ctx取消时中断模型调用
+func (p *Pipeline) Ask(ctx context.Context, request *client.CompletionRequest, filter vectorindex.Filter) (*Answer, error) {
+	question := request.Prompt
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		if request.Messages[i].Role == client.RoleUser {
+			question = request.Messages[i].Content
+			break
+		}
+	}
+
+	if strings.TrimSpace(question) == "" {
+		return nil, ErrNoQuestion
+	}
+
+	retrievals, err := p.Retrieve(question, 0, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := p.Client.CreateCompletionWithContext(ctx, p.Augment(request, retrievals))
+	if err != nil {
+		return nil, err
+	}
+
+	answer := &Answer{Response: response, Chunks: retrievals, References: References(retrievals)}
+	if response.Data != nil && len(response.Data.DocReferences) == 0 {
+		//响应可能来自缓存等共享的实例, 复制后再填充, 不修改原响应
+		copied, data := *response, *response.Data
+		data.DocReferences = answer.References
+		copied.Data = &data
+		answer.Response = &copied
+	}
+	return answer, nil
+}
+
+// References 将分块转换为DocReferences, IndexId为从1开始的编号
+func References(retrievals []Retrieval) []client.CompletionResponseDataDocReference {
+	var references []client.CompletionResponseDataDocReference
+	for i, retrieval := range retrievals {
+		references = append(references, client.CompletionResponseDataDocReference{
+			IndexId: strconv.Itoa(i + 1),
+			Title:   chunkTitle(&retrieval.Chunk),
+			DocId:   retrieval.Chunk.DocId,
+			DocName: retrieval.Chunk.Title,
+			DocUrl:  retrieval.Chunk.Url,
+			Text:    retrieval.Chunk.Text,
+		})
+	}
+	return references
+}
+
+// chunkTitle 文档标题和分块标题路径
+func chunkTitle(chunk *Chunk) string {
+	if chunk.Title == "" || chunk.Heading == "" {
+		return chunk.Title + chunk.Heading
+	}
+	return chunk.Title + headingSeparator + chunk.Heading
+}
+
+func chunkMetadata(chunk *Chunk) map[string]string {
+	metadata := make(map[string]string, len(chunk.Metadata)+6)
+	for key, value := range chunk.Metadata {
+		metadata[key] = value
+	}
+
+	metadata[metadataDocId] = chunk.DocId
+	metadata[metadataTitle] = chunk.Title
+	metadata[metadataUrl] = chunk.Url
+	metadata[metadataHeading] = chunk.Heading
+	metadata[metadataText] = chunk.Text
+	metadata[metadataIndex] = strconv.Itoa(chunk.Index)
+	return metadata
+}
+
+func chunkFromMetadata(id string, metadata map[string]string) Chunk {
+	chunk := Chunk{
+		Id:      id,
+		DocId:   metadata[metadataDocId],
+		Title:   metadata[metadataTitle],
+		Url:     metadata[metadataUrl],
+		Heading: metadata[metadataHeading],
+		Text:    metadata[metadataText],
+	}
+	chunk.Index, _ = strconv.Atoi(metadata[metadataIndex])
+
+	for key, value := range metadata {
+		if !strings.HasPrefix(key, "_") {
+			if chunk.Metadata == nil {
+				chunk.Metadata = make(map[string]string)
+			}
+			chunk.Metadata[key] = value
+		}
+	}
+	return chunk
+}
@@ -0,0 +1,193 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for rag pipeline
+ * @version 1.0.0
+ */
+
+package rag_test
+
+import (
+	"context"
+	"encoding/json"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/rag"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/vectorindex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var keywords = []string{"退货", "运费", "发票", "安装"}
+
+// keywordEmbedder 向量的每一维表示是否包含对应的关键词
+type keywordEmbedder struct {
+	calls int
+}
+
+func (e *keywordEmbedder) Embed(texts []string) (*client.EmbeddingResult, error) {
+	e.calls++
+	result := &client.EmbeddingResult{}
+	for _, text := range texts {
+		embedding := []float64{0.01}
+		for _, keyword := range keywords {
+			if strings.Contains(text, keyword) {
+				embedding = append(embedding, 1)
+			} else {
+				embedding = append(embedding, 0)
+			}
+		}
+		result.Embeddings = append(result.Embeddings, embedding)
+	}
+	return result, nil
+}
+
+func TestPipelineAsk(t *testing.T) {
+	var received *client.CompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = &client.CompletionRequest{}
+		_ = json.NewDecoder(r.Body).Decode(received)
+
+		_ = json.NewEncoder(w).Encode(&client.CompletionResponse{
+			Success: true,
+			Data:    &client.CompletionResponseData{Text: "7天内可以退货<ref>[1]</ref>。"},
+		})
+	}))
+	defer server.Close()
+
+	pipeline := &rag.Pipeline{
+		Client:   &client.CompletionClient{Token: "token", Endpoint: server.URL},
+		Embedder: &keywordEmbedder{},
+		TopK:     2,
+		MinScore: 0.5,
+	}
+
+	_, err := pipeline.AddDocuments(
+		rag.Document{Id: "policy", Title: "售后政策", Text: "商品签收后7天内可以退货。", Metadata: map[string]string{"lang": "zh"}},
+		rag.Document{Id: "invoice", Title: "发票说明", Text: "发票在订单完成后开具。", Metadata: map[string]string{"lang": "zh"}},
+		rag.Document{Id: "install", Title: "安装指南", Text: "按说明书安装。", Metadata: map[string]string{"lang": "en"}},
+	)
+	if err != nil {
+		t.Fatalf("failed to add documents: %v", err)
+	}
+
+	request := &client.CompletionRequest{
+		AppId:    "app",
+		Messages: []client.ChatCompletionMessage{{Role: client.RoleSystem, Content: "你是客服"}, {Role: client.RoleUser, Content: "怎么退货"}},
+	}
+	answer, err := pipeline.Ask(context.Background(), request, vectorindex.Match(map[string]string{"lang": "zh"}))
+	if err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	if len(answer.Chunks) != 1 || answer.Chunks[0].Chunk.DocId != "policy" || answer.Chunks[0].Chunk.Metadata["lang"] != "zh" {
+		t.Fatalf("unexpected chunks: %v", answer.Chunks)
+	}
+
+	if len(received.Messages) != 2 || !strings.HasPrefix(received.Messages[0].Content, "你是客服\n\n") ||
+		!strings.Contains(received.Messages[0].Content, "[1] 售后政策\n商品签收后7天内可以退货。") {
+		t.Errorf("unexpected request: %s", received)
+	}
+
+	reference := answer.References[0]
+	if reference.IndexId != "1" || reference.DocId != "policy" || reference.DocName != "售后政策" || answer.Text() == "" {
+		t.Errorf("unexpected reference: %s", reference)
+	}
+
+	//引用编号与References对应, 可直接解析
+	cited := answer.Response.Citations()
+	if len(cited.Citations) != 1 || cited.Citations[0].Reference.DocId != "policy" {
+		t.Errorf("unexpected citations: %s", cited)
+	}
+
+	if _, err = pipeline.Ask(context.Background(), &client.CompletionRequest{AppId: "app"}, nil); err != rag.ErrNoQuestion {
+		t.Errorf("expected no question error, got: %v", err)
+	}
+}
+
+// sharedCache 每次返回同一个响应实例
+type sharedCache struct {
+	response *client.CompletionResponse
+}
+
+func (c *sharedCache) Lookup(request *client.CompletionRequest) (*client.CompletionResponse, error) {
+	return c.response, nil
+}
+
+func (c *sharedCache) Store(request *client.CompletionRequest, response *client.CompletionResponse) error {
+	return nil
+}
+
+func TestPipelineAskSharedResponse(t *testing.T) {
+	cache := &sharedCache{response: &client.CompletionResponse{Success: true, Data: &client.CompletionResponseData{Text: "可以退货<ref>[1]</ref>"}}}
+	pipeline := &rag.Pipeline{
+		Client:   &client.CompletionClient{Token: "token", Cache: cache},
+		Embedder: &keywordEmbedder{},
+	}
+
+	if _, err := pipeline.AddDocuments(rag.Document{Id: "policy", Title: "售后政策", Text: "商品签收后7天内可以退货。"}); err != nil {
+		t.Fatalf("failed to add documents: %v", err)
+	}
+
+	answer, err := pipeline.Ask(context.Background(), &client.CompletionRequest{AppId: "app", Prompt: "怎么退货"}, nil)
+	if err != nil {
+		t.Fatalf("failed to ask: %v", err)
+	}
+
+	//填充的引用只出现在返回的副本中, 共享的响应不被修改
+	if len(answer.Response.Data.DocReferences) != 1 || len(cache.response.Data.DocReferences) != 0 {
+		t.Errorf("unexpected references, answer: %s, shared: %s", answer.Response, cache.response)
+	}
+}
+
+func TestPipelineAugmentPrompt(t *testing.T) {
+	pipeline := &rag.Pipeline{Prompt: "资料:\n%s", Role: client.RoleUser}
+	retrievals := []rag.Retrieval{{Chunk: rag.Chunk{Title: "指南", Heading: "配置", Text: "设置AccessKey。"}}}
+	request := &client.CompletionRequest{Prompt: "如何配置", History: []client.ChatQaMessage{{User: "你好", Bot: "你好"}}}
+
+	augmented := pipeline.Augment(request, retrievals)
+	if augmented.Prompt != "" || len(augmented.Messages) != 4 || request.Prompt != "如何配置" {
+		t.Fatalf("unexpected request: %s", augmented)
+	}
+
+	if message := augmented.Messages[0]; message.Role != client.RoleUser || message.Content != "资料:\n[1] 指南 > 配置\n设置AccessKey。" {
+		t.Errorf("unexpected message: %s", message)
+	}
+}
+
+func TestPipelineReplaceDocument(t *testing.T) {
+	pipeline := &rag.Pipeline{
+		Embedder: &keywordEmbedder{},
+		Chunker:  &rag.Chunker{MaxTokens: 12, Overlap: -1, Estimator: runeEstimator{}},
+	}
+
+	chunks, err := pipeline.AddDocuments(rag.Document{Id: "policy", Text: "商品签收后可以退货。发票在订单完成后开具。按说明书安装。"})
+	if err != nil || len(chunks) != 3 {
+		t.Fatalf("unexpected chunks: %v, err: %v", chunks, err)
+	}
+
+	//重新添加后分块数变少, 旧的多余分块被删除
+	if _, err = pipeline.AddDocuments(rag.Document{Id: "policy", Text: "商品签收后可以退货。"}); err != nil {
+		t.Fatalf("failed to replace document: %v", err)
+	}
+
+	if _, ok := pipeline.Index.Get("policy#1"); ok || pipeline.Index.Len() != 1 {
+		t.Errorf("stale chunks should be deleted, size: %d", pipeline.Index.Len())
+	}
+}
+
+func TestAnswerText(t *testing.T) {
+	answer := &rag.Answer{Response: &client.CompletionResponse{Data: &client.CompletionResponseData{
+		Choices: []client.CompletionResponseChoice{{Message: &client.CompletionResponseMessage{Role: "assistant", Content: "回答"}}},
+	}}}
+	if answer.Text() != answer.Response.OutputText() || answer.Text() != "回答" {
+		t.Errorf("unexpected text: %s", answer.Text())
+	}
+
+	if (&rag.Answer{}).Text() != "" {
+		t.Errorf("expected empty text")
+	}
+}
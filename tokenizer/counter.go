@@ -0,0 +1,111 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief token counter for messages and completion requests
+ * @version 1.0.0
+ */
+
+package tokenizer
+
+import (
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"strings"
+)
+
+// ModelRule 模型对话模板的token开销规则
+type ModelRule struct {
+	// MessagePrefix 每条消息内容前的模板, {role}替换为消息角色
+	MessagePrefix string
+	// MessageSuffix 每条消息内容后的模板
+	MessageSuffix string
+	// ReplyPrimer 请求末尾引导模型回复的模板
+	ReplyPrimer string
+}
+
+// ChatMLRule 通义千问系列模型使用的ChatML模板
+var ChatMLRule = ModelRule{
+	MessagePrefix: ImStart + "{role}\n",
+	MessageSuffix: ImEnd + "\n",
+	ReplyPrimer:   ImStart + "assistant\n",
+}
+
+// ModelRules 按模型名前缀匹配的开销规则, 未匹配时使用ChatMLRule
+var ModelRules = map[string]ModelRule{
+	"qwen": ChatMLRule,
+}
+
+// RuleForModel 返回与模型名最长前缀匹配的规则
+func RuleForModel(model string) ModelRule {
+	model = strings.ToLower(model)
+	rule, matched := ChatMLRule, ""
+	for prefix, candidate := range ModelRules {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			rule, matched = candidate, prefix
+		}
+	}
+	return rule
+}
+
+// Counter 按模型规则统计文本、消息和请求的token数, 实现了client.TokenEstimator和client.RequestTokenEstimator,
+// 可直接用于client.TokenBudgetStrategy
+type Counter struct {
+	Tokenizer *Tokenizer
+	Rule      ModelRule
+}
+
+func NewCounter(tokenizer *Tokenizer, model string) *Counter {
+	return &Counter{Tokenizer: tokenizer, Rule: RuleForModel(model)}
+}
+
+// CountText 返回文本的token数, 不包含模板开销
+func (c *Counter) CountText(text string) int {
+	return c.Tokenizer.Count(text)
+}
+
+// CountMessage 返回单条消息的token数, 包含模板开销
+func (c *Counter) CountMessage(message client.ChatCompletionMessage) int {
+	return c.countTurn(string(message.Role), message.Content)
+}
+
+// CountMessages 返回消息列表的token数, 包含引导模型回复的模板开销
+func (c *Counter) CountMessages(messages []client.ChatCompletionMessage) int {
+	total := c.Tokenizer.CountWithSpecial(c.Rule.ReplyPrimer)
+	for _, message := range messages {
+		total += c.CountMessage(message)
+	}
+	return total
+}
+
+// CountRequest 返回请求上下文的token数; Prompt+History格式按用户与助手交替的消息统计
+func (c *Counter) CountRequest(request *client.CompletionRequest) int {
+	total := c.CountMessages(request.Messages)
+	for _, message := range request.History {
+		total += c.countTurn(string(client.RoleUser), message.User)
+		if message.Bot != "" {
+			total += c.countTurn(string(client.RoleAssistant), message.Bot)
+		}
+	}
+
+	if request.Prompt != "" {
+		total += c.countTurn(string(client.RoleUser), request.Prompt)
+	}
+	return total
+}
+
+func (c *Counter) EstimateTokens(text string) int {
+	return c.CountText(text)
+}
+
+func (c *Counter) EstimateRequestTokens(request *client.CompletionRequest) int {
+	return c.CountRequest(request)
+}
+
+// countTurn 内容单独编码, 避免内容中出现的特殊token字符串被当作模板
+func (c *Counter) countTurn(role string, content string) int {
+	prefix := strings.Replace(c.Rule.MessagePrefix, "{role}", role, -1)
+	return c.Tokenizer.CountWithSpecial(prefix) +
+		c.Tokenizer.Count(content) +
+		c.Tokenizer.CountWithSpecial(c.Rule.MessageSuffix)
+}
@@ -0,0 +1,411 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief offline byte-level BPE token estimator
+ * @version 1.0.0
+ */
+
+// Package tokenizer 基于tiktoken格式词表的字节级BPE实现, 用于离线估算token数.
+//
+// 本实现未与通义千问官方tokenizer的编码结果对比验证, 统计结果仅为估算, 计费以服务端返回的Usage为准;
+// testdata中的fixtures由本包对mini.tiktoken词表的编码结果生成, 只用于发现实现的回归
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	EndOfText = "<|endoftext|>"
+	ImStart   = "<|im_start|>"
+	ImEnd     = "<|im_end|>"
+)
+
+// ErrUnknownByte 词表缺少单字节token时无法编码包含该字节的文本
+var ErrUnknownByte = errors.New("Byte is missing from vocabulary")
+
+// DefaultSpecialTokens 通义千问词表的特殊token, id紧随普通词表之后
+var DefaultSpecialTokens = []string{EndOfText, ImStart, ImEnd}
+
+// Tokenizer 基于tiktoken格式词表的BPE分词器, 加载后可并发使用
+type Tokenizer struct {
+	encoder map[string]int
+	special map[string]int
+	decoder map[int]string
+	// specialOrder 按长度降序, 匹配时优先匹配较长的特殊token
+	specialOrder []string
+}
+
+// LoadFile 从文件加载tiktoken格式词表, 如qwen.tiktoken
+func LoadFile(path string) (*Tokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Load(file)
+}
+
+// Load 加载tiktoken格式词表, 每行为base64编码的token和对应的rank, 以空格分隔
+func Load(reader io.Reader) (*Tokenizer, error) {
+	encoder := make(map[string]int)
+	maxRank := -1
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid vocabulary line %d: %q", lineNo, line)
+		}
+
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid token at line %d: %v", lineNo, err)
+		}
+
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rank at line %d: %v", lineNo, err)
+		}
+
+		encoder[string(token)] = rank
+		if rank > maxRank {
+			maxRank = rank
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(encoder) == 0 {
+		return nil, fmt.Errorf("Empty vocabulary")
+	}
+
+	special := make(map[string]int)
+	for i, token := range DefaultSpecialTokens {
+		special[token] = maxRank + 1 + i
+	}
+
+	return New(encoder, special), nil
+}
+
+// New 使用已有的词表和特殊token创建分词器
+func New(encoder map[string]int, special map[string]int) *Tokenizer {
+	t := &Tokenizer{encoder: encoder, special: special, decoder: make(map[int]string, len(encoder)+len(special))}
+	for token, rank := range encoder {
+		t.decoder[rank] = token
+	}
+
+	for token, rank := range special {
+		t.decoder[rank] = token
+		t.specialOrder = append(t.specialOrder, token)
+	}
+
+	sort.Slice(t.specialOrder, func(i, j int) bool {
+		return len(t.specialOrder[i]) > len(t.specialOrder[j])
+	})
+	return t
+}
+
+// VocabularySize 普通词表与特殊token的总数
+func (t *Tokenizer) VocabularySize() int {
+	return len(t.encoder) + len(t.special)
+}
+
+// Encode 编码普通文本, 文本中的特殊token字符串按普通文本处理; 文本包含词表中缺少的字节时返回ErrUnknownByte
+func (t *Tokenizer) Encode(text string) ([]int, error) {
+	ids, missing := t.encode(text, nil)
+	return ids, missingError(missing)
+}
+
+// EncodeWithSpecial 编码文本, 文本中出现的特殊token编码为对应的特殊id
+func (t *Tokenizer) EncodeWithSpecial(text string) ([]int, error) {
+	ids, missing := t.encodeWithSpecial(text)
+	return ids, missingError(missing)
+}
+
+// Count 返回普通文本的token数, 词表中缺少的字节每个按1个token计数
+func (t *Tokenizer) Count(text string) int {
+	ids, missing := t.encode(text, nil)
+	return len(ids) + missing
+}
+
+// CountWithSpecial 返回文本的token数, 文本中出现的特殊token按1个token计数
+func (t *Tokenizer) CountWithSpecial(text string) int {
+	ids, missing := t.encodeWithSpecial(text)
+	return len(ids) + missing
+}
+
+// Decode 将id还原为文本, 未知的id被忽略
+func (t *Tokenizer) Decode(ids []int) string {
+	var buffer bytes.Buffer
+	for _, id := range ids {
+		buffer.WriteString(t.decoder[id])
+	}
+	return buffer.String()
+}
+
+// encode 编码普通文本, 返回编码结果和词表中缺少的字节数
+func (t *Tokenizer) encode(text string, ids []int) ([]int, int) {
+	missing := 0
+	for _, piece := range splitPieces(text) {
+		var n int
+		ids, n = t.encodePiece([]byte(piece), ids)
+		missing += n
+	}
+	return ids, missing
+}
+
+func (t *Tokenizer) encodeWithSpecial(text string) ([]int, int) {
+	var ids []int
+	missing := 0
+	for len(text) > 0 {
+		index, token := t.nextSpecial(text)
+		if index < 0 {
+			index = len(text)
+		}
+
+		var n int
+		ids, n = t.encode(text[:index], ids)
+		missing += n
+		if token == "" {
+			break
+		}
+
+		ids = append(ids, t.special[token])
+		text = text[index+len(token):]
+	}
+	return ids, missing
+}
+
+func missingError(missing int) error {
+	if missing == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes cannot be encoded", ErrUnknownByte, missing)
+}
+
+func (t *Tokenizer) nextSpecial(text string) (int, string) {
+	bestIndex, bestToken := -1, ""
+	for _, token := range t.specialOrder {
+		index := strings.Index(text, token)
+		if index >= 0 && (bestIndex < 0 || index < bestIndex) {
+			bestIndex, bestToken = index, token
+		}
+	}
+	return bestIndex, bestToken
+}
+
+// encodePiece 对单个预分词片段做字节级BPE合并, 每次合并rank最小的相邻片段, rank相同时合并靠前的片段;
+// 使用小顶堆选择待合并的片段, 长片段(如连续的中文)的复杂度为O(n log n). 返回编码结果和词表中缺少的字节数
+func (t *Tokenizer) encodePiece(piece []byte, ids []int) ([]int, int) {
+	if rank, ok := t.encoder[string(piece)]; ok {
+		return append(ids, rank), 0
+	}
+
+	//parts为双向链表, 每个片段初始为单个字节; 合并时删除右侧片段
+	parts := make([]piecePart, len(piece))
+	for i := range parts {
+		parts[i] = piecePart{start: i, end: i + 1, prev: i - 1, next: i + 1}
+	}
+	parts[len(parts)-1].next = -1
+
+	candidates := &mergeHeap{}
+	push := func(left int) {
+		if left < 0 || parts[left].next < 0 {
+			return
+		}
+
+		right := parts[left].next
+
+		if rank, ok := t.encoder[string(piece[parts[left].start:parts[right].end])]; ok {
+			heap.Push(candidates, mergeCandidate{rank: rank, left: left, right: right, end: parts[right].end})
+		}
+	}
+
+	for i := range parts {
+		push(i)
+	}
+
+	for candidates.Len() > 0 {
+		candidate := heap.Pop(candidates).(mergeCandidate)
+		left, right := &parts[candidate.left], &parts[candidate.right]
+		//片段已被合并或已变化时候选失效
+		if left.removed || right.removed || left.next != candidate.right || right.end != candidate.end {
+			continue
+		}
+
+		left.end, left.next = right.end, right.next
+		right.removed = true
+		if right.next >= 0 {
+			parts[right.next].prev = candidate.left
+		}
+
+		push(left.prev)
+		push(candidate.left)
+	}
+
+	missing := 0
+	for i := 0; i >= 0; i = parts[i].next {
+		part := piece[parts[i].start:parts[i].end]
+		rank, ok := t.encoder[string(part)]
+		if !ok {
+			//未合并的片段只能是单个字节, 说明词表缺少该字节
+			missing += len(part)
+			continue
+		}
+		ids = append(ids, rank)
+	}
+	return ids, missing
+}
+
+// piecePart 片段中的一段字节, 按链表连接
+type piecePart struct {
+	start, end int
+	prev, next int
+	removed    bool
+}
+
+// mergeCandidate 可合并的相邻片段, end用于判断右侧片段是否已变化
+type mergeCandidate struct {
+	rank        int
+	left, right int
+	end         int
+}
+
+// mergeHeap 按rank排序的小顶堆, rank相同时靠前的片段优先
+type mergeHeap []mergeCandidate
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].left < h[j].left
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeCandidate)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// splitPieces 参照通义千问的预分词正则切分文本:
+// (?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func splitPieces(text string) []string {
+	runes := []rune(text)
+	var pieces []string
+
+	for i := 0; i < len(runes); {
+		end := matchPiece(runes, i)
+		pieces = append(pieces, string(runes[i:end]))
+		i = end
+	}
+	return pieces
+}
+
+func matchPiece(runes []rune, i int) int {
+	n := len(runes)
+	r := runes[i]
+
+	if r == '\'' && i+1 < n {
+		for _, suffix := range []string{"re", "ve", "ll", "s", "t", "m", "d"} {
+			if hasFoldPrefix(runes[i+1:], suffix) {
+				return i + 1 + utf8.RuneCountInString(suffix)
+			}
+		}
+	}
+
+	if unicode.IsLetter(r) {
+		return scanLetters(runes, i)
+	}
+
+	if r != '\r' && r != '\n' && !unicode.IsNumber(r) && i+1 < n && unicode.IsLetter(runes[i+1]) {
+		return scanLetters(runes, i+1)
+	}
+
+	if unicode.IsNumber(r) {
+		return i + 1
+	}
+
+	start := i
+	if r == ' ' && i+1 < n && isPunctuation(runes[i+1]) {
+		start = i + 1
+	}
+	if isPunctuation(runes[start]) {
+		j := start
+		for j < n && isPunctuation(runes[j]) {
+			j++
+		}
+		for j < n && (runes[j] == '\r' || runes[j] == '\n') {
+			j++
+		}
+		return j
+	}
+
+	j := i
+	lastNewline := -1
+	for j < n && unicode.IsSpace(runes[j]) {
+		if runes[j] == '\r' || runes[j] == '\n' {
+			lastNewline = j
+		}
+		j++
+	}
+
+	if lastNewline >= 0 {
+		return lastNewline + 1
+	}
+
+	if j < n && j-i > 1 {
+		return j - 1
+	}
+	return j
+}
+
+func scanLetters(runes []rune, i int) int {
+	for i < len(runes) && unicode.IsLetter(runes[i]) {
+		i++
+	}
+	return i
+}
+
+func isPunctuation(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+func hasFoldPrefix(runes []rune, prefix string) bool {
+	i := 0
+	for _, p := range prefix {
+		if i >= len(runes) || unicode.ToLower(runes[i]) != p {
+			return false
+		}
+		i++
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases for offline tokenizer
+ * @version 1.0.0
+ */
+
+package tokenizer_test
+
+import (
+	"encoding/json"
+	"errors"
+	client "github.com/aliyun/alibabacloud-bailian-go-sdk/client"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/tokenizer"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadTokenizer(t *testing.T) *tokenizer.Tokenizer {
+	tok, err := tokenizer.LoadFile("testdata/mini.tiktoken")
+	if err != nil {
+		t.Fatalf("failed to load vocabulary: %v", err)
+	}
+	return tok
+}
+
+func TestEncodeFixtures(t *testing.T) {
+	tok := loadTokenizer(t)
+
+	data, err := ioutil.ReadFile("testdata/fixtures.json")
+	if err != nil {
+		t.Fatalf("failed to read fixtures: %v", err)
+	}
+
+	var fixtures []struct {
+		Text string
+		Ids  []int
+	}
+	if err = json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("failed to parse fixtures: %v", err)
+	}
+
+	for _, fixture := range fixtures {
+		ids, err := tok.Encode(fixture.Text)
+		if err != nil || !reflect.DeepEqual(ids, fixture.Ids) {
+			t.Errorf("unexpected ids for %q, got: %v, expected: %v, err: %v", fixture.Text, ids, fixture.Ids, err)
+		}
+
+		if tok.Count(fixture.Text) != len(fixture.Ids) {
+			t.Errorf("unexpected count for %q", fixture.Text)
+		}
+
+		if decoded := tok.Decode(ids); decoded != fixture.Text {
+			t.Errorf("unexpected decoded text: %q, expected: %q", decoded, fixture.Text)
+		}
+	}
+}
+
+func TestEncodeWithSpecial(t *testing.T) {
+	tok := loadTokenizer(t)
+
+	if tok.VocabularySize() != 271 {
+		t.Errorf("unexpected vocabulary size: %d", tok.VocabularySize())
+	}
+
+	ids, err := tok.EncodeWithSpecial(tokenizer.ImStart + "hello" + tokenizer.ImEnd)
+	if err != nil || !reflect.DeepEqual(ids, []int{269, 259, 270}) {
+		t.Errorf("unexpected ids: %v, err: %v", ids, err)
+	}
+
+	if count := tok.Count(tokenizer.ImEnd); count <= 1 {
+		t.Errorf("special token in plain text should not be encoded as special, count: %d", count)
+	}
+}
+
+func TestEncodeUnknownByte(t *testing.T) {
+	tok := tokenizer.New(map[string]int{"a": 0, "b": 1, "ab": 2}, map[string]int{tokenizer.ImEnd: 3})
+
+	if ids, err := tok.Encode("abc"); !errors.Is(err, tokenizer.ErrUnknownByte) {
+		t.Errorf("expected unknown byte error, got: %v, err: %v", ids, err)
+	}
+
+	if _, err := tok.EncodeWithSpecial("ab" + tokenizer.ImEnd + "中"); !errors.Is(err, tokenizer.ErrUnknownByte) {
+		t.Errorf("expected unknown byte error, got: %v", err)
+	}
+
+	//计数时缺少的字节按1个token计数
+	if count := tok.Count("abc"); count != 2 {
+		t.Errorf("unexpected count: %d", count)
+	}
+
+	if count := tok.CountWithSpecial("ab" + tokenizer.ImEnd + "中"); count != 5 {
+		t.Errorf("unexpected count: %d", count)
+	}
+}
+
+func TestEncodeMergeOrder(t *testing.T) {
+	tok := tokenizer.New(map[string]int{"a": 0, "b": 1, "aa": 2, "ab": 3, "aab": 4}, nil)
+
+	//rank相同时先合并靠前的片段, rank小的合并优先
+	for text, expected := range map[string][]int{"aaa": {2, 0}, "aab": {4}, "aaab": {2, 3}} {
+		if ids, err := tok.Encode(text); err != nil || !reflect.DeepEqual(ids, expected) {
+			t.Errorf("unexpected ids for %q: %v, err: %v", text, ids, err)
+		}
+	}
+}
+
+func TestCountLongPiece(t *testing.T) {
+	tok := tokenizer.New(map[string]int{"\xe4": 0, "\xb8": 1, "\xad": 2, "\xe4\xb8": 3, "中": 4}, nil)
+
+	//连续的中文为一个预分词片段, 合并耗时不随片段长度平方增长
+	done := make(chan int, 1)
+	go func() {
+		done <- tok.Count(strings.Repeat("中", 200000))
+	}()
+
+	select {
+	case count := <-done:
+		if count != 200000 {
+			t.Errorf("unexpected count: %d", count)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("count of long piece timed out")
+	}
+}
+
+func TestLoadInvalidVocabulary(t *testing.T) {
+	for _, content := range []string{"", "aGk=", "!!! 1", "aGk= x"} {
+		if _, err := tokenizer.Load(strings.NewReader(content)); err == nil {
+			t.Errorf("expected error for vocabulary: %q", content)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	counter := tokenizer.NewCounter(loadTokenizer(t), "qwen-max")
+
+	messages := []client.ChatCompletionMessage{
+		{Role: client.RoleSystem, Content: "春天"},
+		{Role: client.RoleUser, Content: "hello world"},
+	}
+
+	//system: <|im_start|> + system + \n + 4 + <|im_end|> + \n = 14
+	//user: <|im_start|> + user + \n + 2 + <|im_end|> + \n = 10
+	//reply primer: <|im_start|> + assistant + \n = 11
+	if count := counter.CountMessages(messages); count != 35 {
+		t.Errorf("unexpected message tokens: %d", count)
+	}
+
+	request := &client.CompletionRequest{
+		Prompt:  "hello world",
+		History: []client.ChatQaMessage{{User: "hello world", Bot: "春天"}},
+	}
+
+	//user: 10, assistant: 1 + 9 + 1 + 4 + 2 = 17, prompt: 10, reply primer: 11
+	if count := counter.CountRequest(request); count != 48 {
+		t.Errorf("unexpected request tokens: %d", count)
+	}
+
+	if client.EstimateRequestTokens(counter, request) != 48 {
+		t.Errorf("counter should be used as request token estimator")
+	}
+
+	budget := client.TokenBudgetStrategy{MaxContextTokens: 40, Estimator: counter}
+	if err := budget.Trim(request); err != nil || len(request.History) != 0 {
+		t.Errorf("history should be trimmed, history: %v, err: %v", request.History, err)
+	}
+}
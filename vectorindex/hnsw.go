@@ -0,0 +1,288 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief hierarchical navigable small world graph for approximate search
+ * @version 1.0.0
+ */
+
+package vectorindex
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	DefaultHNSWM              = 16
+	DefaultHNSWEfConstruction = 200
+	DefaultHNSWEfSearch       = 64
+	// DefaultHNSWMaxDeletedRatio 默认在删除的节点超过全部节点的1/4时重建图
+	DefaultHNSWMaxDeletedRatio = 0.25
+)
+
+// HNSWOptions HNSW近似检索参数, 为0的字段使用默认值
+type HNSWOptions struct {
+	// M 每层的最大连接数, 第0层为2M
+	M int
+	// EfConstruction 构建时的候选集大小, 越大召回率越高、构建越慢
+	EfConstruction int
+	// EfSearch 检索时的候选集大小, 小于k时使用k
+	EfSearch int
+	// Seed 随机层数的种子, 相同的种子和写入顺序生成相同的图
+	Seed int64
+	// MaxDeletedRatio 删除和被替换的节点占全部节点的比例超过该值时移除这些节点并重建图
+	MaxDeletedRatio float64
+}
+
+type hnswNode struct {
+	level     int
+	neighbors [][]int32
+}
+
+type hnswGraph struct {
+	options  HNSWOptions
+	nodes    []hnswNode
+	entry    int
+	maxLevel int
+	levelMul float64
+	random   *rand.Rand
+}
+
+// hnswFile 图结构的持久化格式
+type hnswFile struct {
+	Options   HNSWOptions
+	Entry     int
+	MaxLevel  int
+	Levels    []int
+	Neighbors [][][]int32
+}
+
+func newHNSWGraph(options HNSWOptions) *hnswGraph {
+	if options.M <= 0 {
+		options.M = DefaultHNSWM
+	}
+
+	if options.EfConstruction <= 0 {
+		options.EfConstruction = DefaultHNSWEfConstruction
+	}
+
+	if options.EfSearch <= 0 {
+		options.EfSearch = DefaultHNSWEfSearch
+	}
+
+	if options.MaxDeletedRatio <= 0 {
+		options.MaxDeletedRatio = DefaultHNSWMaxDeletedRatio
+	}
+
+	return &hnswGraph{
+		options:  options,
+		levelMul: 1 / math.Log(float64(options.M)),
+		random:   rand.New(rand.NewSource(options.Seed)),
+	}
+}
+
+func (g *hnswGraph) maxNeighbors(level int) int {
+	if level == 0 {
+		return 2 * g.options.M
+	}
+	return g.options.M
+}
+
+// insert 将x.items[i]加入图中, 调用方持有写锁
+func (g *hnswGraph) insert(x *Index, i int) {
+	level := int(-math.Log(1-g.random.Float64()) * g.levelMul)
+	g.nodes = append(g.nodes, hnswNode{level: level, neighbors: make([][]int32, level+1)})
+	if len(g.nodes) == 1 {
+		g.entry, g.maxLevel = i, level
+		return
+	}
+
+	query := x.items[i].Vector
+	entries := []scored{{index: g.entry, score: x.score(query, x.items[g.entry].Vector)}}
+	for l := g.maxLevel; l > level; l-- {
+		entries = g.searchLayer(x, query, entries, 1, l)
+	}
+
+	for l := minInt(level, g.maxLevel); l >= 0; l-- {
+		candidates := g.searchLayer(x, query, entries, g.options.EfConstruction, l)
+		neighbors := closest(candidates, g.maxNeighbors(l))
+		g.nodes[i].neighbors[l] = neighbors
+
+		for _, neighbor := range neighbors {
+			g.connect(x, int(neighbor), i, l)
+		}
+		entries = candidates
+	}
+
+	if level > g.maxLevel {
+		g.entry, g.maxLevel = i, level
+	}
+}
+
+// connect 添加从node到target的连接, 超过最大连接数时保留最相似的连接
+func (g *hnswGraph) connect(x *Index, node, target, level int) {
+	neighbors := append(g.nodes[node].neighbors[level], int32(target))
+	if len(neighbors) > g.maxNeighbors(level) {
+		vector := x.items[node].Vector
+		candidates := make([]scored, len(neighbors))
+		for j, neighbor := range neighbors {
+			candidates[j] = scored{index: int(neighbor), score: x.score(vector, x.items[neighbor].Vector)}
+		}
+		neighbors = closest(candidates, g.maxNeighbors(level))
+	}
+	g.nodes[node].neighbors[level] = neighbors
+}
+
+// searchLayer 在指定层从entries开始贪心搜索, 返回最多ef个最相似的节点
+func (g *hnswGraph) searchLayer(x *Index, query []float32, entries []scored, ef int, level int) []scored {
+	visited := make(map[int]struct{}, ef*4)
+	candidates := &candidateHeap{}
+	results := &resultHeap{}
+	for _, entry := range entries {
+		visited[entry.index] = struct{}{}
+		heap.Push(candidates, entry)
+		heap.Push(results, entry)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(scored)
+		if results.Len() >= ef && current.score < (*results)[0].score {
+			break
+		}
+
+		for _, neighbor := range g.nodes[current.index].neighbors[level] {
+			if _, ok := visited[int(neighbor)]; ok {
+				continue
+			}
+			visited[int(neighbor)] = struct{}{}
+
+			score := x.score(query, x.items[neighbor].Vector)
+			if results.Len() < ef || score > (*results)[0].score {
+				next := scored{index: int(neighbor), score: score}
+				heap.Push(candidates, next)
+				heap.Push(results, next)
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	return *results
+}
+
+// search 近似检索, 删除和不满足过滤条件的节点只用于导航, 不出现在结果中
+func (g *hnswGraph) search(x *Index, query []float32, k int, filter Filter) []Result {
+	if len(g.nodes) == 0 {
+		return nil
+	}
+
+	entries := []scored{{index: g.entry, score: x.score(query, x.items[g.entry].Vector)}}
+	for l := g.maxLevel; l > 0; l-- {
+		entries = g.searchLayer(x, query, entries, 1, l)
+	}
+
+	var matched []scored
+	for _, candidate := range g.searchLayer(x, query, entries, maxInt(g.options.EfSearch, k), 0) {
+		if x.deleted[candidate.index] || (filter != nil && !filter(x.items[candidate.index].Metadata)) {
+			continue
+		}
+		matched = append(matched, candidate)
+	}
+
+	results := x.results(matched)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+func (g *hnswGraph) file() *hnswFile {
+	file := &hnswFile{Options: g.options, Entry: g.entry, MaxLevel: g.maxLevel}
+	file.Levels = make([]int, len(g.nodes))
+	file.Neighbors = make([][][]int32, len(g.nodes))
+	for i, node := range g.nodes {
+		file.Levels[i], file.Neighbors[i] = node.level, node.neighbors
+	}
+	return file
+}
+
+func (g *hnswGraph) load(file *hnswFile, size int) error {
+	if len(file.Levels) != size || len(file.Neighbors) != size || (size > 0 && (file.Entry < 0 || file.Entry >= size)) {
+		return fmt.Errorf("Invalid hnsw graph, nodes: %d, items: %d", len(file.Levels), size)
+	}
+
+	g.nodes = make([]hnswNode, size)
+	for i := range g.nodes {
+		if len(file.Neighbors[i]) != file.Levels[i]+1 {
+			return fmt.Errorf("Invalid hnsw node %d, level: %d, layers: %d", i, file.Levels[i], len(file.Neighbors[i]))
+		}
+
+		for _, neighbors := range file.Neighbors[i] {
+			for _, neighbor := range neighbors {
+				if neighbor < 0 || int(neighbor) >= size {
+					return fmt.Errorf("Invalid hnsw neighbor %d of node %d", neighbor, i)
+				}
+			}
+		}
+		g.nodes[i] = hnswNode{level: file.Levels[i], neighbors: file.Neighbors[i]}
+	}
+
+	g.entry, g.maxLevel = file.Entry, file.MaxLevel
+	//继续写入时保持随机层数可复现
+	g.random = rand.New(rand.NewSource(g.options.Seed + int64(size)))
+	return nil
+}
+
+// closest 返回最相似的n个节点
+func closest(candidates []scored, n int) []int32 {
+	sorted := append([]scored(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].score > sorted[j].score
+	})
+
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+
+	result := make([]int32, len(sorted))
+	for i, candidate := range sorted {
+		result[i] = int32(candidate.index)
+	}
+	return result
+}
+
+// candidateHeap 按score的大顶堆, 优先扩展最相似的候选
+type candidateHeap []scored
+
+func (h candidateHeap) Len() int            { return len(h) }
+func (h candidateHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h candidateHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x interface{}) { *h = append(*h, x.(scored)) }
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,486 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief in-memory vector index with exact and hnsw search
+ * @version 1.0.0
+ */
+
+package vectorindex
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/internal/fileutil"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Metric 相似度度量
+type Metric string
+
+const (
+	// Cosine 余弦相似度, 向量在写入时归一化
+	Cosine Metric = "cosine"
+	// Dot 内积
+	Dot Metric = "dot"
+	// L2 欧氏距离, Score为负的距离
+	L2 Metric = "l2"
+)
+
+// formatVersion 持久化格式版本
+const formatVersion = 1
+
+var (
+	ErrDimensionMismatch = errors.New("Vector dimension mismatch")
+	ErrEmptyId           = errors.New("Vector id is empty")
+)
+
+// Item 索引中的向量
+type Item struct {
+	Id string
+	// Vector 使用余弦相似度时保存的是归一化后的向量
+	Vector   []float32
+	Metadata map[string]string
+}
+
+// Result 检索结果, 按Score降序排列
+type Result struct {
+	Id string
+	// Score 相似度, 越大越相似; L2时为负的欧氏距离
+	Score    float64
+	Metadata map[string]string
+}
+
+// Filter 元数据过滤条件, 返回false的向量不参与检索
+type Filter func(metadata map[string]string) bool
+
+// Match 元数据中所有指定的键值都相等时匹配
+func Match(conditions map[string]string) Filter {
+	return func(metadata map[string]string) bool {
+		for key, value := range conditions {
+			if actual, ok := metadata[key]; !ok || actual != value {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Options 索引配置
+type Options struct {
+	Metric Metric
+	// HNSW 为nil时使用精确检索, 否则使用HNSW近似检索
+	HNSW *HNSWOptions
+}
+
+// Index 内存向量索引, 并发安全
+type Index struct {
+	metric    Metric
+	dimension int
+	items     []Item
+	deleted   []bool
+	ids       map[string]int
+	count     int
+	graph     *hnswGraph
+	mutex     sync.RWMutex
+}
+
+// New 创建精确检索的索引, metric未知时panic
+func New(metric Metric) *Index {
+	index, err := NewWithOptions(Options{Metric: metric})
+	if err != nil {
+		panic(err)
+	}
+	return index
+}
+
+// NewWithOptions 创建索引, Metric为空时使用Cosine
+func NewWithOptions(options Options) (*Index, error) {
+	switch options.Metric {
+	case "":
+		options.Metric = Cosine
+	case Cosine, Dot, L2:
+	default:
+		return nil, fmt.Errorf("Unknown metric: %s", options.Metric)
+	}
+
+	index := &Index{metric: options.Metric, ids: make(map[string]int)}
+	if options.HNSW != nil {
+		index.graph = newHNSWGraph(*options.HNSW)
+	}
+	return index, nil
+}
+
+// Metric 相似度度量
+func (x *Index) Metric() Metric {
+	return x.metric
+}
+
+// Dimension 向量维度, 写入第一个向量后确定
+func (x *Index) Dimension() int {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	return x.dimension
+}
+
+// Len 向量数量
+func (x *Index) Len() int {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	return x.count
+}
+
+// Add 写入向量, Id已存在时替换
+func (x *Index) Add(items ...Item) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	defer x.compactIfNeeded()
+
+	for _, item := range items {
+		if item.Id == "" {
+			return ErrEmptyId
+		}
+
+		if x.dimension == 0 {
+			x.dimension = len(item.Vector)
+		}
+
+		if len(item.Vector) != x.dimension || x.dimension == 0 {
+			return fmt.Errorf("%w: %s has %d dimensions, expected %d", ErrDimensionMismatch, item.Id, len(item.Vector), x.dimension)
+		}
+
+		item.Vector = append([]float32(nil), item.Vector...)
+		if x.metric == Cosine {
+			normalize(item.Vector)
+		}
+
+		if i, ok := x.ids[item.Id]; ok {
+			//精确检索直接替换, HNSW将旧节点标记为删除后插入新节点
+			if x.graph == nil {
+				x.items[i] = item
+				continue
+			}
+			x.deleted[i] = true
+			x.count--
+		}
+
+		x.ids[item.Id] = len(x.items)
+		x.items = append(x.items, item)
+		x.deleted = append(x.deleted, false)
+		x.count++
+		if x.graph != nil {
+			x.graph.insert(x, len(x.items)-1)
+		}
+	}
+	return nil
+}
+
+// AddEmbeddings 写入EmbeddingClient返回的向量, metadata可为nil或与ids等长
+func (x *Index) AddEmbeddings(ids []string, embeddings [][]float64, metadata []map[string]string) error {
+	if len(ids) != len(embeddings) || (metadata != nil && len(metadata) != len(ids)) {
+		return fmt.Errorf("Invalid embeddings, ids: %d, embeddings: %d, metadata: %d", len(ids), len(embeddings), len(metadata))
+	}
+
+	items := make([]Item, len(ids))
+	for i, embedding := range embeddings {
+		vector := make([]float32, len(embedding))
+		for j, v := range embedding {
+			vector[j] = float32(v)
+		}
+
+		items[i] = Item{Id: ids[i], Vector: vector}
+		if metadata != nil {
+			items[i].Metadata = metadata[i]
+		}
+	}
+	return x.Add(items...)
+}
+
+// Get 按Id读取向量
+func (x *Index) Get(id string) (Item, bool) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	i, ok := x.ids[id]
+	if !ok {
+		return Item{}, false
+	}
+	return x.items[i], true
+}
+
+// Delete 删除向量, 不存在的Id被忽略; HNSW索引中删除的节点仍用于图的导航, 超过MaxDeletedRatio时重建图
+func (x *Index) Delete(ids ...string) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	defer x.compactIfNeeded()
+
+	for _, id := range ids {
+		i, ok := x.ids[id]
+		if !ok {
+			continue
+		}
+
+		delete(x.ids, id)
+		x.count--
+		if x.graph != nil {
+			x.deleted[i] = true
+			continue
+		}
+
+		last := len(x.items) - 1
+		if i != last {
+			x.items[i] = x.items[last]
+			x.ids[x.items[i].Id] = i
+		}
+		x.items = x.items[:last]
+		x.deleted = x.deleted[:last]
+	}
+}
+
+// Compact 移除HNSW索引中已删除的节点并重建图, 精确检索的索引不做任何操作
+func (x *Index) Compact() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if x.graph != nil && len(x.items) > x.count {
+		x.compact()
+	}
+}
+
+// compactIfNeeded 删除的节点超过MaxDeletedRatio时重建图, 调用方持有写锁
+func (x *Index) compactIfNeeded() {
+	if x.graph == nil {
+		return
+	}
+
+	removed := len(x.items) - x.count
+	if removed > 0 && float64(removed) > x.graph.options.MaxDeletedRatio*float64(len(x.items)) {
+		x.compact()
+	}
+}
+
+// compact 只保留未删除的向量, 按原顺序重新插入新图, 调用方持有写锁
+func (x *Index) compact() {
+	items := make([]Item, 0, x.count)
+	for i, item := range x.items {
+		if !x.deleted[i] {
+			items = append(items, item)
+		}
+	}
+
+	x.items, x.deleted = items, make([]bool, len(items))
+	x.ids = make(map[string]int, len(items))
+	x.graph = newHNSWGraph(x.graph.options)
+	for i, item := range items {
+		x.ids[item.Id] = i
+		x.graph.insert(x, i)
+	}
+}
+
+// Search 返回与query最相似的k个向量; HNSW索引在过滤后结果不足k个时回退为精确检索
+func (x *Index) Search(query []float32, k int, filter Filter) ([]Result, error) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	if k <= 0 || x.count == 0 {
+		return nil, nil
+	}
+
+	if len(query) != x.dimension {
+		return nil, fmt.Errorf("%w: query has %d dimensions, expected %d", ErrDimensionMismatch, len(query), x.dimension)
+	}
+
+	if x.metric == Cosine {
+		query = append([]float32(nil), query...)
+		normalize(query)
+	}
+
+	if x.graph != nil {
+		results := x.graph.search(x, query, k, filter)
+		if len(results) >= k || (filter == nil && len(results) == x.count) {
+			return results, nil
+		}
+	}
+	return x.exactSearch(query, k, filter), nil
+}
+
+// SearchEmbedding 使用float64向量检索
+func (x *Index) SearchEmbedding(query []float64, k int, filter Filter) ([]Result, error) {
+	vector := make([]float32, len(query))
+	for i, v := range query {
+		vector[i] = float32(v)
+	}
+	return x.Search(vector, k, filter)
+}
+
+func (x *Index) exactSearch(query []float32, k int, filter Filter) []Result {
+	top := &resultHeap{}
+	for i := range x.items {
+		if x.deleted[i] || (filter != nil && !filter(x.items[i].Metadata)) {
+			continue
+		}
+
+		score := x.score(query, x.items[i].Vector)
+		if top.Len() < k {
+			heap.Push(top, scored{index: i, score: score})
+		} else if score > (*top)[0].score {
+			(*top)[0] = scored{index: i, score: score}
+			heap.Fix(top, 0)
+		}
+	}
+	return x.results(*top)
+}
+
+func (x *Index) results(candidates []scored) []Result {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	results := make([]Result, len(candidates))
+	for i, candidate := range candidates {
+		item := x.items[candidate.index]
+		results[i] = Result{Id: item.Id, Score: candidate.score, Metadata: item.Metadata}
+	}
+	return results
+}
+
+func (x *Index) score(a, b []float32) float64 {
+	if x.metric == L2 {
+		var sum float64
+		for i := range a {
+			d := float64(a[i]) - float64(b[i])
+			sum += d * d
+		}
+		return -math.Sqrt(sum)
+	}
+
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func normalize(vector []float32) {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+
+	if sum == 0 {
+		return
+	}
+
+	norm := math.Sqrt(sum)
+	for i, v := range vector {
+		vector[i] = float32(float64(v) / norm)
+	}
+}
+
+// scored 候选向量及其相似度
+type scored struct {
+	index int
+	score float64
+}
+
+// resultHeap 按score的小顶堆, 用于保留最相似的k个结果
+type resultHeap []scored
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(scored)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// indexFile 持久化格式
+type indexFile struct {
+	Version   int
+	Metric    Metric
+	Dimension int
+	Items     []Item
+	Deleted   []bool
+	Graph     *hnswFile
+}
+
+// Save 以gob格式写入索引, HNSW索引同时保存图结构, 加载后无需重建
+func (x *Index) Save(writer io.Writer) error {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+
+	file := indexFile{Version: formatVersion, Metric: x.metric, Dimension: x.dimension, Items: x.items, Deleted: x.deleted}
+	if x.graph != nil {
+		file.Graph = x.graph.file()
+	}
+	return gob.NewEncoder(writer).Encode(&file)
+}
+
+// SaveFile 原子地写入文件
+func (x *Index) SaveFile(path string) error {
+	return fileutil.WriteFileAtomic(path, x.Save)
+}
+
+// Load 读取Save写入的索引
+func Load(reader io.Reader) (*Index, error) {
+	file := indexFile{}
+	if err := gob.NewDecoder(reader).Decode(&file); err != nil {
+		return nil, fmt.Errorf("Failed to decode index: %v", err)
+	}
+
+	if file.Version != formatVersion {
+		return nil, fmt.Errorf("Unsupported index version: %d", file.Version)
+	}
+
+	if len(file.Deleted) != len(file.Items) {
+		return nil, fmt.Errorf("Invalid index, items: %d, deleted: %d", len(file.Items), len(file.Deleted))
+	}
+
+	options := Options{Metric: file.Metric}
+	if file.Graph != nil {
+		options.HNSW = &file.Graph.Options
+	}
+
+	index, err := NewWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	index.dimension, index.items, index.deleted = file.Dimension, file.Items, file.Deleted
+	for i, item := range index.items {
+		if !index.deleted[i] {
+			index.ids[item.Id] = i
+			index.count++
+		}
+	}
+
+	if index.graph != nil {
+		if err = index.graph.load(file.Graph, len(index.items)); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// LoadFile 从文件读取索引
+func LoadFile(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Load(file)
+}
@@ -0,0 +1,245 @@
+/*
+ * All rights Reserved, Designed By Alibaba Group Inc.
+ * Copyright: Copyright(C) 1999-2023
+ * Company  : Alibaba Group Inc.
+
+ * @brief test cases and benchmarks for vector index
+ * @version 1.0.0
+ */
+
+package vectorindex_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aliyun/alibabacloud-bailian-go-sdk/vectorindex"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func randomVectors(n, dimension int, seed int64) [][]float32 {
+	random := rand.New(rand.NewSource(seed))
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dimension)
+		for j := range vectors[i] {
+			vectors[i][j] = float32(random.NormFloat64())
+		}
+	}
+	return vectors
+}
+
+func buildIndex(t testing.TB, options vectorindex.Options, vectors [][]float32) *vectorindex.Index {
+	index, err := vectorindex.NewWithOptions(options)
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	for i, vector := range vectors {
+		item := vectorindex.Item{Id: strconv.Itoa(i), Vector: vector, Metadata: map[string]string{"parity": strconv.Itoa(i % 2)}}
+		if err = index.Add(item); err != nil {
+			t.Fatalf("failed to add vector: %v", err)
+		}
+	}
+	return index
+}
+
+func TestIndexMetrics(t *testing.T) {
+	vectors := [][]float32{{1, 0}, {10, 1}, {0, 1}}
+	queries := map[vectorindex.Metric][]float32{
+		vectorindex.Cosine: {1, 0.01},
+		vectorindex.Dot:    {0.2, 0.9},
+		vectorindex.L2:     {0.2, 0.9},
+	}
+	expected := map[vectorindex.Metric]string{vectorindex.Cosine: "0", vectorindex.Dot: "1", vectorindex.L2: "2"}
+	for metric, query := range queries {
+		index := buildIndex(t, vectorindex.Options{Metric: metric}, vectors)
+		results, err := index.Search(query, 1, nil)
+		if err != nil || len(results) != 1 || results[0].Id != expected[metric] {
+			t.Errorf("unexpected results of %s: %v, err: %v", metric, results, err)
+		}
+	}
+
+	index := vectorindex.New(vectorindex.L2)
+	_ = index.Add(vectorindex.Item{Id: "a", Vector: []float32{0, 0}})
+	if err := index.Add(vectorindex.Item{Id: "b", Vector: []float32{0}}); !errors.Is(err, vectorindex.ErrDimensionMismatch) {
+		t.Errorf("expected dimension mismatch, got: %v", err)
+	}
+
+	if results, _ := index.SearchEmbedding([]float64{3, 4}, 1, nil); results[0].Score != -5 {
+		t.Errorf("unexpected l2 score: %v", results)
+	}
+
+	if _, err := vectorindex.NewWithOptions(vectorindex.Options{Metric: "manhattan"}); err == nil {
+		t.Errorf("expected unknown metric error")
+	}
+}
+
+func TestIndexFilterAndDelete(t *testing.T) {
+	query := randomVectors(1, 16, 2)[0]
+	for _, options := range []vectorindex.Options{{}, {HNSW: &vectorindex.HNSWOptions{Seed: 1}}} {
+		index := buildIndex(t, options, randomVectors(200, 16, 1))
+		index.Delete("4", "6", "not-exist")
+
+		results, err := index.Search(query, 10, vectorindex.Match(map[string]string{"parity": "0"}))
+		if err != nil || len(results) != 10 || index.Len() != 198 {
+			t.Fatalf("unexpected results: %d, len: %d, err: %v", len(results), index.Len(), err)
+		}
+
+		for i, result := range results {
+			if result.Metadata["parity"] != "0" || result.Id == "4" || result.Id == "6" {
+				t.Errorf("unexpected result: %v", result)
+			}
+
+			if i > 0 && result.Score > results[i-1].Score {
+				t.Errorf("results not sorted: %v", results)
+			}
+		}
+
+		//替换已存在的向量后检索到新的向量
+		_ = index.Add(vectorindex.Item{Id: "9", Vector: query, Metadata: map[string]string{"parity": "1"}})
+		results, _ = index.Search(query, 1, nil)
+		if len(results) != 1 || results[0].Id != "9" || results[0].Score < 0.999 || index.Len() != 198 {
+			t.Errorf("unexpected results after replace: %v, len: %d", results, index.Len())
+		}
+	}
+}
+
+func TestIndexPersistence(t *testing.T) {
+	vectors := randomVectors(300, 8, 3)
+	for _, options := range []vectorindex.Options{{Metric: vectorindex.Dot}, {HNSW: &vectorindex.HNSWOptions{M: 8}}} {
+		index := buildIndex(t, options, vectors)
+		index.Delete("1")
+
+		path := filepath.Join(t.TempDir(), "index.gob")
+		if err := index.SaveFile(path); err != nil {
+			t.Fatalf("failed to save index: %v", err)
+		}
+
+		loaded, err := vectorindex.LoadFile(path)
+		if err != nil {
+			t.Fatalf("failed to load index: %v", err)
+		}
+
+		if loaded.Len() != 299 || loaded.Metric() != index.Metric() || loaded.Dimension() != 8 {
+			t.Fatalf("unexpected loaded index, len: %d, metric: %s", loaded.Len(), loaded.Metric())
+		}
+
+		expected, _ := index.Search(vectors[7], 5, nil)
+		actual, _ := loaded.Search(vectors[7], 5, nil)
+		for i := range expected {
+			if actual[i].Id != expected[i].Id {
+				t.Errorf("unexpected results after load: %v, expected: %v", actual, expected)
+				break
+			}
+		}
+
+		//加载后可继续写入
+		if err = loaded.Add(vectorindex.Item{Id: "new", Vector: vectors[1]}); err != nil {
+			t.Errorf("failed to add after load: %v", err)
+		}
+	}
+}
+
+func TestHNSWCompaction(t *testing.T) {
+	vectors := randomVectors(200, 8, 5)
+	options := vectorindex.Options{HNSW: &vectorindex.HNSWOptions{Seed: 5}}
+	index := buildIndex(t, options, vectors[:50])
+
+	//持续删除和写入后, 已删除的节点被回收, 持久化的大小不随写入次数增长
+	for i := 50; i < len(vectors); i++ {
+		index.Delete(strconv.Itoa(i - 50))
+		if err := index.Add(vectorindex.Item{Id: strconv.Itoa(i), Vector: vectors[i]}); err != nil {
+			t.Fatalf("failed to add: %v", err)
+		}
+
+		//替换已有的向量同样产生删除的节点
+		if err := index.Add(vectorindex.Item{Id: strconv.Itoa(i), Vector: vectors[i]}); err != nil {
+			t.Fatalf("failed to replace: %v", err)
+		}
+	}
+
+	fresh := buildIndex(t, options, vectors[150:])
+	var churned, expected bytes.Buffer
+	if err := index.Save(&churned); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	_ = fresh.Save(&expected)
+
+	if index.Len() != 50 || churned.Len() > expected.Len()*3/2 {
+		t.Errorf("deleted nodes not reclaimed, len: %d, size: %d, expected: %d", index.Len(), churned.Len(), expected.Len())
+	}
+
+	for i := 150; i < len(vectors); i++ {
+		results, err := index.Search(vectors[i], 1, nil)
+		if err != nil || len(results) != 1 || results[0].Id != strconv.Itoa(i) {
+			t.Fatalf("unexpected results for %d: %v, err: %v", i, results, err)
+		}
+	}
+
+	index.Compact()
+	var compacted bytes.Buffer
+	_ = index.Save(&compacted)
+	if compacted.Len() > churned.Len() {
+		t.Errorf("unexpected compacted size: %d", compacted.Len())
+	}
+}
+
+func TestHNSWRecall(t *testing.T) {
+	vectors := randomVectors(2000, 32, 4)
+	exact := buildIndex(t, vectorindex.Options{}, vectors)
+	approximate := buildIndex(t, vectorindex.Options{HNSW: &vectorindex.HNSWOptions{Seed: 4}}, vectors)
+
+	hits, total := 0, 0
+	for _, query := range randomVectors(50, 32, 5) {
+		expected, _ := exact.Search(query, 10, nil)
+		actual, _ := approximate.Search(query, 10, nil)
+		ids := make(map[string]bool)
+		for _, result := range actual {
+			ids[result.Id] = true
+		}
+
+		for _, result := range expected {
+			total++
+			if ids[result.Id] {
+				hits++
+			}
+		}
+	}
+
+	if recall := float64(hits) / float64(total); recall < 0.9 {
+		t.Errorf("recall too low: %.3f", recall)
+	}
+}
+
+func benchmarkSearch(b *testing.B, options vectorindex.Options) {
+	index := buildIndex(b, options, randomVectors(10000, 128, 6))
+	queries := randomVectors(100, 128, 7)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := index.Search(queries[i%len(queries)], 10, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExactSearch(b *testing.B) {
+	benchmarkSearch(b, vectorindex.Options{})
+}
+
+func BenchmarkHNSWSearch(b *testing.B) {
+	benchmarkSearch(b, vectorindex.Options{HNSW: &vectorindex.HNSWOptions{}})
+}
+
+func BenchmarkHNSWAdd(b *testing.B) {
+	vectors := randomVectors(b.N, 128, 8)
+	index, _ := vectorindex.NewWithOptions(vectorindex.Options{HNSW: &vectorindex.HNSWOptions{}})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = index.Add(vectorindex.Item{Id: strconv.Itoa(i), Vector: vectors[i]})
+	}
+}